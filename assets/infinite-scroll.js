@@ -0,0 +1,61 @@
+// Infinite scrolling for paginated lists. The list is marked with
+// data-infinite-scroll and ends with a [data-load-more] element pointing at
+// the HTML fragment of the next page. When it scrolls into view the fragment
+// replaces it, bringing its own [data-load-more] element if there are more
+// pages. Without JavaScript the regular previous/next links keep working.
+(function() {
+  if(!("IntersectionObserver" in window)) {
+    return;
+  }
+
+  document.querySelectorAll("[data-infinite-scroll]").forEach(function(list) {
+    let pagination = list.parentElement.querySelector("[data-pagination]");
+    let loading = false;
+
+    let observer = new IntersectionObserver(function(entries) {
+      entries.forEach(function(entry) {
+        if(entry.isIntersecting) {
+          loadMore(entry.target);
+        }
+      });
+    }, {rootMargin: "400px"});
+
+    function watch() {
+      let marker = list.querySelector("[data-load-more]");
+      if(marker != null) {
+        observer.observe(marker);
+      }
+    }
+
+    function loadMore(marker) {
+      if(loading) {
+        return;
+      }
+      loading = true;
+      observer.unobserve(marker);
+      fetch(marker.dataset.loadMore, {credentials: "same-origin"}).then(function(resp) {
+        if(!resp.ok) {
+          throw new Error("unexpected status " + resp.status);
+        }
+        return resp.text();
+      }).then(function(html) {
+        let fragment = document.createElement("template");
+        fragment.innerHTML = html;
+        marker.replaceWith(fragment.content);
+        loading = false;
+        watch();
+      }).catch(function() {
+        // Fall back to the regular links
+        loading = false;
+        if(pagination != null) {
+          pagination.hidden = false;
+        }
+      });
+    }
+
+    if(pagination != null && list.querySelector("[data-load-more]") != null) {
+      pagination.hidden = true;
+    }
+    watch();
+  });
+})();
@@ -0,0 +1,49 @@
+// Client proofing. Selecting an image and saving a note post their forms in
+// the background so the client keeps their place in the gallery, the forms
+// are handled on the document so images added by infinite scrolling work
+// too. Without JavaScript the forms reload the gallery.
+(function() {
+  let count = document.querySelector("[data-selection-count]");
+
+  document.addEventListener("submit", function(event) {
+    let form = event.target;
+    if(!form.matches("[data-proofing]")) {
+      return;
+    }
+    event.preventDefault();
+
+    let body = new URLSearchParams(new FormData(form));
+    fetch(form.action, {
+      method: "POST",
+      credentials: "same-origin",
+      headers: {"X-Requested-With": "XMLHttpRequest"},
+      body: body,
+    }).then(function(resp) {
+      if(!resp.ok) {
+        return resp.text().then(function(msg) {
+          alert(msg);
+          window.location.reload();
+        });
+      }
+      if(form.dataset.proofing === "heart") {
+        toggle(form, body.get("selected") === "on");
+      }
+    });
+  });
+
+  function toggle(form, selected) {
+    let image = form.closest("[data-proofing-image]");
+    form.querySelector("input[name='selected']").value = selected ? "" : "on";
+    form.querySelector("button").setAttribute("aria-pressed", selected);
+    form.querySelector("[data-heart-label]").textContent = selected ? "♥ Selected" : "♡ Select";
+    let note = image.querySelector("[data-proofing-note]");
+    note.hidden = !selected;
+    if(!selected) {
+      // Unselecting an image drops its note
+      note.querySelector("input[name='note']").value = "";
+    }
+    if(count != null) {
+      count.textContent = parseInt(count.textContent, 10) + (selected ? 1 : -1);
+    }
+  }
+})();
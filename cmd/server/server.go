@@ -1,26 +1,58 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/csrf"
+	"github.com/gorilla/securecookie"
 	"github.com/joho/godotenv"
 	"github.com/rahulbalajee/lenslocked/controllers"
+	"github.com/rahulbalajee/lenslocked/metrics"
 	"github.com/rahulbalajee/lenslocked/migrations"
 	"github.com/rahulbalajee/lenslocked/models"
 	"github.com/rahulbalajee/lenslocked/templates"
+	"github.com/rahulbalajee/lenslocked/urls"
 	"github.com/rahulbalajee/lenslocked/views"
-	"golang.org/x/oauth2"
 )
 
 type config struct {
-	PSQL models.PostgresConfig
-	SMTP models.SMTPConfig
+	// Env is either "development" or "production"
+	Env   string
+	PSQL  models.PostgresConfig
+	SMTP  models.SMTPConfig
+	Email struct {
+		From       string
+		ReplyTo    string
+		ReturnPath string
+		// Domain is used for Message-IDs, defaults to the BASE_URL host
+		Domain string
+		// Transport is one of smtp, file or memory
+		Transport string
+		// Maildir is where the file transport delivers emails
+		Maildir string
+	}
+	// DKIM signing is enabled when a private key file is set
+	DKIM struct {
+		Domain         string
+		Selector       string
+		PrivateKeyFile string
+	}
 	CSRF struct {
 		Key            string
 		Secure         bool
@@ -28,8 +60,32 @@ type config struct {
 	}
 	Server struct {
 		Address string
+		// URLs builds absolute links on BASE_URL, the public URL of the app
+		URLs *urls.Builder
+		// AdminAddress is where /metrics is served, it should not be
+		// reachable from the internet. Disabled when empty.
+		AdminAddress string
+		// TrustProxy reads visitor addresses from X-Forwarded-For, only
+		// turn it on behind a proxy that overwrites the header
+		TrustProxy bool
+
+		ReadTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		// ShutdownTimeout is how long in-flight requests get to finish on SIGTERM
+		ShutdownTimeout time.Duration
 	}
-	OAuthProviders map[string]*oauth2.Config
+	Log struct {
+		// Format is json or text, defaults to json in production and text in development
+		Format string
+		Level  slog.Level
+	}
+	// TrashRetention is how long deleted galleries and images can be restored
+	TrashRetention time.Duration
+	CloudProviders map[string]models.CloudConfig
+	// CookieHashKey signs cookies that must not be forged, eg. unlocked galleries
+	CookieHashKey string
 }
 
 func loadEnvConfig() (config, error) {
@@ -40,6 +96,31 @@ func loadEnvConfig() (config, error) {
 		return cfg, err
 	}
 
+	cfg.Env = os.Getenv("APP_ENV")
+	if cfg.Env == "" {
+		cfg.Env = "production"
+	}
+	if cfg.Env != "development" && cfg.Env != "production" {
+		return cfg, fmt.Errorf("invalid APP_ENV %q", cfg.Env)
+	}
+
+	cfg.Log.Format = os.Getenv("LOG_FORMAT")
+	if cfg.Log.Format == "" {
+		cfg.Log.Format = "json"
+		if cfg.Env == "development" {
+			cfg.Log.Format = "text"
+		}
+	}
+	if cfg.Log.Format != "json" && cfg.Log.Format != "text" {
+		return cfg, fmt.Errorf("invalid LOG_FORMAT %q", cfg.Log.Format)
+	}
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		err = cfg.Log.Level.UnmarshalText([]byte(level))
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+	}
+
 	cfg.PSQL = models.PostgresConfig{
 		Host:     os.Getenv("PSQL_HOST"),
 		Port:     os.Getenv("PSQL_PORT"),
@@ -52,13 +133,32 @@ func loadEnvConfig() (config, error) {
 		return cfg, fmt.Errorf("no PSQL config provided")
 	}
 
-	cfg.SMTP.Host = os.Getenv("SMTP_HOST")
-	cfg.SMTP.Port, err = strconv.Atoi(os.Getenv("SMTP_PORT"))
-	if err != nil {
-		return cfg, err
+	cfg.Email.Transport = os.Getenv("EMAIL_TRANSPORT")
+	if cfg.Email.Transport == "" {
+		cfg.Email.Transport = "smtp"
+	}
+	switch cfg.Email.Transport {
+	case "smtp":
+		cfg.SMTP.Host = os.Getenv("SMTP_HOST")
+		cfg.SMTP.Port, err = strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			return cfg, err
+		}
+		cfg.SMTP.Username = os.Getenv("SMTP_USERNAME")
+		cfg.SMTP.Password = os.Getenv("SMTP_PASSWORD")
+	case "file":
+		cfg.Email.Maildir = os.Getenv("EMAIL_MAILDIR")
+		if cfg.Email.Maildir == "" {
+			cfg.Email.Maildir = "maildir"
+		}
+	case "memory":
+		// Emails are dropped once the process exits, never lose real mail
+		if cfg.Env != "development" {
+			return cfg, fmt.Errorf("EMAIL_TRANSPORT memory is only allowed with APP_ENV development")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid EMAIL_TRANSPORT %q", cfg.Email.Transport)
 	}
-	cfg.SMTP.Username = os.Getenv("SMTP_USERNAME")
-	cfg.SMTP.Password = os.Getenv("SMTP_PASSWORD")
 
 	cfg.CSRF.Key = os.Getenv("CSRF_KEY")
 	cfg.CSRF.Secure, err = strconv.ParseBool(os.Getenv("CSRF_SECURE"))
@@ -66,43 +166,170 @@ func loadEnvConfig() (config, error) {
 		return cfg, err
 	}
 	// Parse CSRF trusted origins from comma-separated string
-	trustedOrigins := os.Getenv("CSRF_TRUSTED_ORIGINS")
-	origins := strings.Split(trustedOrigins, ",")
-	for i := range origins {
-		origins[i] = strings.TrimSpace(origins[i])
+	cfg.CSRF.TrustedOrigins = splitList(os.Getenv("CSRF_TRUSTED_ORIGINS"))
+
+	cfg.CookieHashKey = os.Getenv("COOKIE_HASH_KEY")
+	if cfg.CookieHashKey == "" {
+		return cfg, fmt.Errorf("COOKIE_HASH_KEY is required")
 	}
-	cfg.CSRF.TrustedOrigins = origins
 
 	cfg.Server.Address = os.Getenv("SERVER_ADDRESS")
+	cfg.Server.AdminAddress = os.Getenv("ADMIN_ADDRESS")
+	if value := os.Getenv("TRUST_PROXY"); value != "" {
+		cfg.Server.TrustProxy, err = strconv.ParseBool(value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid TRUST_PROXY: %w", err)
+		}
+	}
 
-	cfg.OAuthProviders = make(map[string]*oauth2.Config)
-	dbxConfig := &oauth2.Config{
-		ClientID:     os.Getenv("DROPBOX_APP_ID"),
-		ClientSecret: os.Getenv("DROPBOX_APP_SECRET"),
-		Scopes:       []string{"files.metadata.read", "files.content.read"},
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  "https://www.dropbox.com/oauth2/authorize",
-			TokenURL: "https://api.dropboxapi.com/oauth2/token",
-		},
+	// Uploads can take a while on slow connections, hence the generous
+	// read/write defaults
+	timeouts := []struct {
+		env          string
+		dst          *time.Duration
+		defaultValue time.Duration
+	}{
+		{"SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout, 5 * time.Minute},
+		{"SERVER_READ_HEADER_TIMEOUT", &cfg.Server.ReadHeaderTimeout, 10 * time.Second},
+		{"SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout, 5 * time.Minute},
+		{"SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout, 2 * time.Minute},
+		{"SERVER_SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout, 30 * time.Second},
+	}
+	for _, t := range timeouts {
+		*t.dst = t.defaultValue
+		if value := os.Getenv(t.env); value != "" {
+			*t.dst, err = time.ParseDuration(value)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", t.env, err)
+			}
+		}
+	}
+
+	cfg.TrashRetention = models.DefaultTrashRetention
+	if value := os.Getenv("TRASH_RETENTION"); value != "" {
+		cfg.TrashRetention, err = time.ParseDuration(value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid TRASH_RETENTION: %w", err)
+		}
+	}
+
+	// BASE_URL is the public URL every absolute link we generate points to,
+	// validate it here so a typo fails at startup instead of in an email
+	cfg.Server.URLs, err = urls.New(os.Getenv("BASE_URL"))
+	if err != nil {
+		return cfg, err
+	}
+	// Requests coming from our own public host are always trusted
+	if !slices.Contains(cfg.CSRF.TrustedOrigins, cfg.Server.URLs.Host()) {
+		cfg.CSRF.TrustedOrigins = append(cfg.CSRF.TrustedOrigins, cfg.Server.URLs.Host())
+	}
+
+	cfg.Email.From = os.Getenv("EMAIL_FROM")
+	if cfg.Email.From == "" {
+		cfg.Email.From = fmt.Sprintf("Lenslocked Support <support@%s>", cfg.Server.URLs.Hostname())
+	}
+	cfg.Email.ReplyTo = os.Getenv("EMAIL_REPLY_TO")
+	cfg.Email.ReturnPath = os.Getenv("EMAIL_RETURN_PATH")
+	cfg.Email.Domain = os.Getenv("EMAIL_DOMAIN")
+	if cfg.Email.Domain == "" {
+		cfg.Email.Domain = cfg.Server.URLs.Hostname()
+	}
+
+	cfg.DKIM.PrivateKeyFile = os.Getenv("DKIM_PRIVATE_KEY_FILE")
+	if cfg.DKIM.PrivateKeyFile != "" {
+		cfg.DKIM.Selector = os.Getenv("DKIM_SELECTOR")
+		cfg.DKIM.Domain = os.Getenv("DKIM_DOMAIN")
+		if cfg.DKIM.Domain == "" {
+			cfg.DKIM.Domain = cfg.Email.Domain
+		}
+		if cfg.DKIM.Selector == "" {
+			return cfg, fmt.Errorf("DKIM_SELECTOR is required when DKIM_PRIVATE_KEY_FILE is set")
+		}
+	}
+
+	// Cloud import providers are enabled by CLOUD_PROVIDERS_FILE, a JSON file
+	// keeping the secrets out of the environment, and by CLOUD_PROVIDERS. Env
+	// vars prefixed with a provider's name, eg. DROPBOX_APP_ID, override its
+	// settings from the file. Endpoints are optional and default per provider.
+	cfg.CloudProviders = make(map[string]models.CloudConfig)
+	if path := os.Getenv("CLOUD_PROVIDERS_FILE"); path != "" {
+		cfg.CloudProviders, err = models.ReadCloudConfigFile(path)
+		if err != nil {
+			return cfg, err
+		}
+	}
+	names := slices.Collect(maps.Keys(cfg.CloudProviders))
+	names = append(names, splitList(os.Getenv("CLOUD_PROVIDERS"))...)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		prefix := strings.ToUpper(name) + "_"
+		providerCfg := cfg.CloudProviders[name]
+		providerCfg.ClientID = envOr(prefix+"APP_ID", providerCfg.ClientID)
+		providerCfg.ClientSecret = envOr(prefix+"APP_SECRET", providerCfg.ClientSecret)
+		if scopes := splitList(os.Getenv(prefix + "SCOPES")); scopes != nil {
+			providerCfg.Scopes = scopes
+		}
+		providerCfg.AuthURL = envOr(prefix+"AUTH_URL", providerCfg.AuthURL)
+		providerCfg.TokenURL = envOr(prefix+"TOKEN_URL", providerCfg.TokenURL)
+		providerCfg.APIURL = envOr(prefix+"API_URL", providerCfg.APIURL)
+		providerCfg.ContentURL = envOr(prefix+"CONTENT_URL", providerCfg.ContentURL)
+		cfg.CloudProviders[name] = providerCfg
 	}
-	cfg.OAuthProviders["dropbox"] = dbxConfig
 
 	return cfg, nil
 }
 
+// envOr returns the env var key, or fallback when it isn't set
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// splitList parses a comma-separated env var, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func newLogger(cfg config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.Log.Level}
+	if cfg.Log.Format == "text" {
+		return slog.New(slog.NewTextHandler(os.Stdout, opts))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, opts))
+}
+
 func main() {
 	cfg, err := loadEnvConfig()
 	if err != nil {
 		panic(err)
 	}
 
-	err = run(cfg)
+	// ctx is cancelled on SIGTERM (docker stop) or Ctrl+C, which triggers a
+	// graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = run(ctx, cfg)
 	if err != nil {
-		panic(err)
+		slog.Error("server failed", "err", err)
+		os.Exit(1)
 	}
 }
 
-func run(cfg config) error {
+func run(ctx context.Context, cfg config) error {
+	logger := newLogger(cfg)
+	// Background jobs in the models package log through the default logger
+	slog.SetDefault(logger)
+
 	// Initiate DB connection and close it later when function exits
 	db, err := models.Open(cfg.PSQL)
 	if err != nil {
@@ -110,12 +337,31 @@ func run(cfg config) error {
 	}
 	defer db.Close()
 
+	// sql.Open doesn't connect, make sure the database is reachable before
+	// anything else so a misconfiguration fails fast with a clear message
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	err = db.PingContext(pingCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("startup check: database %s:%s unreachable: %w", cfg.PSQL.Host, cfg.PSQL.Port, err)
+	}
+
 	// Run DB migrations automatically at startup
 	err = models.MigrateFS(db, migrations.FS, ".")
 	if err != nil {
 		return err
 	}
 
+	// Background workers run until shutdown, we wait for them before exiting
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+
+	err = metrics.RegisterDB(db)
+	if err != nil {
+		return err
+	}
+
 	// Dependency injection (passing in the PostgreSQL DB)
 	// userService for creating and managing users
 	userService := &models.UserService{
@@ -128,33 +374,172 @@ func run(cfg config) error {
 		DB: db,
 	}
 
+	// Prometheus scrapes every 15s to 1m, a minute old count is plenty
+	err = metrics.RegisterActiveSessions(sessionService.Count, time.Minute)
+	if err != nil {
+		return err
+	}
+
 	// Dependency injection (passing in the PostgreSQL DB)
 	// passwordResetService for creating and managing password resets for users
 	passwordResetService := &models.PasswordResetService{
 		DB: db,
 	}
 
+	// shareLinkService for links giving visitors access to a gallery
+	shareLinkService := &models.ShareLinkService{
+		DB: db,
+	}
+
+	// collaboratorService for gallery roles and invitations
+	collaboratorService := &models.CollaboratorService{
+		DB: db,
+	}
+
+	// transferService for handing galleries over to another account
+	transferService := &models.TransferService{
+		DB: db,
+	}
+
+	// selectionService for the images clients pick while proofing a gallery
+	selectionService := &models.SelectionService{
+		DB: db,
+	}
+
+	// commentService for comments on galleries and their images
+	commentService := &models.CommentService{
+		DB: db,
+	}
+
+	// workspaceService for the workspaces galleries belong to
+	workspaceService := &models.WorkspaceService{
+		DB: db,
+	}
+
+	// emailTransport delivers the emails queued in the outbox
+	var emailTransport models.EmailTransport
+	switch cfg.Email.Transport {
+	case "smtp":
+		emailTransport = models.NewSMTPTransport(cfg.SMTP)
+	case "file":
+		emailTransport = &models.FileTransport{Dir: cfg.Email.Maildir}
+	case "memory":
+		emailTransport = &models.MemoryTransport{}
+	}
+
 	// emailService for sending emails to users
-	emailService := models.NewEmailService(cfg.SMTP)
+	emailService := models.NewEmailService(db, emailTransport)
+	emailService.DefaultSender = cfg.Email.From
+	emailService.DefaultReplyTo = cfg.Email.ReplyTo
+	emailService.ReturnPath = cfg.Email.ReturnPath
+	emailService.Domain = cfg.Email.Domain
+	if cfg.DKIM.PrivateKeyFile != "" {
+		keyPEM, err := os.ReadFile(cfg.DKIM.PrivateKeyFile)
+		if err != nil {
+			return fmt.Errorf("read dkim key: %w", err)
+		}
+		key, err := models.ParseDKIMKey(keyPEM)
+		if err != nil {
+			return err
+		}
+		emailService.DKIM = &models.DKIMConfig{
+			Domain:     cfg.DKIM.Domain,
+			Selector:   cfg.DKIM.Selector,
+			PrivateKey: key,
+		}
+	}
+	emailService.Templates.ForgotPassword = views.MustEmail(views.ParseEmailFS(
+		templates.FS,
+		"forgot-password",
+	))
+	emailService.Templates.GalleryInvitation = views.MustEmail(views.ParseEmailFS(
+		templates.FS,
+		"gallery-invitation",
+	))
+	emailService.Templates.GalleryTransfer = views.MustEmail(views.ParseEmailFS(
+		templates.FS,
+		"gallery-transfer",
+	))
+	emailService.Templates.GalleryTransferred = views.MustEmail(views.ParseEmailFS(
+		templates.FS,
+		"gallery-transferred",
+	))
+	emailService.Templates.SelectionSubmitted = views.MustEmail(views.ParseEmailFS(
+		templates.FS,
+		"selection-submitted",
+	))
+	emailService.Templates.CommentPosted = views.MustEmail(views.ParseEmailFS(
+		templates.FS,
+		"comment-posted",
+	))
 
-	imageService := &models.ImageService{}
+	// Deliver queued emails in the background
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		emailService.RunOutbox(workerCtx, 5*time.Second)
+	}()
+
+	// urlBuilder generates every absolute link we hand out, eg. in emails
+	urlBuilder := cfg.Server.URLs
+
+	imageService := &models.ImageService{
+		DB: db,
+	}
+
+	healthC := controllers.Health{
+		Checks: []controllers.HealthCheck{
+			{Name: "database", Check: db.PingContext},
+			{Name: "migrations", Check: func(ctx context.Context) error {
+				return models.CheckMigrations(ctx, db, migrations.FS)
+			}},
+			{Name: "storage", Check: func(ctx context.Context) error {
+				return imageService.CheckStorage()
+			}},
+		},
+	}
+	for name, err := range healthC.Run(ctx) {
+		if err != nil {
+			return fmt.Errorf("startup check %s: %w", name, err)
+		}
+	}
 
 	galleryService := &models.GalleryService{
 		DB:           db,
 		ImageService: imageService,
 	}
 
+	// Remove the images of deleted galleries once their transaction commits
+	// and purge the trash
+	cleanupService := &models.CleanupService{
+		DB:             db,
+		GalleryService: galleryService,
+		ImageService:   imageService,
+		TrashRetention: cfg.TrashRetention,
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		cleanupService.Run(workerCtx, 30*time.Second)
+	}()
+
 	// Setup User middleware
 	umw := controllers.UserMiddleware{
 		SessionService: sessionService,
 	}
 
+	// Setup Workspace middleware
+	wmw := controllers.WorkspaceMiddleware{
+		WorkspaceService: workspaceService,
+	}
+
 	// Setup CSRF middleware
 	csrfMw := csrf.Protect(
 		[]byte(cfg.CSRF.Key),
 		csrf.Secure(cfg.CSRF.Secure),
 		csrf.Path("/"),
 		csrf.TrustedOrigins(cfg.CSRF.TrustedOrigins),
+		csrf.ErrorHandler(http.HandlerFunc(controllers.CSRFFailure)),
 	)
 
 	// Adapting REST and using it's own controllers for User related endpoints plumbing UserService and SessionService
@@ -164,6 +549,7 @@ func run(cfg config) error {
 		SessionService:       sessionService,
 		PasswordResetService: passwordResetService,
 		EmailService:         emailService,
+		URLs:                 urlBuilder,
 	}
 
 	// Plumbing work to make sure the Templates in users controller are populated with right values before routing happens
@@ -198,9 +584,30 @@ func run(cfg config) error {
 		"tailwind.gohtml",
 	))
 
+	// Unsubscribe links must keep working in old emails, so they don't expire
+	unsubscribeTokens := securecookie.New([]byte(cfg.CookieHashKey), nil).MaxAge(0)
+
 	galleriesC := controllers.Galleries{
-		GalleryService: galleryService,
-		ImageService:   imageService,
+		GalleryService:      galleryService,
+		ImageService:        imageService,
+		ShareLinkService:    shareLinkService,
+		CollaboratorService: collaboratorService,
+		TransferService:     transferService,
+		SelectionService:    selectionService,
+		CommentService:      commentService,
+		EmailService:        emailService,
+		URLs:                urlBuilder,
+		Unlocks:             securecookie.New([]byte(cfg.CookieHashKey), nil),
+		UnsubscribeTokens:   unsubscribeTokens,
+		// 10 password guesses per visitor and gallery every 15 minutes, 50
+		// per gallery from everyone
+		UnlockLimiter:        controllers.NewRateLimiter(10, 15*time.Minute),
+		GalleryUnlockLimiter: controllers.NewRateLimiter(50, 15*time.Minute),
+		// 5 comments per visitor and gallery every 10 minutes, 30 per
+		// gallery from everyone
+		CommentLimiter:        controllers.NewRateLimiter(5, 10*time.Minute),
+		GalleryCommentLimiter: controllers.NewRateLimiter(30, 10*time.Minute),
+		TrustProxy:            cfg.Server.TrustProxy,
 	}
 
 	galleriesC.Template.New = views.Must(views.ParseFS(
@@ -217,28 +624,127 @@ func run(cfg config) error {
 		templates.FS,
 		"galleries/index.gohtml",
 		"tailwind.gohtml",
+		"galleries/gallery-cards.gohtml",
+		"pagination.gohtml",
 	))
 	galleriesC.Template.Show = views.Must(views.ParseFS(
 		templates.FS,
 		"galleries/show.gohtml",
 		"tailwind.gohtml",
+		"galleries/image-items.gohtml",
+		"galleries/comments.gohtml",
+		"pagination.gohtml",
 	))
 	galleriesC.Template.ShowToAll = views.Must(views.ParseFS(
 		templates.FS,
 		"galleries/showtoall.gohtml",
 		"tailwind.gohtml",
+		"galleries/image-items.gohtml",
+		"galleries/comments.gohtml",
+		"pagination.gohtml",
+	))
+	galleriesC.Template.ImageItems = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/image-items-page.gohtml",
+		"galleries/image-items.gohtml",
+		"galleries/comments.gohtml",
+		"pagination.gohtml",
+	))
+	galleriesC.Template.GalleryCards = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/gallery-cards-page.gohtml",
+		"galleries/gallery-cards.gohtml",
+		"pagination.gohtml",
+	))
+	galleriesC.Template.Trash = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/trash.gohtml",
+		"tailwind.gohtml",
+	))
+	galleriesC.Template.ShareLink = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/share-link.gohtml",
+		"tailwind.gohtml",
+	))
+	galleriesC.Template.Unlock = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/unlock.gohtml",
+	))
+
+	galleriesC.Template.Transfer = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/transfer.gohtml",
+		"tailwind.gohtml",
+	))
+	galleriesC.Template.Import = views.Must(views.ParseFS(
+		templates.FS,
+		"galleries/import.gohtml",
+		"tailwind.gohtml",
 	))
 
+	adminC := controllers.Admin{
+		GalleryService:  galleryService,
+		TransferService: transferService,
+		EmailService:    emailService,
+		URLs:            urlBuilder,
+	}
+	adminC.Template.Transfer = views.Must(views.ParseFS(
+		templates.FS,
+		"admin/transfer.gohtml",
+		"tailwind.gohtml",
+	))
+
+	unsubscribeC := controllers.Unsubscribe{
+		EmailService: emailService,
+		Tokens:       unsubscribeTokens,
+	}
+	unsubscribeC.Template.Unsubscribe = views.Must(views.ParseFS(
+		templates.FS,
+		"unsubscribe.gohtml",
+		"tailwind.gohtml",
+	))
+
+	workspacesC := controllers.Workspaces{
+		WorkspaceService: workspaceService,
+	}
+	workspacesC.Template.Index = views.Must(views.ParseFS(
+		templates.FS,
+		"workspaces/index.gohtml",
+		"tailwind.gohtml",
+	))
+	workspacesC.Template.Show = views.Must(views.ParseFS(
+		templates.FS,
+		"workspaces/show.gohtml",
+		"tailwind.gohtml",
+	))
+
+	cloudProviders := make(map[string]models.CloudProvider)
+	for name, providerCfg := range cfg.CloudProviders {
+		provider, err := models.NewCloudProvider(name, providerCfg)
+		if err != nil {
+			return err
+		}
+		cloudProviders[name] = provider
+	}
+
 	oauthC := controllers.OAuth{
-		ProviderConfigs: cfg.OAuthProviders,
+		Providers: cloudProviders,
+		URLs:      urlBuilder,
 	}
 
 	// Create new Chi router
 	r := chi.NewRouter()
 
 	// Apply middlewares that are required for all routes to Chi router we just created
+	r.Use(middleware.RequestID)
+	r.Use(controllers.Metrics)
+	r.Use(controllers.RequestLogger(logger))
+	r.Use(controllers.AccessLog)
+	// Mail clients unsubscribe with a POST that can't have a CSRF token
+	r.Use(controllers.SkipCSRF("/unsubscribe/"))
 	r.Use(csrfMw)
 	r.Use(umw.SetUser)
+	r.Use(wmw.SetWorkspaces)
 
 	tmpl := views.Must(views.ParseFS(
 		templates.FS,
@@ -288,6 +794,32 @@ func run(cfg config) error {
 	// TODO: put this logic into /users/me
 	r.With(umw.RequireUser).Post("/update-email", usersC.UpdateEmail)
 
+	r.Get("/share/{token}", galleriesC.VisitShareLink)
+	r.With(umw.RequireUser).Get("/invitations/{token}", galleriesC.AcceptInvitation)
+	r.With(umw.RequireUser).Get("/transfers/{token}", galleriesC.ShowTransfer)
+	r.With(umw.RequireUser).Post("/transfers/{token}", galleriesC.AcceptTransfer)
+
+	r.Get("/unsubscribe/{token}", unsubscribeC.Show)
+	r.Post("/unsubscribe/{token}", unsubscribeC.Process)
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(umw.RequireUser, umw.RequireAdmin)
+		r.Get("/transfer", adminC.Transfer)
+		r.Post("/transfer", adminC.ProcessTransfer)
+	})
+
+	r.Route("/workspaces", func(r chi.Router) {
+		r.Use(umw.RequireUser)
+		r.Get("/", workspacesC.Index)
+		r.Post("/", workspacesC.Create)
+		r.Post("/switch", workspacesC.Switch)
+		r.Get("/{id}", workspacesC.Show)
+		r.Post("/{id}", workspacesC.Rename)
+		r.Post("/{id}/members", workspacesC.AddMember)
+		r.Post("/{id}/members/{userID}", workspacesC.UpdateMember)
+		r.Post("/{id}/members/{userID}/remove", workspacesC.RemoveMember)
+	})
+
 	r.Route("/galleries", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Use(umw.RequireUser)
@@ -295,15 +827,52 @@ func run(cfg config) error {
 			r.Post("/", galleriesC.ProcessNew)
 			r.Get("/{id}/edit", galleriesC.Edit)
 			r.Post("/{id}", galleriesC.ProcessEdit)
+			r.Post("/{id}/slug", galleriesC.ResetSlug)
+			r.Post("/{id}/password", galleriesC.SetPassword)
 			r.Get("/", galleriesC.Index)
 			r.Post("/{id}/delete", galleriesC.Delete)
-			r.Get("/{id}", galleriesC.Show)
+			r.Post("/{id}/share-links", galleriesC.CreateShareLink)
+			r.Post("/{id}/share-links/{linkID}/revoke", galleriesC.RevokeShareLink)
+			r.Post("/{id}/collaborators", galleriesC.InviteCollaborator)
+			r.Post("/{id}/collaborators/{userID}", galleriesC.UpdateCollaborator)
+			r.Post("/{id}/collaborators/{userID}/remove", galleriesC.RemoveCollaborator)
+			r.Post("/{id}/invitations/{invitationID}/revoke", galleriesC.RevokeInvitation)
+			r.Post("/{id}/transfer", galleriesC.TransferGallery)
+			r.Post("/{id}/transfer/cancel", galleriesC.CancelTransfer)
+			r.Get("/{id}/selections/{selectionID}/export", galleriesC.ExportSelection)
+			r.Post("/{id}/comments/{commentID}/approve", galleriesC.ApproveComment)
+			r.Post("/{id}/comments/{commentID}/hide", galleriesC.HideComment)
+			r.Post("/{id}/comments/{commentID}/delete", galleriesC.DeleteComment)
 			r.Post("/{id}/images/{filename}/delete", galleriesC.DeleteImage)
 			r.Post("/{id}/images", galleriesC.UploadImage)
 			r.Post("/{id}/images/url", galleriesC.ImageViaURL)
+			r.Post("/{id}/images/import", galleriesC.ImportImages)
+			r.Post("/{id}/images/order", galleriesC.ReorderImages)
+			r.Post("/{id}/images/details", galleriesC.BulkUpdateImageDetails)
+			r.Post("/{id}/images/{filename}/details", galleriesC.UpdateImageDetails)
+			r.Get("/{id}/trash/{filename}", galleriesC.TrashedImage)
+			r.Post("/{id}/trash/{filename}/restore", galleriesC.RestoreImage)
+			r.Post("/{id}/trash/{filename}/delete", galleriesC.DeleteImageForever)
+			r.Get("/trash", galleriesC.Trash)
+			r.Post("/trash/{id}/restore", galleriesC.Restore)
+			r.Post("/trash/{id}/delete", galleriesC.DeleteForever)
 		})
-		r.Get("/g/{id}", galleriesC.ShowToAll)
+		// Show is also open to visitors with a viewer session from a share link
+		r.Get("/{id}", galleriesC.Show)
+		r.Get("/g/{slug}", galleriesC.ShowToAll)
+		r.Post("/g/{slug}/unlock", galleriesC.Unlock)
+		r.Get("/g/{slug}/images/{filename}", galleriesC.SharedImage)
 		r.Get("/{id}/images/{filename}", galleriesC.Image)
+		r.Get("/{id}/download", galleriesC.DownloadGallery)
+		// Clients proofing the gallery through a share link or its shared link
+		r.Post("/{id}/selection", galleriesC.StartSelection)
+		r.Post("/{id}/selection/images/{filename}", galleriesC.SelectImage)
+		r.Post("/{id}/selection/submit", galleriesC.SubmitSelection)
+		r.Post("/g/{slug}/selection", galleriesC.StartSelection)
+		r.Post("/g/{slug}/selection/images/{filename}", galleriesC.SelectImage)
+		r.Post("/g/{slug}/selection/submit", galleriesC.SubmitSelection)
+		r.Post("/{id}/comments", galleriesC.PostComment)
+		r.Post("/g/{slug}/comments", galleriesC.PostComment)
 	})
 
 	assetsHandler := http.FileServer(http.Dir("assets"))
@@ -315,11 +884,145 @@ func run(cfg config) error {
 		r.Get("/callback", oauthC.Callback)
 	})
 
+	// Email previews use sample data and must never be reachable in production
+	if cfg.Env == "development" {
+		previewsC := controllers.EmailPreviews{
+			Previews: map[string]controllers.EmailPreview{
+				"forgot-password": {
+					Template: emailService.Templates.ForgotPassword,
+					Data: models.ForgotPasswordEmail{
+						ResetURL: urlBuilder.URL("/reset-pw", url.Values{"token": {"preview-token"}}),
+					},
+				},
+				"gallery-invitation": {
+					Template: emailService.Templates.GalleryInvitation,
+					Data: models.GalleryInvitationEmail{
+						InvitedBy:    "jon@example.com",
+						GalleryTitle: "Summer Wedding",
+						Role:         models.RoleContributor.Label(),
+						AcceptURL:    urlBuilder.URL("/invitations/preview-token", nil),
+						ExpiresAt:    time.Now().Add(models.DefaultInvitationDuration),
+					},
+				},
+				"gallery-transfer": {
+					Template: emailService.Templates.GalleryTransfer,
+					Data: models.GalleryTransferEmail{
+						From:         "jon@example.com",
+						GalleryTitle: "Summer Wedding",
+						AcceptURL:    urlBuilder.URL("/transfers/preview-token", nil),
+						ExpiresAt:    time.Now().Add(models.DefaultTransferDuration),
+					},
+				},
+				"gallery-transferred": {
+					Template: emailService.Templates.GalleryTransferred,
+					Data: models.GalleryTransferredEmail{
+						From:         "jon@example.com",
+						To:           "jane@example.com",
+						GalleryTitle: "Summer Wedding",
+						GalleryURL:   urlBuilder.URL("/galleries/1", nil),
+						Recipient:    true,
+					},
+				},
+				"selection-submitted": {
+					Template: emailService.Templates.SelectionSubmitted,
+					Data: models.SelectionSubmittedEmail{
+						ClientName:     "Jane",
+						GalleryTitle:   "Summer Wedding",
+						ShareLinkLabel: "Bride's family",
+						Count:          24,
+						SelectionsURL:  urlBuilder.URL("/galleries/1/edit", nil),
+						UnsubscribeURL: urlBuilder.URL("/unsubscribe/preview-token", nil),
+					},
+				},
+				"comment-posted": {
+					Template: emailService.Templates.CommentPosted,
+					Data: models.CommentPostedEmail{
+						AuthorName:     "Jane",
+						Body:           "These came out beautifully!\nCould we get a print of this one?",
+						GalleryTitle:   "Summer Wedding",
+						Filename:       "IMG_0042.jpg",
+						Pending:        true,
+						CommentsURL:    urlBuilder.URL("/galleries/1/edit", nil),
+						UnsubscribeURL: urlBuilder.URL("/unsubscribe/preview-token", nil),
+					},
+				},
+			},
+		}
+		r.Get("/dev/emails", previewsC.Index)
+		r.Get("/dev/emails/{name}", previewsC.Show)
+	}
+
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Page not found", http.StatusNotFound)
 	})
 
-	// Start the server
-	fmt.Printf("Starting server on %s...\n", cfg.Server.Address)
-	return http.ListenAndServe(cfg.Server.Address, r)
+	// Probes live outside of the app router so they skip every middleware,
+	// staying cheap and out of the access logs
+	root := chi.NewRouter()
+	root.Get("/healthz", healthC.Live)
+	root.Get("/readyz", healthC.Ready)
+	root.Mount("/", r)
+
+	errLog := slog.NewLogLogger(logger.Handler(), slog.LevelError)
+	servers := []*http.Server{{
+		Addr:              cfg.Server.Address,
+		Handler:           root,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		ErrorLog:          errLog,
+	}}
+
+	// The admin listener is separate so metrics are never exposed through Caddy
+	if cfg.Server.AdminAddress != "" {
+		adminR := chi.NewRouter()
+		adminR.Handle("/metrics", metrics.Handler())
+		adminR.Get("/healthz", healthC.Live)
+		adminR.Get("/readyz", healthC.Ready)
+		servers = append(servers, &http.Server{
+			Addr:              cfg.Server.AdminAddress,
+			Handler:           adminR,
+			ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+			ErrorLog:          errLog,
+		})
+	}
+
+	// Start the servers
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		logger.Info("starting server", "address", srv.Addr, "env", cfg.Env)
+		go func() {
+			err := srv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+		err = nil
+	case err = <-serveErr:
+		logger.Error("server stopped", "err", err)
+	}
+
+	// Stop accepting connections and let in-flight requests (eg. uploads)
+	// finish, then stop the background workers which may have work queued
+	// by those requests
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		shutdownErr := srv.Shutdown(shutdownCtx)
+		if shutdownErr != nil {
+			logger.Error("server shutdown", "address", srv.Addr, "err", shutdownErr)
+		}
+	}
+
+	stopWorkers()
+	workers.Wait()
+	logger.Info("shutdown complete")
+
+	return err
 }
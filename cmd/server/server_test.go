@@ -0,0 +1,213 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// setConfigEnv runs loadEnvConfig against an empty .env with a minimal valid
+// configuration, vars overrides or adds to it
+func setConfigEnv(t *testing.T, vars map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, ".env"), nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	env := map[string]string{
+		"APP_ENV":               "production",
+		"PSQL_HOST":             "localhost",
+		"PSQL_PORT":             "5432",
+		"EMAIL_TRANSPORT":       "file",
+		"CSRF_KEY":              "csrf-key",
+		"CSRF_SECURE":           "true",
+		"CSRF_TRUSTED_ORIGINS":  "",
+		"COOKIE_HASH_KEY":       "cookie-hash-key",
+		"BASE_URL":              "http://localhost:3000",
+		"EMAIL_FROM":            "",
+		"EMAIL_REPLY_TO":        "",
+		"EMAIL_RETURN_PATH":     "",
+		"EMAIL_DOMAIN":          "",
+		"DKIM_PRIVATE_KEY_FILE": "",
+		"DKIM_SELECTOR":         "",
+		"DKIM_DOMAIN":           "",
+		"CLOUD_PROVIDERS":       "",
+		"CLOUD_PROVIDERS_FILE":  "",
+		"DROPBOX_APP_ID":        "",
+		"DROPBOX_APP_SECRET":    "",
+		"DROPBOX_SCOPES":        "",
+		"DROPBOX_AUTH_URL":      "",
+		"DROPBOX_TOKEN_URL":     "",
+		"DROPBOX_API_URL":       "",
+		"DROPBOX_CONTENT_URL":   "",
+	}
+	for key, value := range vars {
+		env[key] = value
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoadEnvConfigHosts(t *testing.T) {
+	setConfigEnv(t, map[string]string{
+		"BASE_URL":              "https://photos.example.com:8443",
+		"DKIM_PRIVATE_KEY_FILE": "dkim.pem",
+		"DKIM_SELECTOR":         "mail",
+	})
+
+	cfg, err := loadEnvConfig()
+	if err != nil {
+		t.Fatalf("loadEnvConfig() err = %v", err)
+	}
+
+	// Every host we hand out comes from BASE_URL
+	if got, want := cfg.Email.From, "Lenslocked Support <support@photos.example.com>"; got != want {
+		t.Errorf("Email.From = %q, want %q", got, want)
+	}
+	if got, want := cfg.Email.Domain, "photos.example.com"; got != want {
+		t.Errorf("Email.Domain = %q, want %q", got, want)
+	}
+	if got, want := cfg.DKIM.Domain, "photos.example.com"; got != want {
+		t.Errorf("DKIM.Domain = %q, want %q", got, want)
+	}
+	if got, want := cfg.Server.URLs.URL("/galleries", nil), "https://photos.example.com:8443/galleries"; got != want {
+		t.Errorf("Server.URLs.URL() = %q, want %q", got, want)
+	}
+	if !slices.Contains(cfg.CSRF.TrustedOrigins, "photos.example.com:8443") {
+		t.Errorf("CSRF.TrustedOrigins = %v, want it to contain the BASE_URL host", cfg.CSRF.TrustedOrigins)
+	}
+}
+
+func TestLoadEnvConfigEmailOverrides(t *testing.T) {
+	setConfigEnv(t, map[string]string{
+		"BASE_URL":     "https://photos.example.com",
+		"EMAIL_FROM":   "Studio <hello@studio.example.org>",
+		"EMAIL_DOMAIN": "mail.example.org",
+	})
+
+	cfg, err := loadEnvConfig()
+	if err != nil {
+		t.Fatalf("loadEnvConfig() err = %v", err)
+	}
+
+	if got, want := cfg.Email.From, "Studio <hello@studio.example.org>"; got != want {
+		t.Errorf("Email.From = %q, want %q", got, want)
+	}
+	if got, want := cfg.Email.Domain, "mail.example.org"; got != want {
+		t.Errorf("Email.Domain = %q, want %q", got, want)
+	}
+}
+
+func TestLoadEnvConfigInvalidBaseURL(t *testing.T) {
+	setConfigEnv(t, map[string]string{"BASE_URL": "photos.example.com"})
+
+	_, err := loadEnvConfig()
+	if err == nil {
+		t.Fatal("loadEnvConfig() err = nil, want an error for a BASE_URL without scheme")
+	}
+}
+
+func TestLoadEnvConfigMemoryTransport(t *testing.T) {
+	setConfigEnv(t, map[string]string{"EMAIL_TRANSPORT": "memory"})
+
+	_, err := loadEnvConfig()
+	if err == nil {
+		t.Fatal("loadEnvConfig() err = nil, want the memory transport rejected in production")
+	}
+}
+
+func TestLoadEnvConfigCloudProviders(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cloud.json")
+	err := os.WriteFile(file, []byte(`{
+		"Dropbox": {
+			"app_id": "file-id",
+			"app_secret": "file-secret",
+			"scopes": ["files.content.read"],
+			"api_url": "https://api.dropbox.test"
+		}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		env  map[string]string
+		want map[string]models.CloudConfig
+	}{
+		"env only": {
+			env: map[string]string{
+				"CLOUD_PROVIDERS":    "dropbox",
+				"DROPBOX_APP_ID":     "env-id",
+				"DROPBOX_APP_SECRET": "env-secret",
+				"DROPBOX_SCOPES":     "a, b",
+			},
+			want: map[string]models.CloudConfig{
+				"dropbox": {ClientID: "env-id", ClientSecret: "env-secret", Scopes: []string{"a", "b"}},
+			},
+		},
+		"file only": {
+			env: map[string]string{"CLOUD_PROVIDERS_FILE": file},
+			want: map[string]models.CloudConfig{
+				"dropbox": {
+					ClientID:     "file-id",
+					ClientSecret: "file-secret",
+					Scopes:       []string{"files.content.read"},
+					APIURL:       "https://api.dropbox.test",
+				},
+			},
+		},
+		"env overrides file": {
+			env: map[string]string{
+				"CLOUD_PROVIDERS_FILE": file,
+				"DROPBOX_APP_SECRET":   "env-secret",
+			},
+			want: map[string]models.CloudConfig{
+				"dropbox": {
+					ClientID:     "file-id",
+					ClientSecret: "env-secret",
+					Scopes:       []string{"files.content.read"},
+					APIURL:       "https://api.dropbox.test",
+				},
+			},
+		},
+		"none": {
+			want: map[string]models.CloudConfig{},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			setConfigEnv(t, tc.env)
+
+			cfg, err := loadEnvConfig()
+			if err != nil {
+				t.Fatalf("loadEnvConfig() err = %v", err)
+			}
+			if !reflect.DeepEqual(cfg.CloudProviders, tc.want) {
+				t.Errorf("CloudProviders = %+v, want %+v", cfg.CloudProviders, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadEnvConfigCloudProvidersFileTypo(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cloud.json")
+	err := os.WriteFile(file, []byte(`{"dropbox": {"app_secrett": "secret"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfigEnv(t, map[string]string{"CLOUD_PROVIDERS_FILE": file})
+
+	_, err = loadEnvConfig()
+	if err == nil {
+		t.Fatal("loadEnvConfig() err = nil, want unknown keys in the file rejected")
+	}
+}
@@ -0,0 +1,32 @@
+package context
+
+import (
+	"context"
+)
+
+const (
+	accessLogKey key = "access-log"
+)
+
+// AccessLogEntry collects what middlewares further down learn about a
+// request, eg. who made it, for the request's line in the access log
+type AccessLogEntry struct {
+	UserID int
+}
+
+func WithAccessLog(ctx context.Context, entry *AccessLogEntry) context.Context {
+	return context.WithValue(ctx, accessLogKey, entry)
+}
+
+// AccessLog returns the entry the request is logged with, nil outside of
+// the access log middleware
+func AccessLog(ctx context.Context) *AccessLogEntry {
+	val := ctx.Value(accessLogKey)
+
+	entry, ok := val.(*AccessLogEntry)
+	if !ok {
+		return nil
+	}
+
+	return entry
+}
@@ -0,0 +1,27 @@
+package context
+
+import (
+	"context"
+	"log/slog"
+)
+
+const (
+	loggerKey key = "logger"
+)
+
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// Logger returns the request scoped logger, or the default logger when the
+// context doesn't carry one (eg. background jobs)
+func Logger(ctx context.Context) *slog.Logger {
+	val := ctx.Value(loggerKey)
+
+	logger, ok := val.(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+
+	return logger
+}
@@ -0,0 +1,42 @@
+package context
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+const (
+	workspacesKey key = "workspaces"
+)
+
+type loadWorkspaces func() (all []models.Workspace, current *models.Workspace)
+
+// WithWorkspaces stores how to load the workspaces of the signed in user and
+// the one they are working in, current is nil when they look at all of
+// them. load runs the first time either is asked for, so requests that never
+// use them, eg. for images, don't query them.
+func WithWorkspaces(ctx context.Context, load func() (all []models.Workspace, current *models.Workspace)) context.Context {
+	return context.WithValue(ctx, workspacesKey, loadWorkspaces(sync.OnceValues(load)))
+}
+
+func Workspaces(ctx context.Context) []models.Workspace {
+	load, ok := ctx.Value(workspacesKey).(loadWorkspaces)
+	if !ok {
+		return nil
+	}
+	all, _ := load()
+	return all
+}
+
+// Workspace returns the workspace the user is working in, nil when they look
+// at all of their workspaces
+func Workspace(ctx context.Context) *models.Workspace {
+	load, ok := ctx.Value(workspacesKey).(loadWorkspaces)
+	if !ok {
+		return nil
+	}
+	_, current := load()
+	return current
+}
@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+	"github.com/rahulbalajee/lenslocked/urls"
+)
+
+// Admin holds the pages only admins can use, see UserMiddleware.RequireAdmin
+type Admin struct {
+	Template struct {
+		Transfer Executer
+	}
+	GalleryService  GalleryService
+	TransferService TransferService
+	EmailService    EmailService
+	URLs            *urls.Builder
+}
+
+type adminTransferData struct {
+	GalleryID string
+	Email     string
+	// Done describes the transfer that was just made
+	Done string
+}
+
+func (a Admin) Transfer(w http.ResponseWriter, r *http.Request) {
+	a.Template.Transfer.Execute(w, r, adminTransferData{})
+}
+
+// ProcessTransfer makes another account the owner of a gallery right away
+func (a Admin) ProcessTransfer(w http.ResponseWriter, r *http.Request) {
+	data := adminTransferData{
+		GalleryID: r.FormValue("gallery_id"),
+		Email:     strings.TrimSpace(r.FormValue("email")),
+	}
+
+	galleryID, err := strconv.Atoi(data.GalleryID)
+	if err != nil {
+		err = errors.Public(err, "Gallery ID must be a number.")
+		a.Template.Transfer.Execute(w, r, data, err)
+		return
+	}
+
+	gallery, err := a.GalleryService.ByID(r.Context(), galleryID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			err = errors.Public(err, "There is no gallery with that ID.")
+		}
+		a.Template.Transfer.Execute(w, r, data, err)
+		return
+	}
+
+	transfer, err := a.TransferService.ForceTransfer(r.Context(), gallery.ID, data.Email,
+		transferredEmails(r, a.EmailService, a.URLs, gallery, true))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			err = errors.Public(err, "There is no account with that email address.")
+		}
+		a.Template.Transfer.Execute(w, r, data, err)
+		return
+	}
+
+	context.Logger(r.Context()).Info("gallery force transferred", "gallery_id", gallery.ID,
+		"from_user_id", transfer.FromUserID)
+
+	data = adminTransferData{
+		Done: fmt.Sprintf("%s now belongs to %s.", gallery.Title, transfer.Email),
+	}
+	a.Template.Transfer.Execute(w, r, data)
+}
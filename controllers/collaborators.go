@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// InviteCollaborator emails an invitation to collaborate on the gallery
+func (g Galleries) InviteCollaborator(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !strings.Contains(email, "@") {
+		http.Error(w, "Email must be a valid email address", http.StatusBadRequest)
+		return
+	}
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	user := context.User(r.Context())
+	_, err = g.CollaboratorService.Invite(r.Context(), gallery.ID, email, role, user.ID,
+		func(tx *sql.Tx, invitation *models.Invitation) error {
+			return g.EmailService.GalleryInvitation(r.Context(), tx, invitation.Email, models.GalleryInvitationEmail{
+				InvitedBy:    user.Email,
+				GalleryTitle: gallery.Title,
+				Role:         role.Label(),
+				AcceptURL:    g.URLs.URL("/invitations/"+invitation.Token, nil),
+				ExpiresAt:    invitation.ExpiresAt,
+			})
+		})
+	if err != nil {
+		context.Logger(r.Context()).Error("create invitation", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// UpdateCollaborator changes the role of a collaborator
+func (g Galleries) UpdateCollaborator(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	err = g.CollaboratorService.SetRole(r.Context(), gallery.ID, userID, role)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Collaborator not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("set collaborator role", "gallery_id", gallery.ID, "user_id", userID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) RemoveCollaborator(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.CollaboratorService.Remove(r.Context(), gallery.ID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Collaborator not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("remove collaborator", "gallery_id", gallery.ID, "user_id", userID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	invitationID, err := strconv.Atoi(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.CollaboratorService.RevokeInvitation(r.Context(), gallery.ID, invitationID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("revoke invitation", "gallery_id", gallery.ID, "invitation_id", invitationID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// AcceptInvitation gives the signed in user the role of the invitation. The
+// token is what proves the invitation was meant for them, so it can be
+// accepted with an account using another email.
+func (g Galleries) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+
+	invitation, err := g.CollaboratorService.Accept(r.Context(), chi.URLParam(r, "token"), user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrLinkExpired) {
+			http.Error(w, "This invitation has expired", http.StatusGone)
+			return
+		}
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("accept invitation", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	galleryPath := fmt.Sprintf("/galleries/%d", invitation.GalleryID)
+	http.Redirect(w, r, galleryPath, http.StatusFound)
+}
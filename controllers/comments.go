@@ -0,0 +1,336 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+const (
+	maxCommentLength     = 2000
+	maxCommentNameLength = 100
+)
+
+// PostComment adds a comment to a gallery or one of its images, or a reply
+// to another comment. Collaborators comment under their email address and
+// their comments are shown right away. Everyone else gives a name, is rate
+// limited and waits for the owner to approve their comment.
+func (g Galleries) PostComment(w http.ResponseWriter, r *http.Request) {
+	gallery, role, galleryPath, err := g.commentableGallery(w, r)
+	if err != nil {
+		return
+	}
+	if !gallery.CommentsEnabled {
+		http.Error(w, "Comments are turned off for this gallery", http.StatusForbidden)
+		return
+	}
+
+	user := context.User(r.Context())
+	comment := models.Comment{
+		GalleryID:  gallery.ID,
+		Filename:   r.FormValue("filename"),
+		AuthorName: strings.TrimSpace(r.FormValue("name")),
+		Body:       strings.TrimSpace(r.FormValue("body")),
+		Status:     models.CommentPending,
+	}
+	if user != nil {
+		comment.UserID = &user.ID
+	}
+	if role != "" {
+		comment.AuthorName = user.Email
+		comment.Status = models.CommentApproved
+	}
+
+	if comment.AuthorName == "" {
+		http.Error(w, "Name can't be empty", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(comment.AuthorName) > maxCommentNameLength {
+		http.Error(w, fmt.Sprintf("Name can't be longer than %d characters", maxCommentNameLength), http.StatusBadRequest)
+		return
+	}
+	if comment.Body == "" {
+		http.Error(w, "Comment can't be empty", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(comment.Body) > maxCommentLength {
+		http.Error(w, fmt.Sprintf("Comment can't be longer than %d characters", maxCommentLength), http.StatusBadRequest)
+		return
+	}
+
+	if role == "" {
+		key := fmt.Sprintf("%d:%s", gallery.ID, clientIP(r, g.TrustProxy))
+		if !g.CommentLimiter.Allow(key) || !g.GalleryCommentLimiter.Allow(strconv.Itoa(gallery.ID)) {
+			context.Logger(r.Context()).Warn("too many comments", "gallery_id", gallery.ID)
+			http.Error(w, "Too many comments, please try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	if value := r.FormValue("parent_id"); value != "" {
+		parentID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid ID", http.StatusBadRequest)
+			return
+		}
+		parent, err := g.CommentService.ByID(r.Context(), gallery.ID, parentID)
+		if err != nil && !errors.Is(err, models.ErrNotFound) {
+			context.Logger(r.Context()).Error("query comment", "comment_id", parentID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		// Visitors only see approved comments, so those are all they can
+		// answer
+		if parent == nil || (parent.Status != models.CommentApproved && !role.Can(models.PermissionManage)) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		comment.ParentID = &parent.ID
+		comment.Filename = parent.Filename
+	} else if comment.Filename != "" {
+		_, err = g.ImageService.Image(r.Context(), gallery.ID, comment.Filename)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				http.Error(w, "Image not found", http.StatusNotFound)
+				return
+			}
+			context.Logger(r.Context()).Error("query image", "gallery_id", gallery.ID, "filename", comment.Filename, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = g.CommentService.Create(r.Context(), &comment,
+		func(tx *sql.Tx, comment *models.Comment) error {
+			// The owner doesn't need to hear about their own comments
+			if user != nil && user.ID == gallery.UserID {
+				return nil
+			}
+			unsubscribe, err := unsubscribeURL(g.UnsubscribeTokens, g.URLs, comment.OwnerEmail)
+			if err != nil {
+				return err
+			}
+			return g.EmailService.CommentPosted(r.Context(), tx, comment.OwnerEmail, models.CommentPostedEmail{
+				AuthorName:     comment.AuthorName,
+				Body:           comment.Body,
+				GalleryTitle:   gallery.Title,
+				Filename:       comment.Filename,
+				Pending:        comment.Status == models.CommentPending,
+				CommentsURL:    g.URLs.URL(fmt.Sprintf("/galleries/%d/edit", gallery.ID), nil),
+				UnsubscribeURL: unsubscribe,
+			})
+		})
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("create comment", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if comment.Status == models.CommentPending {
+		http.Redirect(w, r, galleryPath+"?comment=pending#comments", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, galleryPath+"#comments", http.StatusFound)
+}
+
+func (g Galleries) ApproveComment(w http.ResponseWriter, r *http.Request) {
+	g.moderateComment(w, r, models.CommentApproved)
+}
+
+// HideComment takes a comment down without deleting it, it can be approved
+// again later
+func (g Galleries) HideComment(w http.ResponseWriter, r *http.Request) {
+	g.moderateComment(w, r, models.CommentHidden)
+}
+
+// DeleteComment deletes a comment along with its replies
+func (g Galleries) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	gallery, commentID, err := g.commentByID(w, r)
+	if err != nil {
+		return
+	}
+
+	err = g.CommentService.Delete(r.Context(), gallery.ID, commentID)
+	if err != nil {
+		g.commentError(w, r, commentID, err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit#comments", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) moderateComment(w http.ResponseWriter, r *http.Request, status models.CommentStatus) {
+	gallery, commentID, err := g.commentByID(w, r)
+	if err != nil {
+		return
+	}
+
+	err = g.CommentService.SetStatus(r.Context(), gallery.ID, commentID, status)
+	if err != nil {
+		g.commentError(w, r, commentID, err)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit#comments", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// commentByID returns the gallery in the URL and the ID of the comment to
+// moderate, only owners of the gallery can
+func (g Galleries) commentByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, int, error) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	commentID, err := strconv.Atoi(chi.URLParam(r, "commentID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return nil, 0, err
+	}
+
+	return gallery, commentID, nil
+}
+
+func (g Galleries) commentError(w http.ResponseWriter, r *http.Request, commentID int, err error) {
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return
+	}
+	context.Logger(r.Context()).Error("moderate comment", "comment_id", commentID, "err", err)
+	http.Error(w, "Something went wrong", http.StatusInternalServerError)
+}
+
+// commentableGallery looks up the gallery a visitor comments on, through
+// /galleries/{id} or the shared link of an unlisted or public gallery. It
+// also returns the visitor's role on the gallery and the path of the page
+// the comments are shown on.
+func (g Galleries) commentableGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, models.Role, string, error) {
+	if chi.URLParam(r, "slug") != "" {
+		gallery, err := g.sharedGallery(w, r, false)
+		if err != nil {
+			return nil, "", "", err
+		}
+		role, err := g.userRole(r, gallery)
+		if err != nil {
+			context.Logger(r.Context()).Error("query gallery role", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return nil, "", "", err
+		}
+		return gallery, role, sharedGalleryPath(gallery), nil
+	}
+
+	gallery, err := g.lookupGallery(w, r, false)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	access, ok, err := g.galleryAccess(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery access", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, "", "", err
+	}
+	if !ok {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, "", "", fmt.Errorf("gallery can't be seen")
+	}
+
+	return gallery, access.Role, fmt.Sprintf("/galleries/%d", gallery.ID), nil
+}
+
+// comments holds the approved comments of a gallery page, threads are
+// handed out per image with thread
+type comments struct {
+	url string
+	// needsName is false for collaborators, they comment under their email
+	needsName bool
+	threads   map[string][]*models.Comment
+}
+
+// commentThread is what the comments template needs to show the comments
+// on a gallery or one of its images and the forms to add to them
+type commentThread struct {
+	URL       string
+	Filename  string
+	NeedsName bool
+	// Count includes the replies
+	Count    int
+	Comments []commentView
+}
+
+type commentView struct {
+	ID         int
+	AuthorName string
+	Body       string
+	CreatedAt  time.Time
+	Replies    []commentView
+	// Thread is used by the reply form
+	Thread *commentThread
+}
+
+// newComments loads the approved comments of a gallery served under
+// galleryPath, it returns nil when the gallery has comments turned off
+func (g Galleries) newComments(r *http.Request, gallery *models.Gallery, role models.Role, galleryPath string) (*comments, error) {
+	if !gallery.CommentsEnabled {
+		return nil, nil
+	}
+
+	approved, err := g.CommentService.Approved(r.Context(), gallery.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &comments{
+		url:       galleryPath + "/comments",
+		needsName: role == "",
+		threads:   models.Threads(approved),
+	}, nil
+}
+
+// thread returns the comments on an image, or on the gallery itself when
+// filename is empty. c may be nil when comments are turned off.
+func (c *comments) thread(filename string) *commentThread {
+	if c == nil {
+		return nil
+	}
+
+	thread := commentThread{
+		URL:       c.url,
+		Filename:  filename,
+		NeedsName: c.needsName,
+	}
+	var views func(comments []*models.Comment) []commentView
+	views = func(comments []*models.Comment) []commentView {
+		var list []commentView
+		for _, comment := range comments {
+			thread.Count++
+			list = append(list, commentView{
+				ID:         comment.ID,
+				AuthorName: comment.AuthorName,
+				Body:       comment.Body,
+				CreatedAt:  comment.CreatedAt,
+				Replies:    views(comment.Replies),
+				Thread:     &thread,
+			})
+		}
+		return list
+	}
+	thread.Comments = views(c.threads[filename])
+
+	return &thread
+}
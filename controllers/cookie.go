@@ -7,6 +7,17 @@ import (
 
 const (
 	CookieSession = "session"
+	// CookieShare holds the token of the share link a visitor opened, it is
+	// scoped to the path of the shared gallery
+	CookieShare = "share"
+	// CookieUnlock remembers that the visitor entered the password of a
+	// gallery, it is signed and scoped to the path of the shared gallery
+	CookieUnlock = "unlock"
+	// CookieSelection holds the token of the selection a client is making
+	// while proofing a gallery, it is scoped to the path of the gallery
+	CookieSelection = "selection"
+	// CookieWorkspace holds the ID of the workspace the user is working in
+	CookieWorkspace = "workspace"
 )
 
 func newCookie(name, value string) *http.Cookie {
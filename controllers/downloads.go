@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// DownloadGallery downloads the images of a gallery as a ZIP, to visitors
+// allowed to download single images. Only the images named by ?image are
+// included when it is set, or the images of the visitor's proofing
+// selection with ?selection. ?size=web shrinks large images.
+func (g Galleries) DownloadGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.lookupGallery(w, r, false)
+	if err != nil {
+		return
+	}
+
+	access, ok, err := g.galleryAccess(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery access", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !access.Download {
+		http.Error(w, "Downloads are not allowed for this gallery", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	filenames := query["image"]
+	name := gallery.Title
+	if query.Has("selection") {
+		selection := g.viewerSelection(r, gallery)
+		if selection == nil || len(selection.Items) == 0 {
+			http.Error(w, "Your selection is empty", http.StatusNotFound)
+			return
+		}
+		filenames = selectionFilenames(selection)
+		name = fmt.Sprintf("%s - %s", gallery.Title, selection.ClientName)
+	}
+
+	g.serveArchive(w, r, gallery, filenames, name)
+}
+
+// serveArchive sends a ZIP of the gallery's images named after name, or of
+// all of them when filenames is nil. Range requests let clients resume the
+// download, the archive is the same every time until an image changes.
+// Nothing is cached between requests though: web-size images are checked,
+// and made when missing, before the first byte is sent, and a resumed
+// download reads every image before the offset again to checksum it.
+func (g Galleries) serveArchive(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, filenames []string, name string) {
+	size := models.ImageSizeOriginal
+	if value := r.URL.Query().Get("size"); value != "" {
+		size = models.ImageSize(value)
+	}
+	if !size.Valid() {
+		http.Error(w, "Unknown image size", http.StatusBadRequest)
+		return
+	}
+
+	// Making the web-size images and sending a large gallery take longer
+	// than the server's write timeout allows any other response
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		context.Logger(r.Context()).Warn("clear write deadline", "err", err)
+	}
+
+	archive, err := g.ImageService.Archive(r.Context(), gallery.ID, filenames, size)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "No images to download", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("prepare archive", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if size == models.ImageSizeWeb {
+		name += " (web)"
+	}
+	name += ".zip"
+
+	reader := archive.Reader()
+	defer reader.Close()
+
+	w.Header().Set("ETag", archive.ETag)
+	w.Header().Set("Content-Type", "application/zip")
+	setAttachment(w, name)
+	http.ServeContent(w, r, name, archive.ModTime, reader)
+}
+
+func selectionFilenames(selection *models.Selection) []string {
+	filenames := make([]string, len(selection.Items))
+	for i, item := range selection.Items {
+		filenames[i] = item.Filename
+	}
+	return filenames
+}
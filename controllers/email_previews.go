@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// EmailPreview pairs an email template with sample data to render it with
+type EmailPreview struct {
+	Template models.EmailTemplate
+	Data     any
+}
+
+// EmailPreviews lets developers look at rendered emails in the browser without
+// sending them. Only mount this in development!
+type EmailPreviews struct {
+	Previews map[string]EmailPreview
+}
+
+func (ep EmailPreviews) Index(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range ep.Previews {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString("<!doctype html><html><body style=\"font-family:sans-serif\"><h1>Email previews</h1><ul>")
+	for _, name := range names {
+		escaped := template.HTMLEscapeString(name)
+		fmt.Fprintf(&b, `<li>%s: <a href="/dev/emails/%s">HTML</a> | <a href="/dev/emails/%s?format=text">Plaintext</a></li>`, escaped, escaped, escaped)
+	}
+	b.WriteString("</ul></body></html>")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+func (ep EmailPreviews) Show(w http.ResponseWriter, r *http.Request) {
+	preview, ok := ep.Previews[chi.URLParam(r, "name")]
+	if !ok {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
+	}
+
+	email, err := preview.Template.Render(preview.Data)
+	if err != nil {
+		context.Logger(r.Context()).Error("render email preview", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Subject: %s\n\n%s", email.Subject, email.Plaintext)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, email.HTML)
+}
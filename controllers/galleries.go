@@ -1,16 +1,23 @@
 package controllers
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
+	"mime"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/securecookie"
 	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
 	"github.com/rahulbalajee/lenslocked/models"
+	"github.com/rahulbalajee/lenslocked/urls"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -21,28 +28,99 @@ type Galleries struct {
 		Index     Executer
 		Show      Executer
 		ShowToAll Executer
+		Trash     Executer
+		ShareLink Executer
+		Unlock    Executer
+		Transfer  Executer
+		Import    Executer
+		// Fragments returned to infinite scrolling instead of whole pages
+		ImageItems   Executer
+		GalleryCards Executer
 	}
-	GalleryService GalleryService
-	ImageService   ImageService
+	GalleryService      GalleryService
+	ImageService        ImageService
+	ShareLinkService    ShareLinkService
+	CollaboratorService CollaboratorService
+	TransferService     TransferService
+	SelectionService    SelectionService
+	CommentService      CommentService
+	EmailService        EmailService
+	URLs                *urls.Builder
+	// Unlocks signs the cookies remembering unlocked galleries
+	Unlocks *securecookie.SecureCookie
+	// UnsubscribeTokens signs the unsubscribe links of notification emails,
+	// see Unsubscribe
+	UnsubscribeTokens *securecookie.SecureCookie
+	// UnlockLimiter limits password guesses on protected galleries per
+	// visitor, GalleryUnlockLimiter limits them per gallery whoever makes
+	// them, so changing addresses doesn't buy more guesses
+	UnlockLimiter        *RateLimiter
+	GalleryUnlockLimiter *RateLimiter
+	// CommentLimiter and GalleryCommentLimiter do the same for comments by
+	// visitors who don't collaborate on the gallery
+	CommentLimiter        *RateLimiter
+	GalleryCommentLimiter *RateLimiter
+	// TrustProxy takes the visitor's address from X-Forwarded-For, only set
+	// it behind a proxy that overwrites the header
+	TrustProxy bool
 }
 
 func (g Galleries) New(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Title string
+		Title       string
+		Description string
+		EventDate   string
+		Location    string
+		WorkspaceID int
+		Workspaces  []models.Workspace
 	}
 	data.Title = r.FormValue("title")
+	data.Workspaces = newGalleryWorkspaces(r)
+	if workspace := context.Workspace(r.Context()); workspace != nil {
+		data.WorkspaceID = workspace.ID
+	}
 	g.Template.New.Execute(w, r, data)
 }
 
 func (g Galleries) ProcessNew(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		UserID int
-		Title  string
+		Title       string
+		Description string
+		EventDate   string
+		Location    string
+		WorkspaceID int
+		Workspaces  []models.Workspace
 	}
-	data.UserID = context.User(r.Context()).ID
 	data.Title = r.FormValue("title")
+	data.Description = r.FormValue("description")
+	data.EventDate = r.FormValue("event_date")
+	data.Location = r.FormValue("location")
+	data.WorkspaceID, _ = strconv.Atoi(r.FormValue("workspace_id"))
+	data.Workspaces = newGalleryWorkspaces(r)
+
+	eventDate, err := parseEventDate(data.EventDate)
+	if err != nil {
+		g.Template.New.Execute(w, r, data, err)
+		return
+	}
+
+	if !slices.ContainsFunc(data.Workspaces, func(w models.Workspace) bool {
+		return w.ID == data.WorkspaceID
+	}) {
+		err = errors.Public(errors.New("invalid workspace"), "Please pick a workspace you can add galleries to.")
+		g.Template.New.Execute(w, r, data, err)
+		return
+	}
 
-	gallery, err := g.GalleryService.Create(data.Title, data.UserID)
+	gallery := models.Gallery{
+		UserID:      context.User(r.Context()).ID,
+		WorkspaceID: data.WorkspaceID,
+		Title:       data.Title,
+		Description: data.Description,
+		EventDate:   eventDate,
+		Location:    data.Location,
+	}
+	err = g.GalleryService.Create(r.Context(), &gallery)
 	if err != nil {
 		g.Template.New.Execute(w, r, data, err)
 		return
@@ -52,39 +130,320 @@ func (g Galleries) ProcessNew(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
 
+// newGalleryWorkspaces lists the workspaces the user can add galleries to
+func newGalleryWorkspaces(r *http.Request) []models.Workspace {
+	var workspaces []models.Workspace
+	for _, workspace := range context.Workspaces(r.Context()) {
+		if workspace.Role.Can(models.PermissionUpload) {
+			workspaces = append(workspaces, workspace)
+		}
+	}
+	return workspaces
+}
+
 func (g Galleries) Edit(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, models.PermissionUpload)
 	if err != nil {
 		return
 	}
 
+	role, err := g.userRole(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery role", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
 	type Image struct {
 		GalleryID       int
 		Filename        string
 		FilenameEscaped string
+		Title           string
+		Caption         string
+		AltText         string
 	}
-	var data struct {
+	type TrashedImage struct {
+		Image
+		// TrashNameEscaped addresses the image in the trash
+		TrashNameEscaped string
+		DeletedAt        time.Time
+	}
+	type Option struct {
+		Value string
+		Label string
+	}
+	type ShareLink struct {
+		ID            int
+		Label         string
+		CreatedAt     time.Time
+		ExpiresAt     *time.Time
+		Views         int
+		MaxViews      int
+		AllowDownload bool
+		Expired       bool
+		UsedUp        bool
+	}
+	type Collaborator struct {
+		UserID int
+		Email  string
+		Role   string
+	}
+	type Invitation struct {
 		ID        int
-		Title     string
-		Published bool
-		Images    []Image
+		Email     string
+		Role      string
+		ExpiresAt time.Time
+		Expired   bool
+	}
+	type Transfer struct {
+		Email     string
+		ExpiresAt time.Time
+		Expired   bool
+	}
+	type Comment struct {
+		ID         int
+		AuthorName string
+		Body       string
+		Filename   string
+		Reply      bool
+		Status     string
+		CreatedAt  time.Time
+	}
+	type SelectionItem struct {
+		Filename string
+		Note     string
+	}
+	type Selection struct {
+		ID             int
+		ClientName     string
+		ShareLinkLabel string
+		SubmittedAt    *time.Time
+		CreatedAt      time.Time
+		Items          []SelectionItem
+	}
+	var data struct {
+		ID            int
+		CanEdit       bool
+		CanManage     bool
+		CanTransfer   bool
+		Title         string
+		Description   string
+		EventDate     string
+		Location      string
+		CoverImage    string
+		SortMode      string
+		SortModes     []Option
+		Visibility    string
+		Visibilities  []Option
+		Protected     bool
+		CreatedAt     time.Time
+		UpdatedAt     time.Time
+		ShareURL      string
+		ShareLinks    []ShareLink
+		Roles         []Option
+		Collaborators []Collaborator
+		Invitations   []Invitation
+		// Transfer is the pending transfer of the gallery, if any
+		Transfer *Transfer
+		// Selections clients made while proofing the gallery
+		Selections      []Selection
+		CommentsEnabled bool
+		Comments        []Comment
+		Images          []Image
+		TrashedImages   []TrashedImage
 	}
 	data.ID = gallery.ID
+	data.CanEdit = role.Can(models.PermissionEdit)
+	data.CanManage = role.Can(models.PermissionManage)
+	data.CanTransfer, err = g.CollaboratorService.Owns(r.Context(), gallery, context.User(r.Context()).ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery owner", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
 	data.Title = gallery.Title
-	data.Published = gallery.Published
+	data.Description = gallery.Description
+	data.EventDate = formatEventDate(gallery.EventDate)
+	data.Location = gallery.Location
+	data.SortMode = string(gallery.SortMode)
+	for _, mode := range models.ImageSorts {
+		data.SortModes = append(data.SortModes, Option{
+			Value: string(mode),
+			Label: mode.Label(),
+		})
+	}
+	data.Visibility = string(gallery.Visibility)
+	data.Protected = gallery.Protected()
+	data.CommentsEnabled = gallery.CommentsEnabled
+	for _, visibility := range models.Visibilities {
+		data.Visibilities = append(data.Visibilities, Option{
+			Value: string(visibility),
+			Label: visibility.Label(),
+		})
+	}
+	data.CreatedAt = gallery.CreatedAt
+	data.UpdatedAt = gallery.UpdatedAt
+	// Protected galleries are shared through their link even when private
+	if gallery.Visibility.Shared() || gallery.Protected() {
+		data.ShareURL = g.URLs.URL(sharedGalleryPath(gallery), nil)
+	}
+
+	// Sharing and collaborators are only shown to those who can manage them
+	if data.CanManage {
+		links, err := g.ShareLinkService.ByGalleryID(r.Context(), gallery.ID)
+		if err != nil {
+			context.Logger(r.Context()).Error("query share links", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		for _, link := range links {
+			data.ShareLinks = append(data.ShareLinks, ShareLink{
+				ID:            link.ID,
+				Label:         link.Label,
+				CreatedAt:     link.CreatedAt,
+				ExpiresAt:     link.ExpiresAt,
+				Views:         link.Views,
+				MaxViews:      link.MaxViews,
+				AllowDownload: link.AllowDownload,
+				Expired:       link.Expired(),
+				UsedUp:        link.UsedUp(),
+			})
+		}
+
+		collaborators, err := g.CollaboratorService.Collaborators(r.Context(), gallery.ID)
+		if err != nil {
+			context.Logger(r.Context()).Error("query collaborators", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		for _, collaborator := range collaborators {
+			data.Collaborators = append(data.Collaborators, Collaborator{
+				UserID: collaborator.UserID,
+				Email:  collaborator.Email,
+				Role:   string(collaborator.Role),
+			})
+		}
+
+		invitations, err := g.CollaboratorService.Invitations(r.Context(), gallery.ID)
+		if err != nil {
+			context.Logger(r.Context()).Error("query invitations", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		for _, invitation := range invitations {
+			data.Invitations = append(data.Invitations, Invitation{
+				ID:        invitation.ID,
+				Email:     invitation.Email,
+				Role:      invitation.Role.Label(),
+				ExpiresAt: invitation.ExpiresAt,
+				Expired:   time.Now().After(invitation.ExpiresAt),
+			})
+		}
 
-	images, err := g.ImageService.Images(gallery.ID)
+		for _, role := range models.Roles {
+			data.Roles = append(data.Roles, Option{
+				Value: string(role),
+				Label: role.Label(),
+			})
+		}
+
+		comments, err := g.CommentService.ByGalleryID(r.Context(), gallery.ID)
+		if err != nil {
+			context.Logger(r.Context()).Error("query comments", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		for _, comment := range comments {
+			data.Comments = append(data.Comments, Comment{
+				ID:         comment.ID,
+				AuthorName: comment.AuthorName,
+				Body:       comment.Body,
+				Filename:   comment.Filename,
+				Reply:      comment.ParentID != nil,
+				Status:     string(comment.Status),
+				CreatedAt:  comment.CreatedAt,
+			})
+		}
+	}
+
+	if data.CanTransfer {
+		transfer, err := g.TransferService.ByGalleryID(r.Context(), gallery.ID)
+		switch {
+		case err == nil:
+			data.Transfer = &Transfer{
+				Email:     transfer.Email,
+				ExpiresAt: transfer.ExpiresAt,
+				Expired:   transfer.Expired(),
+			}
+		case !errors.Is(err, models.ErrNotFound):
+			context.Logger(r.Context()).Error("query transfer", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if data.CanEdit {
+		selections, err := g.SelectionService.ByGalleryID(r.Context(), gallery.ID)
+		if err != nil {
+			context.Logger(r.Context()).Error("query selections", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		for _, selection := range selections {
+			item := Selection{
+				ID:             selection.ID,
+				ClientName:     selection.ClientName,
+				ShareLinkLabel: selection.ShareLinkLabel,
+				SubmittedAt:    selection.SubmittedAt,
+				CreatedAt:      selection.CreatedAt,
+			}
+			for _, selected := range selection.Items {
+				item.Items = append(item.Items, SelectionItem{
+					Filename: selected.Filename,
+					Note:     selected.Note,
+				})
+			}
+			data.Selections = append(data.Selections, item)
+		}
+	}
+
+	images, err := g.ImageService.Images(r.Context(), gallery.ID)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("query gallery images", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
+	if cover := coverImage(gallery, images); cover != nil {
+		data.CoverImage = cover.Filename
+	}
 	for _, image := range images {
 		data.Images = append(data.Images, Image{
 			GalleryID:       image.GalleryID,
 			Filename:        image.Filename,
 			FilenameEscaped: url.PathEscape(image.Filename),
+			Title:           image.Title,
+			Caption:         image.Caption,
+			AltText:         image.AltText,
+		})
+	}
+
+	trashed, err := g.ImageService.TrashedImages(r.Context(), gallery.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("query trashed images", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	for _, image := range trashed {
+		data.TrashedImages = append(data.TrashedImages, TrashedImage{
+			Image: Image{
+				GalleryID:       image.GalleryID,
+				Filename:        image.Filename,
+				FilenameEscaped: url.PathEscape(image.Filename),
+			},
+			TrashNameEscaped: url.PathEscape(image.TrashName),
+			DeletedAt:        image.DeletedAt,
 		})
 	}
 
@@ -92,20 +451,82 @@ func (g Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 }
 
 func (g Galleries) ProcessEdit(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
 	if err != nil {
 		return
 	}
 	gallery.Title = r.FormValue("title")
+	gallery.Description = r.FormValue("description")
+	gallery.Location = r.FormValue("location")
+
+	role, err := g.userRole(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery role", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	// Editors don't see the visibility field, only owners decide who can see
+	// and comment on the gallery
+	if role.Can(models.PermissionManage) {
+		gallery.Visibility = models.Visibility(r.FormValue("visibility"))
+		if !gallery.Visibility.Valid() {
+			http.Error(w, "Invalid visibility", http.StatusBadRequest)
+			return
+		}
+		gallery.CommentsEnabled = r.FormValue("comments_enabled") == "on"
+	}
+
+	gallery.SortMode = models.ImageSort(r.FormValue("sort_mode"))
+	if !gallery.SortMode.Valid() {
+		http.Error(w, "Invalid sort mode", http.StatusBadRequest)
+		return
+	}
+
+	gallery.EventDate, err = parseEventDate(r.FormValue("event_date"))
+	if err != nil {
+		http.Error(w, "Event date must be a valid date", http.StatusBadRequest)
+		return
+	}
 
-	gallery.Published, err = strconv.ParseBool(r.FormValue("published"))
+	gallery.CoverImage = filepath.Base(r.FormValue("cover_image"))
+	if gallery.CoverImage == "." {
+		gallery.CoverImage = ""
+	}
+	if gallery.CoverImage != "" {
+		_, err = g.ImageService.Image(r.Context(), gallery.ID, gallery.CoverImage)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				http.Error(w, "Cover image not found", http.StatusBadRequest)
+				return
+			}
+			context.Logger(r.Context()).Error("query cover image", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = g.GalleryService.Update(r.Context(), gallery)
 	if err != nil {
+		context.Logger(r.Context()).Error("update gallery", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
-	err = g.GalleryService.Update(gallery)
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// ResetSlug gives the gallery a new share link, whoever had the old one
+// can't use it anymore
+func (g Galleries) ResetSlug(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.ResetSlug(r.Context(), gallery)
 	if err != nil {
+		context.Logger(r.Context()).Error("reset gallery slug", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -116,122 +537,317 @@ func (g Galleries) ProcessEdit(w http.ResponseWriter, r *http.Request) {
 
 func (g Galleries) Index(w http.ResponseWriter, r *http.Request) {
 	type Gallery struct {
-		ID        int
-		Title     string
-		Published bool
+		ID         int
+		Title      string
+		Visibility string
+		EventDate  *time.Time
+		Location   string
+		UpdatedAt  time.Time
+		ImageCount int
+		// CoverURL is empty when the gallery has no images
+		CoverURL string
+		CoverAlt string
+		// Workspace is only set when listing every workspace
+		Workspace string
+		// Role is only set for galleries shared with the user
+		Role      string
+		CanEdit   bool
+		CanDelete bool
 	}
 
 	var data struct {
-		Galleries []Gallery
+		// Workspace is empty when listing every workspace
+		Workspace  string
+		Galleries  []Gallery
+		Pagination pagination
 	}
 
 	user := context.User(r.Context())
+	workspaceID := 0
+	if workspace := context.Workspace(r.Context()); workspace != nil {
+		workspaceID = workspace.ID
+		data.Workspace = workspace.Name
+	}
+	workspaceNames := make(map[int]string)
+	for _, workspace := range context.Workspaces(r.Context()) {
+		workspaceNames[workspace.ID] = workspace.Name
+	}
 
-	galleries, err := g.GalleryService.ByUserID(user.ID)
+	page, err := g.GalleryService.ByMember(r.Context(), user.ID, workspaceID, pageFromRequest(r))
 	if err != nil {
-		fmt.Println(err)
+		if errors.Is(err, models.ErrInvalidCursor) {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+		context.Logger(r.Context()).Error("query galleries by member", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	data.Pagination = newPagination(r, page.Cursors)
 
-	for _, gallery := range galleries {
-		data.Galleries = append(data.Galleries, Gallery{
-			ID:        gallery.ID,
-			Title:     gallery.Title,
-			Published: gallery.Published,
-		})
+	galleryIDs := make([]int, len(page.Galleries))
+	for i, gallery := range page.Galleries {
+		galleryIDs[i] = gallery.ID
+	}
+	summaries, err := g.ImageService.ImageSummaries(r.Context(), galleryIDs)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery image summaries", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	for _, gallery := range page.Galleries {
+		role := page.Roles[gallery.ID]
+		summary := summaries[gallery.ID]
+
+		card := Gallery{
+			ID:         gallery.ID,
+			Title:      gallery.Title,
+			Visibility: string(gallery.Visibility),
+			EventDate:  gallery.EventDate,
+			Location:   gallery.Location,
+			UpdatedAt:  gallery.UpdatedAt,
+			ImageCount: summary.Count,
+			CanEdit:    role.Can(models.PermissionUpload),
+			CanDelete:  role.Can(models.PermissionEdit),
+		}
+		if workspaceID == 0 {
+			card.Workspace = workspaceNames[gallery.WorkspaceID]
+		}
+		// Galleries of the user's workspaces aren't shared with them, they
+		// are theirs to work on
+		if _, member := workspaceNames[gallery.WorkspaceID]; !member && role != models.RoleOwner {
+			card.Role = role.Label()
+		}
+		if cover := summary.Cover; cover != nil {
+			card.CoverURL = imageURL(*cover)
+			card.CoverAlt = imageAlt(*cover)
+		}
+		data.Galleries = append(data.Galleries, card)
+	}
+
+	if isFragmentRequest(r) {
+		g.Template.GalleryCards.Execute(w, r, data)
+		return
 	}
 
 	g.Template.Index.Execute(w, r, data)
 }
 
+// Show shows a gallery to its collaborators and to visitors with a viewer
+// session from a share link
 func (g Galleries) Show(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.lookupGallery(w, r, false)
 	if err != nil {
 		return
 	}
 
+	access, ok, err := g.galleryAccess(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery access", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if context.User(r.Context()) == nil {
+			http.Redirect(w, r, "/signin", http.StatusFound)
+			return
+		}
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+
 	type Image struct {
-		GalleryID       int
-		Filename        string
-		FilenameEscaped string
+		URL     string
+		Title   string
+		Caption string
+		Alt     string
+		// DownloadURL is empty when the visitor can't download
+		DownloadURL string
+		proofedImage
+		// Comments is nil when the gallery has comments turned off
+		Comments *commentThread
 	}
 	var data struct {
-		ID     int
-		Title  string
-		Images []Image
+		ID          int
+		CanEdit     bool
+		Title       string
+		Description string
+		EventDate   *time.Time
+		Location    string
+		UpdatedAt   time.Time
+		ImageCount  int
+		Images      []Image
+		Pagination  pagination
+		// DownloadURL is empty when the visitor can't download
+		DownloadURL string
+		// Proofing is nil for collaborators
+		Proofing *proofing
+		Comments *commentThread
+		// CommentPending is true after the visitor posted a comment that
+		// waits for approval
+		CommentPending bool
 	}
 	data.ID = gallery.ID
+	data.CanEdit = access.Can(models.PermissionUpload)
+	if access.Download {
+		data.DownloadURL = fmt.Sprintf("/galleries/%d/download", gallery.ID)
+	}
+	if access.Role == "" {
+		data.Proofing = g.newProofing(r, gallery, fmt.Sprintf("/galleries/%d", gallery.ID))
+		if data.DownloadURL != "" && data.Proofing.Count > 0 {
+			data.Proofing.DownloadURL = data.DownloadURL + "?selection"
+		}
+	}
+
+	comments, err := g.newComments(r, gallery, access.Role, fmt.Sprintf("/galleries/%d", gallery.ID))
+	if err != nil {
+		context.Logger(r.Context()).Error("query comments", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	data.Comments = comments.thread("")
+	data.CommentPending = r.URL.Query().Get("comment") == "pending"
 	data.Title = gallery.Title
+	data.Description = gallery.Description
+	data.EventDate = gallery.EventDate
+	data.Location = gallery.Location
+	data.UpdatedAt = gallery.UpdatedAt
 
-	images, err := g.ImageService.Images(gallery.ID)
+	page, err := g.ImageService.ImagesPage(r.Context(), gallery.ID, pageFromRequest(r))
 	if err != nil {
-		fmt.Println(err)
+		if errors.Is(err, models.ErrInvalidCursor) {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+		context.Logger(r.Context()).Error("query gallery images", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	data.ImageCount = page.Total
+	data.Pagination = newPagination(r, page.Cursors)
 
-	for _, image := range images {
-		data.Images = append(data.Images, Image{
-			GalleryID:       image.GalleryID,
-			Filename:        image.Filename,
-			FilenameEscaped: url.PathEscape(image.Filename),
-		})
+	for _, image := range page.Images {
+		item := Image{
+			URL:          imageURL(image),
+			Title:        image.Title,
+			Caption:      image.Caption,
+			Alt:          imageAlt(image),
+			proofedImage: data.Proofing.image(image.Filename),
+			Comments:     comments.thread(image.Filename),
+		}
+		if access.Download {
+			item.DownloadURL = item.URL + "?download"
+		}
+		data.Images = append(data.Images, item)
+	}
+
+	if isFragmentRequest(r) {
+		g.Template.ImageItems.Execute(w, r, data)
+		return
 	}
 
 	g.Template.Show.Execute(w, r, data)
 }
 
+// ShowToAll shows unlisted and public galleries to anyone who has the link,
+// visitors are asked for the password of protected galleries
 func (g Galleries) ShowToAll(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r)
+	gallery, err := g.sharedGallery(w, r, true)
 	if err != nil {
 		return
 	}
 
-	if !gallery.Published {
-		http.Error(w, "Gallery not found", http.StatusNotFound)
-		return
-	}
-
 	type Image struct {
-		GalleryID       int
-		Filename        string
-		FilenameEscaped string
+		URL     string
+		Title   string
+		Caption string
+		Alt     string
+		// Downloads need a share link that allows them
+		DownloadURL string
+		proofedImage
+		Comments *commentThread
 	}
 	var data struct {
-		ID     int
-		Title  string
-		Images []Image
+		ID          int
+		Title       string
+		Description string
+		EventDate   *time.Time
+		Location    string
+		UpdatedAt   time.Time
+		ImageCount  int
+		Images      []Image
+		Pagination  pagination
+		Proofing    *proofing
+		Comments    *commentThread
+		// CommentPending is true after the visitor posted a comment that
+		// waits for approval
+		CommentPending bool
 	}
 	data.ID = gallery.ID
+	data.Proofing = g.newProofing(r, gallery, sharedGalleryPath(gallery))
+
+	var comments *comments
+	if gallery.CommentsEnabled {
+		role, err := g.userRole(r, gallery)
+		if err == nil {
+			comments, err = g.newComments(r, gallery, role, sharedGalleryPath(gallery))
+		}
+		if err != nil {
+			context.Logger(r.Context()).Error("query comments", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+	data.Comments = comments.thread("")
+	data.CommentPending = r.URL.Query().Get("comment") == "pending"
 	data.Title = gallery.Title
+	data.Description = gallery.Description
+	data.EventDate = gallery.EventDate
+	data.Location = gallery.Location
+	data.UpdatedAt = gallery.UpdatedAt
 
-	images, err := g.ImageService.Images(gallery.ID)
+	page, err := g.ImageService.ImagesPage(r.Context(), gallery.ID, pageFromRequest(r))
 	if err != nil {
-		fmt.Println(err)
+		if errors.Is(err, models.ErrInvalidCursor) {
+			http.Error(w, "Invalid page", http.StatusBadRequest)
+			return
+		}
+		context.Logger(r.Context()).Error("query gallery images", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
+	data.ImageCount = page.Total
+	data.Pagination = newPagination(r, page.Cursors)
 
-	for _, image := range images {
+	for _, image := range page.Images {
 		data.Images = append(data.Images, Image{
-			GalleryID:       image.GalleryID,
-			Filename:        image.Filename,
-			FilenameEscaped: url.PathEscape(image.Filename),
+			URL:          sharedGalleryPath(gallery) + "/images/" + url.PathEscape(image.Filename),
+			Title:        image.Title,
+			Caption:      image.Caption,
+			Alt:          imageAlt(image),
+			proofedImage: data.Proofing.image(image.Filename),
+			Comments:     comments.thread(image.Filename),
 		})
 	}
 
+	if isFragmentRequest(r) {
+		g.Template.ImageItems.Execute(w, r, data)
+		return
+	}
+
 	g.Template.ShowToAll.Execute(w, r, data)
 }
 
 func (g Galleries) Delete(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
 	if err != nil {
 		return
 	}
 
-	err = g.GalleryService.Delete(gallery.ID)
+	err = g.GalleryService.Delete(r.Context(), gallery.ID)
 	if err != nil {
+		context.Logger(r.Context()).Error("delete gallery", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -239,37 +855,142 @@ func (g Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
+// Trash lists the galleries the user deleted so they can be restored
+func (g Galleries) Trash(w http.ResponseWriter, r *http.Request) {
+	type Gallery struct {
+		ID        int
+		Title     string
+		DeletedAt time.Time
+	}
+
+	var data struct {
+		Galleries []Gallery
+	}
+
+	user := context.User(r.Context())
+
+	galleries, err := g.GalleryService.Trashed(r.Context(), user.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("query trashed galleries", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	for _, gallery := range galleries {
+		data.Galleries = append(data.Galleries, Gallery{
+			ID:        gallery.ID,
+			Title:     gallery.Title,
+			DeletedAt: *gallery.DeletedAt,
+		})
+	}
+
+	g.Template.Trash.Execute(w, r, data)
+}
+
+func (g Galleries) Restore(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.trashedGalleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.Restore(r.Context(), gallery.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("restore gallery", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) DeleteForever(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.trashedGalleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	err = g.GalleryService.DeleteForever(r.Context(), gallery.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("delete gallery forever", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/galleries/trash", http.StatusFound)
+}
+
+// Image serves the images of a gallery to whoever can see it, see
+// galleryAccess. Unlisted galleries serve their images through SharedImage
+// so the link can't be guessed from the ID. Adding ?download to the URL
+// serves the image as an attachment to visitors allowed to download.
 func (g Galleries) Image(w http.ResponseWriter, r *http.Request) {
-	filename := g.filename(w, r)
+	gallery, err := g.lookupGallery(w, r, false)
+	if err != nil {
+		return
+	}
 
-	galleryID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	access, ok, err := g.galleryAccess(r, gallery)
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusNotFound)
+		context.Logger(r.Context()).Error("query gallery access", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+
+	download := r.URL.Query().Has("download")
+	if download && !access.Download {
+		http.Error(w, "Downloads are not allowed for this gallery", http.StatusForbidden)
 		return
 	}
 
-	image, err := g.ImageService.Image(galleryID, filename)
+	g.serveImage(w, r, gallery, download)
+}
+
+// SharedImage serves an image of an unlisted or public gallery by its slug
+func (g Galleries) SharedImage(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.sharedGallery(w, r, false)
+	if err != nil {
+		return
+	}
+
+	g.serveImage(w, r, gallery, false)
+}
+
+func (g Galleries) serveImage(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, download bool) {
+	filename := g.filename(w, r)
+
+	image, err := g.ImageService.Image(r.Context(), gallery.ID, filename)
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
 			http.Error(w, "Image not found", http.StatusNotFound)
 			return
 		}
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("query image", "gallery_id", gallery.ID, "filename", filename, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
+	if download {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": image.Filename,
+		}))
+	}
 	http.ServeFile(w, r, image.Path)
 }
 
 func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, models.PermissionUpload)
 	if err != nil {
 		return
 	}
 
 	err = r.ParseMultipartForm(5 << 20) // 5mb bit shift
 	if err != nil {
+		context.Logger(r.Context()).Error("parse multipart form", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -278,12 +999,13 @@ func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
 	for _, fileHeader := range fileHeaders {
 		file, err := fileHeader.Open()
 		if err != nil {
+			context.Logger(r.Context()).Error("open uploaded file", "filename", fileHeader.Filename, "err", err)
 			http.Error(w, "Something went wrong", http.StatusInternalServerError)
 			return
 		}
 		defer file.Close()
 
-		err = g.ImageService.CreateImage(gallery.ID, fileHeader.Filename, file)
+		err = g.ImageService.CreateImage(r.Context(), gallery.ID, fileHeader.Filename, file)
 		if err != nil {
 			var fileErr models.FileError
 			if errors.As(err, &fileErr) {
@@ -291,6 +1013,7 @@ func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, msg, http.StatusBadRequest)
 				return
 			}
+			context.Logger(r.Context()).Error("create image", "gallery_id", gallery.ID, "filename", fileHeader.Filename, "err", err)
 			http.Error(w, "Something went wrong", http.StatusInternalServerError)
 			return
 		}
@@ -301,7 +1024,7 @@ func (g Galleries) UploadImage(w http.ResponseWriter, r *http.Request) {
 }
 
 func (g Galleries) ImageViaURL(w http.ResponseWriter, r *http.Request) {
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, models.PermissionUpload)
 	if err != nil {
 		return
 	}
@@ -314,14 +1037,16 @@ func (g Galleries) ImageViaURL(w http.ResponseWriter, r *http.Request) {
 
 	files := r.PostForm["images"]
 
-	var eg errgroup.Group
+	// The first failed download cancels the others
+	eg, ctx := errgroup.WithContext(r.Context())
 	for _, file := range files {
 		imageFile := file
 		eg.Go(func() error {
-			return g.ImageService.CreateImageViaURL(gallery.ID, imageFile)
+			return g.ImageService.CreateImageViaURL(ctx, gallery.ID, imageFile)
 		})
 	}
 	if err = eg.Wait(); err != nil {
+		context.Logger(r.Context()).Error("create images via url", "gallery_id", gallery.ID, "err", err)
 		http.Error(w, "Unable to download all images", http.StatusInternalServerError)
 		return
 	}
@@ -330,16 +1055,223 @@ func (g Galleries) ImageViaURL(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, editPath, http.StatusFound)
 }
 
+// ReorderImages saves the order images were dragged into on the edit page. The
+// body is JSON with every filename of the gallery in its new order, the CSRF
+// token is sent in the X-CSRF-Token header.
+func (g Galleries) ReorderImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	var body struct {
+		Order []string `json:"order"`
+	}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err = g.ImageService.Reorder(r.Context(), gallery.ID, body.Order)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidOrder) {
+			http.Error(w, "The images of this gallery have changed, please reload the page", http.StatusConflict)
+			return
+		}
+		context.Logger(r.Context()).Error("reorder images", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	// Dragging images around only makes sense with the manual order
+	if gallery.SortMode != models.SortManual {
+		gallery.SortMode = models.SortManual
+		err = g.GalleryService.Update(r.Context(), gallery)
+		if err != nil {
+			context.Logger(r.Context()).Error("update gallery sort mode", "gallery_id", gallery.ID, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateImageDetails saves the title, caption and alt text of a single image
+// edited inline on the edit page
+func (g Galleries) UpdateImageDetails(w http.ResponseWriter, r *http.Request) {
+	filename := g.filename(w, r)
+
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	details := []models.ImageDetails{{
+		Filename: filename,
+		Title:    strings.TrimSpace(r.FormValue("title")),
+		Caption:  strings.TrimSpace(r.FormValue("caption")),
+		AltText:  strings.TrimSpace(r.FormValue("alt_text")),
+	}}
+	g.updateImageDetails(w, r, gallery, details)
+}
+
+// BulkUpdateImageDetails applies the same title, caption or alt text to every
+// selected image. Fields left blank are not changed.
+func (g Galleries) BulkUpdateImageDetails(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	selected := r.PostForm["filenames"]
+	title := strings.TrimSpace(r.PostForm.Get("title"))
+	caption := strings.TrimSpace(r.PostForm.Get("caption"))
+	altText := strings.TrimSpace(r.PostForm.Get("alt_text"))
+
+	images, err := g.ImageService.Images(r.Context(), gallery.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery images", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var details []models.ImageDetails
+	for _, image := range images {
+		if !slices.Contains(selected, image.Filename) {
+			continue
+		}
+		d := models.ImageDetails{
+			Filename: image.Filename,
+			Title:    image.Title,
+			Caption:  image.Caption,
+			AltText:  image.AltText,
+		}
+		if title != "" {
+			d.Title = title
+		}
+		if caption != "" {
+			d.Caption = caption
+		}
+		if altText != "" {
+			d.AltText = altText
+		}
+		details = append(details, d)
+	}
+	g.updateImageDetails(w, r, gallery, details)
+}
+
+func (g Galleries) updateImageDetails(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, details []models.ImageDetails) {
+	err := g.ImageService.UpdateDetails(r.Context(), gallery.ID, details)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("update image details", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
 func (g Galleries) DeleteImage(w http.ResponseWriter, r *http.Request) {
 	filename := g.filename(w, r)
 
-	gallery, err := g.galleryByID(w, r, userMustOwnGallery)
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	err = g.ImageService.DeleteImage(r.Context(), gallery.ID, filename)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("delete image", "gallery_id", gallery.ID, "filename", filename, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// TrashedImage serves an image from the trash, only the owner can see it
+func (g Galleries) TrashedImage(w http.ResponseWriter, r *http.Request) {
+	trashName := g.filename(w, r)
+
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
 	if err != nil {
 		return
 	}
 
-	err = g.ImageService.DeleteImage(gallery.ID, filename)
+	image, err := g.ImageService.TrashedImage(r.Context(), gallery.ID, trashName)
 	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("query trashed image", "gallery_id", gallery.ID, "trash_name", trashName, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	http.ServeFile(w, r, image.Path)
+}
+
+func (g Galleries) RestoreImage(w http.ResponseWriter, r *http.Request) {
+	trashName := g.filename(w, r)
+
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	err = g.ImageService.RestoreImage(r.Context(), gallery.ID, trashName)
+	if err != nil {
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			http.Error(w, fmt.Sprintf("Unable to restore the image, %v.", fileErr.Issue), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("restore image", "gallery_id", gallery.ID, "trash_name", trashName, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) DeleteImageForever(w http.ResponseWriter, r *http.Request) {
+	trashName := g.filename(w, r)
+
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	err = g.ImageService.DeleteTrashedImage(r.Context(), gallery.ID, trashName)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("delete trashed image", "gallery_id", gallery.ID, "trash_name", trashName, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -354,42 +1286,189 @@ func (g Galleries) filename(w http.ResponseWriter, r *http.Request) string {
 	return filename
 }
 
-type galleryOpt func(http.ResponseWriter, *http.Request, *models.Gallery) error
+// coverImage returns the image chosen as the gallery's cover, or the first
+// image when none was chosen or it has been deleted since
+func coverImage(gallery *models.Gallery, images []models.Image) *models.Image {
+	if len(images) == 0 {
+		return nil
+	}
+	for i := range images {
+		if images[i].Filename == gallery.CoverImage {
+			return &images[i]
+		}
+	}
+	return &images[0]
+}
+
+// imageURL is the path the owner of a gallery loads its images from
+func imageURL(image models.Image) string {
+	return fmt.Sprintf("/galleries/%d/images/%s", image.GalleryID, url.PathEscape(image.Filename))
+}
 
-func (g Galleries) galleryByID(w http.ResponseWriter, r *http.Request, opts ...galleryOpt) (*models.Gallery, error) {
+// sharedGalleryPath is the path unlisted and public galleries are shared with
+func sharedGalleryPath(gallery *models.Gallery) string {
+	return "/galleries/g/" + url.PathEscape(gallery.Slug)
+}
+
+// imageAlt is the alt attribute of an image, falling back to its title or
+// caption when no alt text was written
+func imageAlt(image models.Image) string {
+	for _, alt := range []string{image.AltText, image.Title, image.Caption} {
+		if alt != "" {
+			return alt
+		}
+	}
+	return image.Filename
+}
+
+const eventDateLayout = "2006-01-02"
+
+// parseEventDate parses the value of a date input, an empty value clears the date
+func parseEventDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse(eventDateLayout, value)
+	if err != nil {
+		return nil, errors.Public(err, "Event date must be a valid date.")
+	}
+	return &date, nil
+}
+
+func formatEventDate(date *time.Time) string {
+	if date == nil {
+		return ""
+	}
+	return date.Format(eventDateLayout)
+}
+
+// galleryByID looks up the gallery in the URL and checks the current user
+// has perm on it, see authorize
+func (g Galleries) galleryByID(w http.ResponseWriter, r *http.Request, perm models.Permission) (*models.Gallery, error) {
+	gallery, err := g.lookupGallery(w, r, false)
+	if err != nil {
+		return nil, err
+	}
+	return gallery, g.authorize(w, r, gallery, perm)
+}
+
+// trashedGalleryByID is galleryByID for galleries that are in the trash
+func (g Galleries) trashedGalleryByID(w http.ResponseWriter, r *http.Request, perm models.Permission) (*models.Gallery, error) {
+	gallery, err := g.lookupGallery(w, r, true)
+	if err != nil {
+		return nil, err
+	}
+	return gallery, g.authorize(w, r, gallery, perm)
+}
+
+// lookupGallery looks up the gallery in the URL without checking who is
+// asking, handlers open to visitors check galleryAccess themselves
+func (g Galleries) lookupGallery(w http.ResponseWriter, r *http.Request, trashed bool) (*models.Gallery, error) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		http.Error(w, "Invalid ID", http.StatusNotFound)
 		return nil, err
 	}
 
-	gallery, err := g.GalleryService.ByID(id)
+	var gallery *models.Gallery
+	if trashed {
+		gallery, err = g.GalleryService.TrashedByID(r.Context(), id)
+	} else {
+		gallery, err = g.GalleryService.ByID(r.Context(), id)
+	}
 	if err != nil {
 		if errors.Is(err, models.ErrNotFound) {
 			http.Error(w, "Gallery not found", http.StatusNotFound)
 			return nil, err
 		}
+		context.Logger(r.Context()).Error("query gallery by id", "gallery_id", id, "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return nil, err
 	}
 
-	for _, opt := range opts {
-		err = opt(w, r, gallery)
-		if err != nil {
+	return gallery, nil
+}
+
+// sharedGallery looks up an unlisted or public gallery by the slug in the
+// URL. Links used to carry the numeric ID, they are redirected to the slug
+// as long as the gallery is public. Protected galleries, private ones
+// included, need to be unlocked first, prompt shows the password form
+// instead of a 404 when they aren't.
+func (g Galleries) sharedGallery(w http.ResponseWriter, r *http.Request, prompt bool) (*models.Gallery, error) {
+	slug := chi.URLParam(r, "slug")
+
+	gallery, err := g.GalleryService.BySlug(r.Context(), slug)
+	if errors.Is(err, models.ErrNotFound) {
+		if id, convErr := strconv.Atoi(slug); convErr == nil {
+			g.redirectPublicGallery(w, r, id)
 			return nil, err
 		}
 	}
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+			return nil, err
+		}
+		context.Logger(r.Context()).Error("query gallery by slug", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	role, err := g.userRole(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery role", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, err
+	}
+	// Collaborators don't need the password
+	if role != "" {
+		return gallery, nil
+	}
+
+	// Private galleries stay private whether they have a password or not
+	if !gallery.Visibility.Shared() {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, fmt.Errorf("gallery is private")
+	}
+
+	if gallery.Protected() {
+		if g.unlocked(r, gallery) {
+			return gallery, nil
+		}
+		if prompt {
+			g.renderUnlock(w, r, gallery)
+		} else {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		}
+		return nil, fmt.Errorf("gallery is locked")
+	}
 
 	return gallery, nil
-
 }
 
-func userMustOwnGallery(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) error {
-	user := context.User(r.Context())
-	if gallery.UserID != user.ID {
-		http.Error(w, "You are not authorized to edit this Gallery", http.StatusForbidden)
-		return fmt.Errorf("user does not have access to this gallery")
+func (g Galleries) redirectPublicGallery(w http.ResponseWriter, r *http.Request, id int) {
+	gallery, err := g.GalleryService.ByID(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("query gallery by id", "gallery_id", id, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
 	}
 
-	return nil
+	if gallery.Visibility != models.VisibilityPublic {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+
+	target := sharedGalleryPath(gallery)
+	if filename := chi.URLParam(r, "filename"); filename != "" {
+		target += "/images/" + url.PathEscape(filename)
+	}
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
 }
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// How long an unlocked gallery stays unlocked, securecookie codecs reject
+// values older than 30 days by default
+const unlockDuration = 30 * 24 * time.Hour
+
+// galleryUnlock is stored in the signed unlock cookie
+type galleryUnlock struct {
+	GalleryID int
+	// Password is a fingerprint of the password hash, changing the password
+	// locks everyone out again
+	Password string
+}
+
+// SetPassword sets or removes the password visitors need to see the gallery
+func (g Galleries) SetPassword(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	password := r.FormValue("password")
+	if r.FormValue("remove") != "" {
+		password = ""
+	} else if password == "" {
+		http.Error(w, "Password can't be empty", http.StatusBadRequest)
+		return
+	}
+
+	err = g.GalleryService.SetPassword(r.Context(), gallery, password)
+	if err != nil {
+		context.Logger(r.Context()).Error("set gallery password", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// Unlock checks the password of a protected gallery and remembers the
+// unlock in a signed cookie scoped to the shared gallery
+func (g Galleries) Unlock(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.GalleryService.BySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("query gallery by slug", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	// A password doesn't make a private gallery visible
+	if !gallery.Visibility.Shared() {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	if !gallery.Protected() {
+		http.Redirect(w, r, sharedGalleryPath(gallery), http.StatusFound)
+		return
+	}
+
+	// Guesses are limited per gallery and visitor, so someone hammering a
+	// gallery doesn't lock out everyone else, and per gallery, so someone
+	// switching addresses can't keep guessing
+	key := fmt.Sprintf("%d:%s", gallery.ID, clientIP(r, g.TrustProxy))
+	if !g.UnlockLimiter.Allow(key) || !g.GalleryUnlockLimiter.Allow(strconv.Itoa(gallery.ID)) {
+		context.Logger(r.Context()).Warn("too many unlock attempts", "gallery_id", gallery.ID)
+		err = errors.Public(errors.New("too many unlock attempts"), "Too many attempts, please try again later.")
+		g.renderUnlock(w, r, gallery, err)
+		return
+	}
+
+	if !gallery.CheckPassword(r.FormValue("password")) {
+		err = errors.Public(errors.New("wrong gallery password"), "Wrong password, please try again.")
+		g.renderUnlock(w, r, gallery, err)
+		return
+	}
+
+	value, err := g.Unlocks.Encode(CookieUnlock, galleryUnlock{
+		GalleryID: gallery.ID,
+		Password:  passwordFingerprint(gallery),
+	})
+	if err != nil {
+		context.Logger(r.Context()).Error("encode unlock cookie", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	cookie := newCookie(CookieUnlock, value)
+	cookie.Path = sharedGalleryPath(gallery)
+	cookie.MaxAge = int(unlockDuration.Seconds())
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, sharedGalleryPath(gallery), http.StatusFound)
+}
+
+func (g Galleries) renderUnlock(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, errs ...error) {
+	var data struct {
+		Title     string
+		UnlockURL string
+	}
+	data.Title = gallery.Title
+	data.UnlockURL = sharedGalleryPath(gallery) + "/unlock"
+
+	g.Template.Unlock.Execute(w, r, data, errs...)
+}
+
+// unlocked tells if the visitor entered the password of the gallery
+func (g Galleries) unlocked(r *http.Request, gallery *models.Gallery) bool {
+	value, err := readCookie(r, CookieUnlock)
+	if err != nil {
+		return false
+	}
+
+	var unlock galleryUnlock
+	err = g.Unlocks.Decode(CookieUnlock, value, &unlock)
+	if err != nil {
+		context.Logger(r.Context()).Info("invalid unlock cookie", "gallery_id", gallery.ID, "err", err)
+		return false
+	}
+
+	return unlock.GalleryID == gallery.ID && unlock.Password == passwordFingerprint(gallery)
+}
+
+// passwordFingerprint identifies the current password of a gallery without
+// giving away its hash, the unlock cookie is signed but not encrypted
+func passwordFingerprint(gallery *models.Gallery) string {
+	sum := sha256.Sum256([]byte(gallery.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// galleryAccess is what a visitor may do with a gallery they can see
+type galleryAccess struct {
+	// Role is empty for visitors who aren't collaborators
+	Role models.Role
+	// Download allows downloading the original images
+	Download bool
+}
+
+// Can tells if the visitor's role grants perm
+func (a galleryAccess) Can(perm models.Permission) bool {
+	return a.Role.Can(perm)
+}
+
+// galleryAccess works out what the visitor may do with the gallery, ok is
+// false when they can't see it at all. Collaborators get what their role
+// allows, a viewer session from a share link grants what the link allows and
+// public galleries without a password can be seen by everyone.
+func (g Galleries) galleryAccess(r *http.Request, gallery *models.Gallery) (access galleryAccess, ok bool, err error) {
+	role, err := g.userRole(r, gallery)
+	if err != nil {
+		return galleryAccess{}, false, err
+	}
+	if role != "" {
+		return galleryAccess{Role: role, Download: role.Can(models.PermissionView)}, true, nil
+	}
+
+	if link := g.viewerShareLink(r, gallery); link != nil {
+		return galleryAccess{Download: link.AllowDownload}, true, nil
+	}
+
+	// Protected galleries are unlocked through their shared link, see
+	// sharedGallery
+	return galleryAccess{}, gallery.Visibility == models.VisibilityPublic && !gallery.Protected(), nil
+}
+
+// userRole returns the role of the signed in user on the gallery, it is
+// empty for visitors who aren't signed in or don't collaborate on it
+func (g Galleries) userRole(r *http.Request, gallery *models.Gallery) (models.Role, error) {
+	user := context.User(r.Context())
+	if user == nil {
+		return "", nil
+	}
+
+	role, err := g.CollaboratorService.Role(r.Context(), gallery, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return role, nil
+}
+
+// authorize checks the signed in user has perm on the gallery. Users who
+// don't collaborate on the gallery are told it doesn't exist so IDs don't
+// leak, collaborators whose role isn't enough get a 403.
+func (g Galleries) authorize(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, perm models.Permission) error {
+	role, err := g.userRole(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery role", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return err
+	}
+
+	if role == "" {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return fmt.Errorf("user does not have access to this gallery")
+	}
+	if !role.Can(perm) {
+		http.Error(w, "You are not authorized to do this", http.StatusForbidden)
+		return fmt.Errorf("%s can't do this", role)
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+	"github.com/rahulbalajee/lenslocked/urls"
+)
+
+// TransferGallery emails a link to accept the gallery to its new owner. Only
+// the account owning the gallery can give it away, collaborators with the
+// owner role can't.
+func (g Galleries) TransferGallery(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.ownGallery(w, r)
+	if err != nil {
+		return
+	}
+
+	user := context.User(r.Context())
+	email := strings.TrimSpace(r.FormValue("email"))
+	if !strings.Contains(email, "@") {
+		http.Error(w, "Email must be a valid email address", http.StatusBadRequest)
+		return
+	}
+	if strings.EqualFold(email, user.Email) {
+		http.Error(w, "You already own this gallery", http.StatusBadRequest)
+		return
+	}
+
+	_, err = g.TransferService.Create(r.Context(), gallery.ID, user.ID, email,
+		func(tx *sql.Tx, transfer *models.GalleryTransfer) error {
+			return g.EmailService.GalleryTransfer(r.Context(), tx, transfer.Email, models.GalleryTransferEmail{
+				From:         user.Email,
+				GalleryTitle: gallery.Title,
+				AcceptURL:    g.URLs.URL("/transfers/"+transfer.Token, nil),
+				ExpiresAt:    transfer.ExpiresAt,
+			})
+		})
+	if err != nil {
+		context.Logger(r.Context()).Error("create transfer", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) CancelTransfer(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.ownGallery(w, r)
+	if err != nil {
+		return
+	}
+
+	err = g.TransferService.Cancel(r.Context(), gallery.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Transfer not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("cancel transfer", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// ShowTransfer lets the recipient of a transfer pick the workspace the
+// gallery goes to before accepting it
+func (g Galleries) ShowTransfer(w http.ResponseWriter, r *http.Request) {
+	transfer, gallery, err := g.pendingTransfer(w, r)
+	if err != nil {
+		return
+	}
+
+	g.renderTransfer(w, r, transfer, gallery)
+}
+
+func (g Galleries) AcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	transfer, gallery, err := g.pendingTransfer(w, r)
+	if err != nil {
+		return
+	}
+
+	workspaceID, _ := strconv.Atoi(r.FormValue("workspace_id"))
+	if !slices.ContainsFunc(newGalleryWorkspaces(r), func(w models.Workspace) bool {
+		return w.ID == workspaceID
+	}) {
+		err = errors.Public(errors.New("invalid workspace"), "Please pick a workspace you can add galleries to.")
+		g.renderTransfer(w, r, transfer, gallery, err)
+		return
+	}
+
+	user := context.User(r.Context())
+	_, err = g.TransferService.Accept(r.Context(), chi.URLParam(r, "token"), user.ID, workspaceID,
+		transferredEmails(r, g.EmailService, g.URLs, gallery, false))
+	if err != nil {
+		if errors.Is(err, models.ErrLinkExpired) {
+			http.Error(w, "This transfer has expired", http.StatusGone)
+			return
+		}
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Transfer not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("accept transfer", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+func (g Galleries) renderTransfer(w http.ResponseWriter, r *http.Request, transfer *models.GalleryTransfer, gallery *models.Gallery, errs ...error) {
+	var data struct {
+		Token       string
+		Title       string
+		From        string
+		WorkspaceID int
+		Workspaces  []models.Workspace
+	}
+	data.Token = chi.URLParam(r, "token")
+	data.Title = gallery.Title
+	data.From = transfer.FromEmail
+	data.Workspaces = newGalleryWorkspaces(r)
+	// Keep the gallery in its workspace when the recipient is a member of it
+	data.WorkspaceID = gallery.WorkspaceID
+
+	g.Template.Transfer.Execute(w, r, data, errs...)
+}
+
+// pendingTransfer looks up the transfer in the URL and its gallery, making
+// sure it was sent to the email of the signed in user
+func (g Galleries) pendingTransfer(w http.ResponseWriter, r *http.Request) (*models.GalleryTransfer, *models.Gallery, error) {
+	transfer, err := g.TransferService.ByToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, models.ErrLinkExpired) {
+			http.Error(w, "This transfer has expired", http.StatusGone)
+			return nil, nil, err
+		}
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Transfer not found", http.StatusNotFound)
+			return nil, nil, err
+		}
+		context.Logger(r.Context()).Error("query transfer by token", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, nil, err
+	}
+
+	user := context.User(r.Context())
+	if !strings.EqualFold(transfer.Email, user.Email) {
+		http.Error(w, "This transfer was sent to another email address, sign in with that account to accept it", http.StatusForbidden)
+		return nil, nil, fmt.Errorf("transfer sent to another email")
+	}
+
+	gallery, err := g.GalleryService.ByID(r.Context(), transfer.GalleryID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+			return nil, nil, err
+		}
+		context.Logger(r.Context()).Error("query gallery by id", "gallery_id", transfer.GalleryID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, nil, err
+	}
+
+	return transfer, gallery, nil
+}
+
+// ownGallery is galleryByID for actions only the account owning the gallery
+// can take
+func (g Galleries) ownGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return nil, err
+	}
+
+	owns, err := g.CollaboratorService.Owns(r.Context(), gallery, context.User(r.Context()).ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery owner", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, err
+	}
+	if !owns {
+		http.Error(w, "Only the owner of the gallery can do this", http.StatusForbidden)
+		return nil, fmt.Errorf("user does not own the gallery")
+	}
+
+	return gallery, nil
+}
+
+// transferredEmails queues the confirmation of a transfer for the previous
+// and the new owner in the transaction that moves the gallery
+func transferredEmails(r *http.Request, emails EmailService, urlBuilder *urls.Builder, gallery *models.Gallery, forced bool) models.Notify[models.GalleryTransfer] {
+	return func(tx *sql.Tx, transfer *models.GalleryTransfer) error {
+		data := models.GalleryTransferredEmail{
+			From:         transfer.FromEmail,
+			To:           transfer.Email,
+			GalleryTitle: gallery.Title,
+			Forced:       forced,
+		}
+
+		// The previous owner may have lost access to the gallery
+		data.GalleryURL = urlBuilder.URL("/galleries", nil)
+		err := emails.GalleryTransferred(r.Context(), tx, transfer.FromEmail, data)
+		if err != nil {
+			return err
+		}
+
+		data.Recipient = true
+		data.GalleryURL = urlBuilder.URL(fmt.Sprintf("/galleries/%d", gallery.ID), nil)
+		return emails.GalleryTransferred(r.Context(), tx, transfer.Email, data)
+	}
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// HealthCheck is a single dependency checked by the readiness probe
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type Health struct {
+	Checks []HealthCheck
+	// Timeout for all checks of a single probe, defaults to 5 seconds
+	Timeout time.Duration
+}
+
+// Live tells the orchestrator the process is up, it never checks dependencies
+// so a database outage doesn't get the container restarted
+func (h Health) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Ready reports whether we can serve traffic, ie. every check passes
+func (h Health) Ready(w http.ResponseWriter, r *http.Request) {
+	timeout := h.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	status := http.StatusOK
+	results := make(map[string]string)
+	for name, err := range h.Run(ctx) {
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			results[name] = err.Error()
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
+
+// Run executes every check and returns its error keyed by check name
+func (h Health) Run(ctx context.Context) map[string]error {
+	results := make(map[string]error, len(h.Checks))
+	for _, check := range h.Checks {
+		results[check.Name] = check.Check(ctx)
+	}
+	return results
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"archive/zip"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// maxImportUploadSize caps the ZIP archives ImportImages accepts
+const maxImportUploadSize = 2 << 30
+
+// ImportImages adds the images in an uploaded ZIP archive to a gallery. With
+// the folders option every top-level folder of the archive goes into a new
+// gallery named after it instead. A summary lists the images that were
+// imported and why the other files weren't.
+func (g Galleries) ImportImages(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionUpload)
+	if err != nil {
+		return
+	}
+
+	// Uploading and importing a large archive takes longer than the server's
+	// read and write timeouts allow any other request
+	rc := http.NewResponseController(w)
+	err = rc.SetReadDeadline(time.Time{})
+	if err != nil {
+		context.Logger(r.Context()).Warn("clear read deadline", "err", err)
+	}
+	err = rc.SetWriteDeadline(time.Time{})
+	if err != nil {
+		context.Logger(r.Context()).Warn("clear write deadline", "err", err)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadSize)
+	err = r.ParseMultipartForm(5 << 20) // 5mb bit shift
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			msg := fmt.Sprintf("The archive can't be larger than %d GB", maxImportUploadSize>>30)
+			http.Error(w, msg, http.StatusRequestEntityTooLarge)
+			return
+		}
+		context.Logger(r.Context()).Error("parse multipart form", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, fileHeader, err := r.FormFile("archive")
+	if err != nil {
+		http.Error(w, "Please pick a ZIP archive to import", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, fileHeader.Size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v isn't a ZIP archive", fileHeader.Filename), http.StatusBadRequest)
+		return
+	}
+
+	// Folders become galleries of the user, in the workspace of the gallery
+	// the archive was uploaded to
+	folders := r.FormValue("folders") == "on"
+	if folders && !slices.ContainsFunc(newGalleryWorkspaces(r), func(w models.Workspace) bool {
+		return w.ID == gallery.WorkspaceID
+	}) {
+		http.Error(w, "You can't add galleries to this workspace", http.StatusForbidden)
+		return
+	}
+
+	entries, err := g.ImageService.ImportEntries(zr, folders)
+	if err != nil {
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			http.Error(w, fmt.Sprintf("%v can't be imported: %v", fileHeader.Filename, fileErr.Issue), http.StatusBadRequest)
+			return
+		}
+		context.Logger(r.Context()).Error("read archive", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	type Gallery struct {
+		ID    int
+		Title string
+		Count int
+		// New is true for galleries made for a folder of the archive
+		New bool
+	}
+	type Entry struct {
+		Name         string
+		GalleryTitle string
+		// Issue is empty for imported images
+		Issue string
+	}
+	var data struct {
+		ID        int
+		Title     string
+		Archive   string
+		Galleries []*Gallery
+		Imported  []Entry
+		Rejected  []Entry
+	}
+	data.ID = gallery.ID
+	data.Title = gallery.Title
+	data.Archive = fileHeader.Filename
+	data.Galleries = []*Gallery{{ID: gallery.ID, Title: gallery.Title}}
+
+	folderGalleries := map[string]*Gallery{"": data.Galleries[0]}
+	for _, entry := range entries {
+		if entry.Issue != "" {
+			data.Rejected = append(data.Rejected, Entry{Name: entry.Name, Issue: entry.Issue})
+			continue
+		}
+
+		target, ok := folderGalleries[entry.Folder]
+		if !ok {
+			folderGallery := models.Gallery{
+				UserID:      context.User(r.Context()).ID,
+				WorkspaceID: gallery.WorkspaceID,
+				Title:       entry.Folder,
+			}
+			err = g.GalleryService.Create(r.Context(), &folderGallery)
+			if err != nil {
+				context.Logger(r.Context()).Error("create gallery for folder", "folder", entry.Folder, "err", err)
+				http.Error(w, "Something went wrong", http.StatusInternalServerError)
+				return
+			}
+			target = &Gallery{ID: folderGallery.ID, Title: folderGallery.Title, New: true}
+			folderGalleries[entry.Folder] = target
+			data.Galleries = append(data.Galleries, target)
+		}
+
+		issue, err := g.importEntry(r, target.ID, entry)
+		if err != nil {
+			context.Logger(r.Context()).Error("import image", "gallery_id", target.ID, "name", entry.Name, "err", err)
+			http.Error(w, "Something went wrong", http.StatusInternalServerError)
+			return
+		}
+		if issue != "" {
+			data.Rejected = append(data.Rejected, Entry{Name: entry.Name, Issue: issue})
+			continue
+		}
+		target.Count++
+		data.Imported = append(data.Imported, Entry{Name: entry.Name, GalleryTitle: target.Title})
+	}
+
+	g.Template.Import.Execute(w, r, data)
+}
+
+// importEntry saves an image from an archive to a gallery. It returns why
+// the image was rejected, the error is only set when something else went
+// wrong.
+func (g Galleries) importEntry(r *http.Request, galleryID int, entry models.ImportEntry) (string, error) {
+	contents, err := entry.Open()
+	if err != nil {
+		if errors.Is(err, zip.ErrAlgorithm) {
+			return "compressed with an unsupported method", nil
+		}
+		return "", err
+	}
+	defer contents.Close()
+
+	err = g.ImageService.CreateImage(r.Context(), galleryID, entry.Filename, contents)
+	if err != nil {
+		var fileErr models.FileError
+		if errors.As(err, &fileErr) {
+			return fileErr.Issue, nil
+		}
+		if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) {
+			return "the file is damaged", nil
+		}
+		return "", err
+	}
+
+	return "", nil
+}
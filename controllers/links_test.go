@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	appctx "github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/models"
+	"github.com/rahulbalajee/lenslocked/templates"
+	"github.com/rahulbalajee/lenslocked/urls"
+	"github.com/rahulbalajee/lenslocked/views"
+)
+
+// testBaseURL isn't the default BASE_URL, every link we hand out must be
+// built on it
+const testBaseURL = "https://photos.example.com/studio"
+
+func testURLs(t *testing.T) *urls.Builder {
+	t.Helper()
+	builder, err := urls.New(testBaseURL)
+	if err != nil {
+		t.Fatalf("urls.New() err = %v", err)
+	}
+	return builder
+}
+
+// assertLink checks that s has the link and no link to another host
+func assertLink(t *testing.T, what, s, link string) {
+	t.Helper()
+	if !strings.Contains(s, link) {
+		t.Errorf("%v doesn't contain %q:\n%v", what, link, s)
+	}
+	if strings.Contains(s, "localhost") {
+		t.Errorf("%v links to localhost:\n%v", what, s)
+	}
+}
+
+func renderEmail(t *testing.T, name string, data any) models.Email {
+	t.Helper()
+	tmpl, err := views.ParseEmailFS(templates.FS, name)
+	if err != nil {
+		t.Fatalf("ParseEmailFS(%q) err = %v", name, err)
+	}
+	email, err := tmpl.Render(data)
+	if err != nil {
+		t.Fatalf("Render() err = %v", err)
+	}
+	return email
+}
+
+func parsePage(t *testing.T, name string) views.Template {
+	t.Helper()
+	tmpl, err := views.ParseFS(templates.FS, name, "tailwind.gohtml")
+	if err != nil {
+		t.Fatalf("ParseFS(%q) err = %v", name, err)
+	}
+	return tmpl
+}
+
+type nopExecuter struct{}
+
+func (nopExecuter) Execute(w http.ResponseWriter, r *http.Request, data any, errs ...error) {}
+
+type fakePasswordResets struct {
+	PasswordResetService
+}
+
+func (fakePasswordResets) Create(ctx context.Context, email string) (*models.PasswordReset, error) {
+	return &models.PasswordReset{Token: "reset-token"}, nil
+}
+
+// fakeEmails records the links of the emails it is asked to send
+type fakeEmails struct {
+	EmailService
+	resetURL   string
+	invitation models.GalleryInvitationEmail
+}
+
+func (fe *fakeEmails) ForgotPassword(ctx context.Context, to string, resetURL string) error {
+	fe.resetURL = resetURL
+	return nil
+}
+
+func (fe *fakeEmails) GalleryInvitation(ctx context.Context, tx *sql.Tx, to string, data models.GalleryInvitationEmail) error {
+	fe.invitation = data
+	return nil
+}
+
+type fakeGalleries struct {
+	GalleryService
+}
+
+func (fakeGalleries) ByID(ctx context.Context, id int) (*models.Gallery, error) {
+	return &models.Gallery{ID: id, UserID: 1, Title: "Summer Wedding"}, nil
+}
+
+type fakeCollaborators struct {
+	CollaboratorService
+}
+
+func (fakeCollaborators) Role(ctx context.Context, gallery *models.Gallery, userID int) (models.Role, error) {
+	return models.RoleOwner, nil
+}
+
+func (fakeCollaborators) Invite(ctx context.Context, galleryID int, email string, role models.Role, invitedBy int, notify models.Notify[models.Invitation]) (*models.Invitation, error) {
+	invitation := &models.Invitation{GalleryID: galleryID, Email: email, Role: role, Token: "invite-token"}
+	return invitation, notify(nil, invitation)
+}
+
+type fakeShareLinks struct {
+	ShareLinkService
+}
+
+func (fakeShareLinks) Create(ctx context.Context, link *models.ShareLink) error {
+	link.Token = "share-token"
+	return nil
+}
+
+// galleryRequest is a POST by a signed in user to a route with the
+// gallery ID 7
+func galleryRequest(form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/galleries/7", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "7")
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, rctx)
+	ctx = appctx.WithUser(ctx, &models.User{ID: 1, Email: "jon@example.com"})
+	return r.WithContext(ctx)
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	emails := &fakeEmails{}
+	u := Users{
+		PasswordResetService: fakePasswordResets{},
+		EmailService:         emails,
+		URLs:                 testURLs(t),
+	}
+	u.Templates.CheckYourEmail = nopExecuter{}
+
+	form := url.Values{"email": {"jon@example.com"}}
+	r := httptest.NewRequest(http.MethodPost, "/forgot-pw", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	u.ProcessForgotPassword(httptest.NewRecorder(), r)
+
+	link := testBaseURL + "/reset-pw?token=reset-token"
+	if emails.resetURL != link {
+		t.Fatalf("reset URL = %q, want %q", emails.resetURL, link)
+	}
+	email := renderEmail(t, "forgot-password", models.ForgotPasswordEmail{ResetURL: emails.resetURL})
+	assertLink(t, "HTML email", email.HTML, link)
+	assertLink(t, "plaintext email", email.Plaintext, link)
+}
+
+func TestOAuthRedirectURI(t *testing.T) {
+	provider, err := models.NewCloudProvider("dropbox", models.CloudConfig{
+		ClientID: "app-id",
+		AuthURL:  "https://dropbox.example.com/oauth2/authorize",
+		TokenURL: "https://dropbox.example.com/oauth2/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oa := OAuth{
+		Providers: map[string]models.CloudProvider{"dropbox": provider},
+		URLs:      testURLs(t),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/oauth/dropbox/connect", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("provider", "dropbox")
+	r = r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx))
+	w := httptest.NewRecorder()
+	oa.Connect(w, r)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	want := testBaseURL + "/oauth/dropbox/callback"
+	if got := location.Query().Get("redirect_uri"); got != want {
+		t.Errorf("redirect_uri = %q, want %q", got, want)
+	}
+}
+
+func TestShareLinkURL(t *testing.T) {
+	g := Galleries{
+		GalleryService:      fakeGalleries{},
+		CollaboratorService: fakeCollaborators{},
+		ShareLinkService:    fakeShareLinks{},
+		URLs:                testURLs(t),
+	}
+	g.Template.ShareLink = parsePage(t, "galleries/share-link.gohtml")
+
+	w := httptest.NewRecorder()
+	g.CreateShareLink(w, galleryRequest(url.Values{"label": {"Family"}}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %v", w.Code, http.StatusOK, w.Body)
+	}
+	assertLink(t, "share link page", w.Body.String(), testBaseURL+"/share/share-token")
+}
+
+func TestInvitationURL(t *testing.T) {
+	emails := &fakeEmails{}
+	g := Galleries{
+		GalleryService:      fakeGalleries{},
+		CollaboratorService: fakeCollaborators{},
+		EmailService:        emails,
+		URLs:                testURLs(t),
+	}
+
+	w := httptest.NewRecorder()
+	g.InviteCollaborator(w, galleryRequest(url.Values{
+		"email": {"jane@example.com"},
+		"role":  {string(models.RoleEditor)},
+	}))
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d: %v", w.Code, http.StatusFound, w.Body)
+	}
+
+	link := testBaseURL + "/invitations/invite-token"
+	if emails.invitation.AcceptURL != link {
+		t.Fatalf("accept URL = %q, want %q", emails.invitation.AcceptURL, link)
+	}
+	email := renderEmail(t, "gallery-invitation", emails.invitation)
+	assertLink(t, "HTML email", email.HTML, link)
+	assertLink(t, "plaintext email", email.Plaintext, link)
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/gorilla/csrf"
+	"github.com/rahulbalajee/lenslocked/context/context"
+)
+
+// RequestLogger puts a logger tagged with the request ID in the request
+// context. It must run after chi's middleware.RequestID.
+func RequestLogger(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqLogger := logger.With("request_id", middleware.GetReqID(r.Context()))
+			ctx := context.WithLogger(r.Context(), reqLogger)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// AccessLog logs one line per request once it has been served. It runs right
+// after RequestLogger so requests turned away by later middlewares, eg. the
+// CSRF check, are logged too. UserMiddleware.SetUser fills in the user ID.
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		entry := &context.AccessLogEntry{}
+
+		next.ServeHTTP(ww, r.WithContext(context.WithAccessLog(r.Context(), entry)))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", routePattern(r),
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"latency", time.Since(start),
+		}
+		if entry.UserID != 0 {
+			attrs = append(attrs, "user_id", entry.UserID)
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		context.Logger(r.Context()).Log(r.Context(), level, "request", attrs...)
+	})
+}
+
+// CSRFFailure is used as the csrf.ErrorHandler so rejected requests are logged
+func CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	context.Logger(r.Context()).Warn("csrf check failed",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"err", csrf.FailureReason(r),
+	)
+	http.Error(w, "Forbidden - CSRF token invalid", http.StatusForbidden)
+}
+
+// routePattern returns the chi route that matched, eg. /galleries/{id}, which
+// unlike the path doesn't explode into one value per gallery
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	return rctx.RoutePattern()
+}
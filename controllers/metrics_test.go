@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/metrics"
+)
+
+func TestMetricsScrape(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Metrics)
+	r.Get("/galleries/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// The counters are global and keep their values when the test runs
+	// again, so check how much the requests added to them
+	series := []string{
+		`lenslocked_http_requests_total{method="GET",route="/galleries/{id}",status="204"}`,
+		`lenslocked_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`lenslocked_http_request_duration_seconds_count{method="GET",route="/galleries/{id}"}`,
+	}
+	before := scrapeMetrics(t)
+
+	for _, path := range []string{"/galleries/1", "/galleries/2", "/nope"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	after := scrapeMetrics(t)
+	// Requests are labelled by route pattern, not by path
+	for i, want := range []float64{2, 1, 2} {
+		if got := after[series[i]] - before[series[i]]; got != want {
+			t.Errorf("%v went up by %v, want %v", series[i], got, want)
+		}
+	}
+	for name := range after {
+		if strings.Contains(name, `route="/galleries/1"`) {
+			t.Error("scrape has a series per path, want one per route pattern")
+		}
+	}
+}
+
+// scrapeMetrics scrapes metrics.Handler, the admin server serves it at
+// /metrics, and returns the value of every series
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+
+	admin := httptest.NewServer(metrics.Handler())
+	defer admin.Close()
+
+	resp, err := http.Get(admin.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("scrape err = %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scrape status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for line := range strings.Lines(string(b)) {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		values[name], err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("scrape has %q, want a number", line)
+		}
+	}
+	return values
+}
@@ -1,29 +1,28 @@
 package controllers
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/csrf"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/models"
+	"github.com/rahulbalajee/lenslocked/urls"
 	"golang.org/x/oauth2"
 )
 
 type OAuth struct {
-	ProviderConfigs map[string]*oauth2.Config
+	Providers map[string]models.CloudProvider
+	// URLs builds the redirect URI registered with each provider
+	URLs *urls.Builder
 }
 
 func (oa OAuth) Connect(w http.ResponseWriter, r *http.Request) {
-	provider := chi.URLParam(r, "provider")
-	provider = strings.ToLower(provider)
-
-	config, ok := oa.ProviderConfigs[provider]
+	provider, ok := oa.provider(w, r)
 	if !ok {
-		http.Error(w, "Invalid OAuth2 Service", http.StatusBadRequest)
 		return
 	}
 
@@ -33,31 +32,28 @@ func (oa OAuth) Connect(w http.ResponseWriter, r *http.Request) {
 	setCookie(w, "oauth_state", state)
 	setCookie(w, "oauth_verifier", verifier)
 
-	url := config.AuthCodeURL(
-		state,
-		oauth2.SetAuthURLParam("token_access_type", "offline"),
-		oauth2.SetAuthURLParam("redirect_uri", redirectURI(r, provider)),
+	opts := append(
+		provider.AuthCodeOptions(),
+		oauth2.SetAuthURLParam("redirect_uri", oa.redirectURI(provider.Name())),
 		oauth2.S256ChallengeOption(verifier),
 	)
+	url := provider.OAuth2Config().AuthCodeURL(state, opts...)
 
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
 func (oa OAuth) Callback(w http.ResponseWriter, r *http.Request) {
-	provider := chi.URLParam(r, "provider")
-	provider = strings.ToLower(provider)
-
-	config, ok := oa.ProviderConfigs[provider]
+	provider, ok := oa.provider(w, r)
 	if !ok {
-		http.Error(w, "Invalid OAuth2 Service", http.StatusBadRequest)
 		return
 	}
+	config := provider.OAuth2Config()
 
 	state := r.FormValue("state")
 	cookieState, err := readCookie(r, "oauth_state")
 	if err != nil || cookieState != state {
 		if err != nil {
-			fmt.Println(err)
+			context.Logger(r.Context()).Warn("read oauth state cookie", "err", err)
 		}
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
@@ -75,48 +71,41 @@ func (oa OAuth) Callback(w http.ResponseWriter, r *http.Request) {
 	token, err := config.Exchange(
 		r.Context(),
 		code,
-		oauth2.SetAuthURLParam("redirect_uri", redirectURI(r, provider)),
+		oauth2.SetAuthURLParam("redirect_uri", oa.redirectURI(provider.Name())),
 		oauth2.VerifierOption(verifier),
 	)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("exchange oauth code", "provider", provider.Name(), "err", err)
 		http.Error(w, "Something went wrong", http.StatusBadRequest)
 		return
 	}
 
 	client := config.Client(r.Context(), token)
-	resp, err := client.Post(
-		"https://api.dropboxapi.com/2/files/list_folder",
-		"application/json",
-		strings.NewReader(`{"path": ""}`),
-	)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	entries, err := provider.ListFolder(r.Context(), client, "")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	var pretty bytes.Buffer
-	err = json.Indent(&pretty, body, "", " ")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		context.Logger(r.Context()).Error("list cloud folder", "provider", provider.Name(), "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	pretty.WriteTo(w)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	enc.Encode(entries)
 }
 
-func redirectURI(r *http.Request, provider string) string {
-	if r.Host == "localhost:3000" {
-		return fmt.Sprintf("http://localhost:3000/oauth/%s/callback", provider)
+func (oa OAuth) provider(w http.ResponseWriter, r *http.Request) (models.CloudProvider, bool) {
+	name := strings.ToLower(chi.URLParam(r, "provider"))
+
+	provider, ok := oa.Providers[name]
+	if !ok {
+		http.Error(w, "Invalid OAuth2 Service", http.StatusBadRequest)
+		return nil, false
 	}
 
-	return fmt.Sprintf("http://lenslocked.bc-noc-dev.com/oauth/%s/callback", provider)
+	return provider, true
+}
+
+func (oa OAuth) redirectURI(provider string) string {
+	return oa.URLs.URL(fmt.Sprintf("/oauth/%s/callback", provider), nil)
 }
@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// pagination holds the links to the pages around the current one, links are
+// empty when there is no page in that direction
+type pagination struct {
+	NextURL string
+	PrevURL string
+	// NextFragmentURL returns only the items of the next page, it is used to
+	// load more items while scrolling
+	NextFragmentURL string
+}
+
+func newPagination(r *http.Request, cursors models.Cursors) pagination {
+	var p pagination
+	if cursors.Next != "" {
+		p.NextURL = pageURL(r, url.Values{"after": {cursors.Next}})
+		p.NextFragmentURL = pageURL(r, url.Values{"after": {cursors.Next}, "fragment": {"1"}})
+	}
+	if cursors.Prev != "" {
+		p.PrevURL = pageURL(r, url.Values{"before": {cursors.Prev}})
+	}
+	return p
+}
+
+// pageURL links to another page of the current path
+func pageURL(r *http.Request, query url.Values) string {
+	u := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func pageFromRequest(r *http.Request) models.Page {
+	return models.Page{
+		After:  r.URL.Query().Get("after"),
+		Before: r.URL.Query().Get("before"),
+	}
+}
+
+// isFragmentRequest is true when only the items of a list are wanted, as
+// opposed to a whole page
+func isFragmentRequest(r *http.Request) bool {
+	return r.URL.Query().Get("fragment") == "1"
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter allows Limit attempts per key in every Window. It is kept in
+// memory, which is enough as long as we run a single server.
+type RateLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	attempts int
+	resetAt  time.Time
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow counts an attempt for key and tells if it is within the limit
+func (rl *RateLimiter) Allow(key string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	// Drop finished windows so the map doesn't grow forever
+	for k, w := range rl.windows {
+		if !now.Before(w.resetAt) {
+			delete(rl.windows, k)
+		}
+	}
+
+	w, ok := rl.windows[key]
+	if !ok {
+		w = &rateWindow{resetAt: now.Add(rl.Window)}
+		rl.windows[key] = w
+	}
+	w.attempts++
+
+	return w.attempts <= rl.Limit
+}
+
+// clientIP returns the address of the visitor. X-Forwarded-For is only read
+// when trustProxy is set: anyone reaching the server directly could put
+// anything in it. In production we run behind Caddy which overwrites the
+// header with the address it sees, so the last entry is the one to trust.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+		parts := strings.Split(forwarded, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
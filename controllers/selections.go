@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// Clients come back to their selection over several visits, the cookie
+// outlives the browser session
+const selectionCookieAge = 90 * 24 * time.Hour
+
+// StartSelection starts proofing a gallery, the client picks images under
+// the name they give
+func (g Galleries) StartSelection(w http.ResponseWriter, r *http.Request) {
+	gallery, link, galleryPath, err := g.proofingGallery(w, r)
+	if err != nil {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name can't be empty", http.StatusBadRequest)
+		return
+	}
+
+	selection := models.Selection{
+		GalleryID:  gallery.ID,
+		ClientName: name,
+	}
+	if link != nil {
+		selection.ShareLinkID = &link.ID
+	}
+	err = g.SelectionService.Create(r.Context(), &selection)
+	if err != nil {
+		context.Logger(r.Context()).Error("create selection", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	cookie := newCookie(CookieSelection, selection.Token)
+	cookie.Path = galleryPath
+	cookie.Expires = time.Now().Add(selectionCookieAge)
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, galleryPath, http.StatusFound)
+}
+
+// SelectImage hearts or unhearts an image and saves the client's note on
+// it. The proofing script sends it in the background, without JavaScript
+// the client is sent back to the gallery.
+func (g Galleries) SelectImage(w http.ResponseWriter, r *http.Request) {
+	gallery, _, galleryPath, err := g.proofingGallery(w, r)
+	if err != nil {
+		return
+	}
+
+	selection, err := g.openSelection(w, r, gallery)
+	if err != nil {
+		return
+	}
+
+	filename := chi.URLParam(r, "filename")
+	_, err = g.ImageService.Image(r.Context(), gallery.ID, filename)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("query image", "gallery_id", gallery.ID, "filename", filename, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if r.FormValue("selected") == "on" {
+		err = g.SelectionService.Select(r.Context(), selection.ID, filename, r.FormValue("note"))
+	} else {
+		err = g.SelectionService.Unselect(r.Context(), selection.ID, filename)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrSelectionSubmitted) {
+			http.Error(w, "Your selection was already submitted", http.StatusConflict)
+			return
+		}
+		context.Logger(r.Context()).Error("select image", "selection_id", selection.ID, "filename", filename, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if isBackgroundRequest(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, galleryPath, http.StatusFound)
+}
+
+// SubmitSelection makes the client's selection final and lets the owner of
+// the gallery know
+func (g Galleries) SubmitSelection(w http.ResponseWriter, r *http.Request) {
+	gallery, _, galleryPath, err := g.proofingGallery(w, r)
+	if err != nil {
+		return
+	}
+
+	selection, err := g.openSelection(w, r, gallery)
+	if err != nil {
+		return
+	}
+	if len(selection.Items) == 0 {
+		http.Error(w, "Pick at least one photo before submitting", http.StatusBadRequest)
+		return
+	}
+
+	err = g.SelectionService.Submit(r.Context(), selection,
+		func(tx *sql.Tx, selection *models.Selection) error {
+			unsubscribe, err := unsubscribeURL(g.UnsubscribeTokens, g.URLs, selection.OwnerEmail)
+			if err != nil {
+				return err
+			}
+			return g.EmailService.SelectionSubmitted(r.Context(), tx, selection.OwnerEmail, models.SelectionSubmittedEmail{
+				ClientName:     selection.ClientName,
+				GalleryTitle:   gallery.Title,
+				ShareLinkLabel: selection.ShareLinkLabel,
+				Count:          len(selection.Items),
+				SelectionsURL:  g.URLs.URL(fmt.Sprintf("/galleries/%d/edit", gallery.ID), nil),
+				UnsubscribeURL: unsubscribe,
+			})
+		})
+	if err != nil {
+		if errors.Is(err, models.ErrSelectionSubmitted) {
+			http.Error(w, "Your selection was already submitted", http.StatusConflict)
+			return
+		}
+		context.Logger(r.Context()).Error("submit selection", "selection_id", selection.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, galleryPath, http.StatusFound)
+}
+
+// ExportSelection downloads the filenames a client selected, as CSV with
+// their notes or as a list that can be pasted into the Lightroom library
+// filter, or the selected images themselves as a ZIP
+func (g Galleries) ExportSelection(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionEdit)
+	if err != nil {
+		return
+	}
+
+	selectionID, err := strconv.Atoi(chi.URLParam(r, "selectionID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	selection, err := g.SelectionService.ByID(r.Context(), gallery.ID, selectionID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Selection not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("query selection", "selection_id", selectionID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "csv":
+		setAttachment(w, fmt.Sprintf("selection-%d.csv", selection.ID))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rows := [][]string{{"filename", "note"}}
+		for _, item := range selection.Items {
+			rows = append(rows, []string{csvCell(item.Filename), csvCell(item.Note)})
+		}
+		// The response has started, a failure can only be logged
+		err = csv.NewWriter(w).WriteAll(rows)
+		if err != nil {
+			context.Logger(r.Context()).Error("write selection csv", "selection_id", selection.ID, "err", err)
+		}
+	case "lightroom":
+		// Lightroom matches files without their extension, so the same list
+		// finds the raw files the proofs were exported from
+		names := make([]string, len(selection.Items))
+		for i, item := range selection.Items {
+			names[i] = strings.TrimSuffix(item.Filename, path.Ext(item.Filename))
+		}
+		setAttachment(w, fmt.Sprintf("selection-%d-lightroom.txt", selection.ID))
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, strings.Join(names, ", "))
+	case "zip":
+		name := fmt.Sprintf("%s - %s", gallery.Title, selection.ClientName)
+		g.serveArchive(w, r, gallery, selectionFilenames(selection), name)
+	default:
+		http.Error(w, "Unknown export format", http.StatusBadRequest)
+	}
+}
+
+// csvCell escapes a value clients typed so spreadsheets show it as text,
+// a leading =, +, - or @ would otherwise make it a formula
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// proofingGallery looks up the gallery a client is proofing, through a share
+// link or viewer session on /galleries/{id} or through the shared link of an
+// unlisted or public gallery. It also returns the share link the client
+// opened, if any, and the path their selection cookie is scoped to.
+// Collaborators opening /galleries/{id} work on the gallery instead of
+// proofing it.
+func (g Galleries) proofingGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, *models.ShareLink, string, error) {
+	if chi.URLParam(r, "slug") != "" {
+		gallery, err := g.sharedGallery(w, r, false)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return gallery, nil, sharedGalleryPath(gallery), nil
+	}
+
+	gallery, err := g.lookupGallery(w, r, false)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	access, ok, err := g.galleryAccess(r, gallery)
+	if err != nil {
+		context.Logger(r.Context()).Error("query gallery access", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return nil, nil, "", err
+	}
+	if !ok || access.Role != "" {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return nil, nil, "", fmt.Errorf("gallery can't be proofed")
+	}
+
+	return gallery, g.viewerShareLink(r, gallery), fmt.Sprintf("/galleries/%d", gallery.ID), nil
+}
+
+// viewerSelection returns the selection the visitor is making on the
+// gallery, nil when they haven't started one
+func (g Galleries) viewerSelection(r *http.Request, gallery *models.Gallery) *models.Selection {
+	token, err := readCookie(r, CookieSelection)
+	if err != nil {
+		return nil
+	}
+
+	selection, err := g.SelectionService.ByToken(r.Context(), gallery.ID, token)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			context.Logger(r.Context()).Error("query selection by token", "gallery_id", gallery.ID, "err", err)
+		}
+		return nil
+	}
+
+	return selection
+}
+
+// openSelection returns the visitor's selection as long as they can still
+// change it
+func (g Galleries) openSelection(w http.ResponseWriter, r *http.Request, gallery *models.Gallery) (*models.Selection, error) {
+	selection := g.viewerSelection(r, gallery)
+	if selection == nil {
+		http.Error(w, "Enter your name to start a selection first", http.StatusBadRequest)
+		return nil, fmt.Errorf("no selection")
+	}
+	if selection.Submitted() {
+		http.Error(w, "Your selection was already submitted", http.StatusConflict)
+		return nil, models.ErrSelectionSubmitted
+	}
+
+	return selection, nil
+}
+
+// isBackgroundRequest tells if the request was sent by a script that
+// doesn't follow redirects, eg. the proofing script
+func isBackgroundRequest(r *http.Request) bool {
+	return r.Header.Get("X-Requested-With") == "XMLHttpRequest"
+}
+
+func setAttachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filename,
+	}))
+}
+
+// proofing is what the gallery pages show a client proofing the gallery
+type proofing struct {
+	// URL is where the proofing forms post to
+	URL        string
+	Started    bool
+	Submitted  bool
+	ClientName string
+	Count      int
+	// DownloadURL is empty unless the client can download the images
+	DownloadURL string
+
+	selection *models.Selection
+}
+
+// proofedImage is embedded in the images of the gallery pages. SelectURL is
+// empty unless the client can still change their selection.
+type proofedImage struct {
+	Selected  bool
+	Note      string
+	SelectURL string
+}
+
+// newProofing loads the visitor's selection on a gallery served under
+// galleryPath
+func (g Galleries) newProofing(r *http.Request, gallery *models.Gallery, galleryPath string) *proofing {
+	p := proofing{
+		URL: galleryPath + "/selection",
+	}
+	p.selection = g.viewerSelection(r, gallery)
+	if p.selection != nil {
+		p.Started = true
+		p.Submitted = p.selection.Submitted()
+		p.ClientName = p.selection.ClientName
+		p.Count = len(p.selection.Items)
+	}
+	return &p
+}
+
+// image returns the proofing state of an image, p may be nil when the
+// visitor can't proof the gallery
+func (p *proofing) image(filename string) proofedImage {
+	var image proofedImage
+	if p == nil || p.selection == nil {
+		return image
+	}
+	if item := p.selection.Item(filename); item != nil {
+		image.Selected = true
+		image.Note = item.Note
+	}
+	if !p.Submitted {
+		image.SelectURL = p.URL + "/images/" + url.PathEscape(filename)
+	}
+	return image
+}
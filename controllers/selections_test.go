@@ -0,0 +1,22 @@
+package controllers
+
+import "testing"
+
+func TestCSVCell(t *testing.T) {
+	tests := map[string]string{
+		"DSC_0001.jpg":             "DSC_0001.jpg",
+		"":                         "",
+		"Crop a bit tighter":       "Crop a bit tighter",
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+1 for the album":         "'+1 for the album",
+		"-2 exposure":              "'-2 exposure",
+		"@SUM(A1:A2)":              "'@SUM(A1:A2)",
+		"\t=1+1":                   "'\t=1+1",
+		"Print at 8x10, not = A4":  "Print at 8x10, not = A4",
+	}
+	for value, want := range tests {
+		if got := csvCell(value); got != want {
+			t.Errorf("csvCell(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"archive/zip"
+	"context"
+	"database/sql"
 	"io"
 
 	"github.com/rahulbalajee/lenslocked/models"
@@ -8,35 +11,116 @@ import (
 
 // Decouple SessionService from controllers using interface
 type SessionService interface {
-	Create(userID int) (*models.Session, error) // Need to decouple this completely by defining our own types
-	User(token string) (*models.User, error)
-	Delete(token string) error
+	Create(ctx context.Context, userID int) (*models.Session, error) // Need to decouple this completely by defining our own types
+	User(ctx context.Context, token string) (*models.User, error)
+	Delete(ctx context.Context, token string) error
 }
 
 // Decouple PasswordResetService from controllers using interfaces
 type PasswordResetService interface {
-	Create(email string) (*models.PasswordReset, error)
-	Consume(token string) (*models.User, error)
+	Create(ctx context.Context, email string) (*models.PasswordReset, error)
+	Consume(ctx context.Context, token string) (*models.User, error)
 }
 
 type EmailService interface {
-	ForgotPassword(to string, resetURL string) error
-	Send(email models.Email) error
+	ForgotPassword(ctx context.Context, to string, resetURL string) error
+	GalleryInvitation(ctx context.Context, tx *sql.Tx, to string, data models.GalleryInvitationEmail) error
+	GalleryTransfer(ctx context.Context, tx *sql.Tx, to string, data models.GalleryTransferEmail) error
+	GalleryTransferred(ctx context.Context, tx *sql.Tx, to string, data models.GalleryTransferredEmail) error
+	SelectionSubmitted(ctx context.Context, tx *sql.Tx, to string, data models.SelectionSubmittedEmail) error
+	CommentPosted(ctx context.Context, tx *sql.Tx, to string, data models.CommentPostedEmail) error
+	Send(ctx context.Context, email models.Email) error
+	Unsubscribe(ctx context.Context, address string) error
 }
 
 type GalleryService interface {
-	Create(title string, userID int) (*models.Gallery, error)
-	ByID(id int) (*models.Gallery, error)
-	ByUserID(userID int) ([]models.Gallery, error)
-	Update(gallery *models.Gallery) error
-	Delete(id int) error
+	Create(ctx context.Context, gallery *models.Gallery) error
+	ByID(ctx context.Context, id int) (*models.Gallery, error)
+	BySlug(ctx context.Context, slug string) (*models.Gallery, error)
+	ByMember(ctx context.Context, userID, workspaceID int, page models.Page) (*models.GalleryPage, error)
+	Update(ctx context.Context, gallery *models.Gallery) error
+	ResetSlug(ctx context.Context, gallery *models.Gallery) error
+	SetPassword(ctx context.Context, gallery *models.Gallery, password string) error
+	Delete(ctx context.Context, id int) error
+	TrashedByID(ctx context.Context, id int) (*models.Gallery, error)
+	Trashed(ctx context.Context, userID int) ([]models.Gallery, error)
+	Restore(ctx context.Context, id int) error
+	DeleteForever(ctx context.Context, id int) error
+}
+
+type ShareLinkService interface {
+	Create(ctx context.Context, link *models.ShareLink) error
+	ByGalleryID(ctx context.Context, galleryID int) ([]models.ShareLink, error)
+	ByToken(ctx context.Context, token string) (*models.ShareLink, error)
+	Visit(ctx context.Context, token string) (*models.ShareLink, error)
+	Revoke(ctx context.Context, galleryID, id int) error
+}
+
+type CollaboratorService interface {
+	Role(ctx context.Context, gallery *models.Gallery, userID int) (models.Role, error)
+	Owns(ctx context.Context, gallery *models.Gallery, userID int) (bool, error)
+	Collaborators(ctx context.Context, galleryID int) ([]models.Collaborator, error)
+	SetRole(ctx context.Context, galleryID, userID int, role models.Role) error
+	Remove(ctx context.Context, galleryID, userID int) error
+	Invite(ctx context.Context, galleryID int, email string, role models.Role, invitedBy int, notify models.Notify[models.Invitation]) (*models.Invitation, error)
+	Invitations(ctx context.Context, galleryID int) ([]models.Invitation, error)
+	RevokeInvitation(ctx context.Context, galleryID, id int) error
+	Accept(ctx context.Context, token string, userID int) (*models.Invitation, error)
+}
+
+type TransferService interface {
+	Create(ctx context.Context, galleryID, fromUserID int, email string, notify models.Notify[models.GalleryTransfer]) (*models.GalleryTransfer, error)
+	ByGalleryID(ctx context.Context, galleryID int) (*models.GalleryTransfer, error)
+	ByToken(ctx context.Context, token string) (*models.GalleryTransfer, error)
+	Cancel(ctx context.Context, galleryID int) error
+	Accept(ctx context.Context, token string, userID, workspaceID int, notify models.Notify[models.GalleryTransfer]) (*models.GalleryTransfer, error)
+	ForceTransfer(ctx context.Context, galleryID int, email string, notify models.Notify[models.GalleryTransfer]) (*models.GalleryTransfer, error)
+}
+
+type SelectionService interface {
+	Create(ctx context.Context, selection *models.Selection) error
+	ByToken(ctx context.Context, galleryID int, token string) (*models.Selection, error)
+	ByID(ctx context.Context, galleryID, id int) (*models.Selection, error)
+	ByGalleryID(ctx context.Context, galleryID int) ([]models.Selection, error)
+	Select(ctx context.Context, selectionID int, filename, note string) error
+	Unselect(ctx context.Context, selectionID int, filename string) error
+	Submit(ctx context.Context, selection *models.Selection, notify models.Notify[models.Selection]) error
+}
+
+type CommentService interface {
+	Create(ctx context.Context, comment *models.Comment, notify models.Notify[models.Comment]) error
+	ByID(ctx context.Context, galleryID, id int) (*models.Comment, error)
+	ByGalleryID(ctx context.Context, galleryID int) ([]models.Comment, error)
+	Approved(ctx context.Context, galleryID int) ([]models.Comment, error)
+	SetStatus(ctx context.Context, galleryID, id int, status models.CommentStatus) error
+	Delete(ctx context.Context, galleryID, id int) error
+}
+
+type WorkspaceService interface {
+	Create(ctx context.Context, name string, userID int) (*models.Workspace, error)
+	ByUserID(ctx context.Context, userID int) ([]models.Workspace, error)
+	Rename(ctx context.Context, id int, name string) error
+	Members(ctx context.Context, workspaceID int) ([]models.WorkspaceMember, error)
+	AddMember(ctx context.Context, workspaceID int, email string, role models.Role) error
+	SetRole(ctx context.Context, workspaceID, userID int, role models.Role) error
+	RemoveMember(ctx context.Context, workspaceID, userID int) error
 }
 
 type ImageService interface {
-	Images(galleryID int) ([]models.Image, error)
-	Image(galleryId int, filename string) (models.Image, error)
-	DeleteImage(galleryID int, filename string) error
-	DeleteAllGalleryImages(galleryID int) error
-	CreateImage(galleryID int, filename string, contents io.Reader) error
-	CreateImageViaURL(galleryID int, url string) error
+	Images(ctx context.Context, galleryID int) ([]models.Image, error)
+	ImagesPage(ctx context.Context, galleryID int, page models.Page) (*models.ImagePage, error)
+	ImageSummaries(ctx context.Context, galleryIDs []int) (map[int]models.ImageSummary, error)
+	Image(ctx context.Context, galleryId int, filename string) (models.Image, error)
+	DeleteImage(ctx context.Context, galleryID int, filename string) error
+	DeleteAllGalleryImages(ctx context.Context, galleryID int) error
+	CreateImage(ctx context.Context, galleryID int, filename string, contents io.Reader) error
+	CreateImageViaURL(ctx context.Context, galleryID int, url string) error
+	Reorder(ctx context.Context, galleryID int, filenames []string) error
+	UpdateDetails(ctx context.Context, galleryID int, details []models.ImageDetails) error
+	TrashedImages(ctx context.Context, galleryID int) ([]models.TrashedImage, error)
+	TrashedImage(ctx context.Context, galleryID int, trashName string) (models.Image, error)
+	RestoreImage(ctx context.Context, galleryID int, trashName string) error
+	DeleteTrashedImage(ctx context.Context, galleryID int, trashName string) error
+	Archive(ctx context.Context, galleryID int, filenames []string, size models.ImageSize) (*models.Archive, error)
+	ImportEntries(zr *zip.Reader, folders bool) ([]models.ImportEntry, error)
 }
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+// CreateShareLink creates a share link and shows it to the owner. Only the
+// hash of the token is stored, so this is the only time the link is shown.
+func (g Galleries) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	link := models.ShareLink{
+		GalleryID:     gallery.ID,
+		Label:         r.FormValue("label"),
+		AllowDownload: r.FormValue("allow_download") == "on",
+	}
+
+	if value := r.FormValue("expires_at"); value != "" {
+		date, err := time.Parse(eventDateLayout, value)
+		if err != nil {
+			http.Error(w, "Expiry must be a valid date", http.StatusBadRequest)
+			return
+		}
+		// Links stay valid until the end of the chosen day
+		expiresAt := date.AddDate(0, 0, 1)
+		if !expiresAt.After(time.Now()) {
+			http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+			return
+		}
+		link.ExpiresAt = &expiresAt
+	}
+
+	if value := r.FormValue("max_views"); value != "" {
+		link.MaxViews, err = strconv.Atoi(value)
+		if err != nil || link.MaxViews < 0 {
+			http.Error(w, "Max views must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = g.ShareLinkService.Create(r.Context(), &link)
+	if err != nil {
+		context.Logger(r.Context()).Error("create share link", "gallery_id", gallery.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	var data struct {
+		GalleryID int
+		Title     string
+		Label     string
+		URL       string
+	}
+	data.GalleryID = gallery.ID
+	data.Title = gallery.Title
+	data.Label = link.Label
+	data.URL = g.URLs.URL("/share/"+link.Token, nil)
+
+	g.Template.ShareLink.Execute(w, r, data)
+}
+
+func (g Galleries) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	linkID, err := strconv.Atoi(chi.URLParam(r, "linkID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	err = g.ShareLinkService.Revoke(r.Context(), gallery.ID, linkID)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Share link not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("revoke share link", "gallery_id", gallery.ID, "link_id", linkID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	editPath := fmt.Sprintf("/galleries/%d/edit", gallery.ID)
+	http.Redirect(w, r, editPath, http.StatusFound)
+}
+
+// VisitShareLink counts a visit of a share link and starts a viewer session
+// scoped to the shared gallery
+func (g Galleries) VisitShareLink(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	link, err := g.ShareLinkService.Visit(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, models.ErrLinkExpired) {
+			http.Error(w, "This link has expired", http.StatusGone)
+			return
+		}
+		if errors.Is(err, models.ErrNotFound) {
+			http.Error(w, "Share link not found", http.StatusNotFound)
+			return
+		}
+		context.Logger(r.Context()).Error("visit share link", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	galleryPath := fmt.Sprintf("/galleries/%d", link.GalleryID)
+
+	cookie := newCookie(CookieShare, token)
+	cookie.Path = galleryPath
+	if link.ExpiresAt != nil {
+		cookie.Expires = *link.ExpiresAt
+	}
+	http.SetCookie(w, cookie)
+
+	http.Redirect(w, r, galleryPath, http.StatusFound)
+}
+
+// viewerShareLink returns the share link of the visitor's viewer session for
+// the gallery, nil when there is none or the link was revoked or expired
+func (g Galleries) viewerShareLink(r *http.Request, gallery *models.Gallery) *models.ShareLink {
+	token, err := readCookie(r, CookieShare)
+	if err != nil {
+		return nil
+	}
+
+	link, err := g.ShareLinkService.ByToken(r.Context(), token)
+	if err != nil {
+		if !errors.Is(err, models.ErrNotFound) {
+			context.Logger(r.Context()).Error("query share link by token", "gallery_id", gallery.ID, "err", err)
+		}
+		return nil
+	}
+
+	if link.GalleryID != gallery.ID || link.Expired() {
+		return nil
+	}
+
+	return link
+}
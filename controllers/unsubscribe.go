@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/securecookie"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/urls"
+)
+
+// unsubscribeTokenName is what unsubscribe tokens are signed under, so other
+// values signed with the same key can't be passed off as one
+const unsubscribeTokenName = "unsubscribe"
+
+// Unsubscribe stops notification emails to an address. The links carry the
+// address signed with Tokens, which proves the visitor got the email, so no
+// account or CSRF token is needed and mail clients can unsubscribe in one
+// click (RFC 8058).
+type Unsubscribe struct {
+	Template struct {
+		Unsubscribe Executer
+	}
+	EmailService EmailService
+	Tokens       *securecookie.SecureCookie
+}
+
+type unsubscribeData struct {
+	Email string
+	Token string
+	// Done is true once the address was unsubscribed
+	Done bool
+}
+
+// Show asks to confirm, link checkers open links in emails so a GET must
+// not unsubscribe
+func (u Unsubscribe) Show(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	address, err := u.address(token)
+	if err != nil {
+		http.Error(w, "This unsubscribe link is invalid", http.StatusNotFound)
+		return
+	}
+
+	u.Template.Unsubscribe.Execute(w, r, unsubscribeData{Email: address, Token: token})
+}
+
+// Process unsubscribes the address, it handles both the confirmation form
+// and the one-click POST of mail clients
+func (u Unsubscribe) Process(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	address, err := u.address(token)
+	if err != nil {
+		http.Error(w, "This unsubscribe link is invalid", http.StatusNotFound)
+		return
+	}
+
+	err = u.EmailService.Unsubscribe(r.Context(), address)
+	if err != nil {
+		context.Logger(r.Context()).Error("unsubscribe", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	u.Template.Unsubscribe.Execute(w, r, unsubscribeData{Email: address, Done: true})
+}
+
+func (u Unsubscribe) address(token string) (string, error) {
+	var address string
+	err := u.Tokens.Decode(unsubscribeTokenName, token, &address)
+	return address, err
+}
+
+// unsubscribeURL builds the link that unsubscribes address from
+// notification emails
+func unsubscribeURL(tokens *securecookie.SecureCookie, urlBuilder *urls.Builder, address string) (string, error) {
+	token, err := tokens.Encode(unsubscribeTokenName, address)
+	if err != nil {
+		return "", err
+	}
+	return urlBuilder.URL("/unsubscribe/"+token, nil), nil
+}
+
+// SkipCSRF lets requests under prefix through the CSRF check. It must come
+// before the CSRF middleware and is only meant for endpoints that carry
+// their own proof of who sent them, eg. signed unsubscribe links.
+func SkipCSRF(prefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				r = csrf.UnsafeSkipCheck(r)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package controllers
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/rahulbalajee/lenslocked/context/context"
@@ -15,19 +14,26 @@ func (umw UserMiddleware) SetUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token, err := readCookie(r, CookieSession)
 		if err != nil {
-			fmt.Println(err)
+			// Most visitors simply aren't signed in
+			context.Logger(r.Context()).Debug("no session cookie", "err", err)
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		user, err := umw.SessionService.User(token)
+		user, err := umw.SessionService.User(r.Context(), token)
 		if err != nil {
-			fmt.Println(err)
+			context.Logger(r.Context()).Info("invalid session", "err", err)
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		if entry := context.AccessLog(r.Context()); entry != nil {
+			entry.UserID = user.ID
+		}
+
 		ctx := context.WithUser(r.Context(), user)
+		// Everything logged from here on is tagged with the user
+		ctx = context.WithLogger(ctx, context.Logger(ctx).With("user_id", user.ID))
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
@@ -43,3 +49,16 @@ func (umw UserMiddleware) RequireUser(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireAdmin must run after RequireUser, pages only admins can use don't
+// exist for anyone else
+func (umw UserMiddleware) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil || !user.Admin {
+			http.Error(w, "Page not found", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,14 +2,13 @@ package controllers
 
 import (
 	"database/sql"
-	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 
 	"github.com/rahulbalajee/lenslocked/context/context"
 	"github.com/rahulbalajee/lenslocked/errors"
 	"github.com/rahulbalajee/lenslocked/models"
+	"github.com/rahulbalajee/lenslocked/urls"
 )
 
 type Users struct {
@@ -25,6 +24,7 @@ type Users struct {
 	SessionService       SessionService       // decoupled with interface (best practice) Interface connection happens in line 46 in main.go
 	PasswordResetService PasswordResetService // decoupled with interface
 	EmailService         EmailService         // decoupled with interface
+	URLs                 *urls.Builder        // builds the absolute links we send in emails
 }
 
 func (u Users) SignUp(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +44,7 @@ func (u Users) ProcessSignUp(w http.ResponseWriter, r *http.Request) {
 	data.Email = r.FormValue("email")
 	data.Password = r.FormValue("password")
 
-	user, err := u.UserService.Create(data.Email, data.Password)
+	user, err := u.UserService.Create(r.Context(), data.Email, data.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrEmailTaken) {
 			err = errors.Public(err, "Email address is already taken.")
@@ -53,9 +53,9 @@ func (u Users) ProcessSignUp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	session, err := u.SessionService.Create(r.Context(), user.ID)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Warn("create session after sign up", "err", err)
 		// TODO: Show a warning message to the user
 		http.Redirect(w, r, "/signin", http.StatusFound)
 		return
@@ -84,23 +84,23 @@ func (u Users) ProcessSignIn(w http.ResponseWriter, r *http.Request) {
 	data.Email = r.FormValue("email")
 	data.Password = r.FormValue("password")
 
-	user, err := u.UserService.Authenticate(data.Email, data.Password)
+	user, err := u.UserService.Authenticate(r.Context(), data.Email, data.Password)
 	// Check for SQL ErrNoRows in case the user tries to login without signing up first
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Redirect(w, r, "/signup", http.StatusFound)
 		return
 	}
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Info("sign in failed", "err", err)
 		err = errors.Public(err, "Check your username and password.")
 		u.Templates.SignIn.Execute(w, r, data, err)
 		return
 	}
 
 	// Create a new session token for the user and set cookie
-	session, err := u.SessionService.Create(user.ID)
+	session, err := u.SessionService.Create(r.Context(), user.ID)
 	if err != nil {
-		log.Println(err)
+		context.Logger(r.Context()).Error("create session after sign in", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -120,15 +120,15 @@ func (u Users) CurrentUser(w http.ResponseWriter, r *http.Request) {
 func (u Users) ProcessSignOut(w http.ResponseWriter, r *http.Request) {
 	token, err := readCookie(r, CookieSession)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Warn("sign out without session cookie", "err", err)
 		http.Redirect(w, r, "/signin", http.StatusFound)
 		return
 	}
 
 	// Delete the session token from the DB and remove the cookie from user's browser = log them out
-	err = u.SessionService.Delete(token)
+	err = u.SessionService.Delete(r.Context(), token)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("delete session", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -153,9 +153,9 @@ func (u Users) ProcessForgotPassword(w http.ResponseWriter, r *http.Request) {
 	}
 	data.Email = r.FormValue("email")
 
-	pwReset, err := u.PasswordResetService.Create(data.Email)
+	pwReset, err := u.PasswordResetService.Create(r.Context(), data.Email)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Info("create password reset", "err", err)
 		if errors.Is(err, sql.ErrNoRows) {
 			err = errors.Public(err, "Email does not exists in our database. Please sign up first.")
 		}
@@ -166,12 +166,11 @@ func (u Users) ProcessForgotPassword(w http.ResponseWriter, r *http.Request) {
 	vals := url.Values{
 		"token": {pwReset.Token},
 	}
-	// TODO: Make the URL here configurable
-	resetURL := "http://localhost:3000/reset-pw?" + vals.Encode()
+	resetURL := u.URLs.URL("/reset-pw", vals)
 
-	err = u.EmailService.ForgotPassword(data.Email, resetURL)
+	err = u.EmailService.ForgotPassword(r.Context(), data.Email, resetURL)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("send forgot password email", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -199,24 +198,24 @@ func (u Users) ProcessResetPassword(w http.ResponseWriter, r *http.Request) {
 	data.Token = r.FormValue("token")
 	data.Password = r.FormValue("password")
 
-	user, err := u.PasswordResetService.Consume(data.Token)
+	user, err := u.PasswordResetService.Consume(r.Context(), data.Token)
 	if err != nil {
 		// TODO: Handle different errors
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("consume password reset", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
-	err = u.UserService.UpdatePassword(user.ID, data.Password)
+	err = u.UserService.UpdatePassword(r.Context(), user.ID, data.Password)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("update password", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
-	session, err := u.SessionService.Create(user.ID)
+	session, err := u.SessionService.Create(r.Context(), user.ID)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Warn("create session after password reset", "err", err)
 		http.Redirect(w, r, "/signin", http.StatusFound)
 		return
 	}
@@ -231,23 +230,23 @@ func (u Users) UpdateEmail(w http.ResponseWriter, r *http.Request) {
 
 	newEmail := r.FormValue("email")
 
-	err := u.UserService.UpdateEmail(user.ID, newEmail)
+	err := u.UserService.UpdateEmail(r.Context(), user.ID, newEmail)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("update email", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
 	token, err := readCookie(r, CookieSession)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("read session cookie", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
 
-	err = u.SessionService.Delete(token)
+	err = u.SessionService.Delete(r.Context(), token)
 	if err != nil {
-		fmt.Println(err)
+		context.Logger(r.Context()).Error("delete session", "err", err)
 		http.Error(w, "Something went wrong", http.StatusInternalServerError)
 		return
 	}
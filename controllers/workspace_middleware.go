@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+type WorkspaceMiddleware struct {
+	WorkspaceService WorkspaceService
+}
+
+// SetWorkspaces makes the workspaces of the signed in user and the one they
+// picked in the switcher available to handlers and templates, it must run
+// after UserMiddleware.SetUser. They are only queried once something asks
+// for them. Every user gets a personal workspace when signing up.
+func (wmw WorkspaceMiddleware) SetWorkspaces(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if user == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := context.WithWorkspaces(r.Context(), func() ([]models.Workspace, *models.Workspace) {
+			workspaces, err := wmw.WorkspaceService.ByUserID(r.Context(), user.ID)
+			if err != nil {
+				context.Logger(r.Context()).Error("query workspaces by user", "err", err)
+				return nil, nil
+			}
+			return workspaces, currentWorkspace(r, workspaces)
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// currentWorkspace returns the workspace picked in the switcher, nil when
+// the user looks at all of them or isn't a member of the one in the cookie
+// anymore
+func currentWorkspace(r *http.Request, workspaces []models.Workspace) *models.Workspace {
+	value, err := readCookie(r, CookieWorkspace)
+	if err != nil {
+		return nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+
+	for i := range workspaces {
+		if workspaces[i].ID == id {
+			return &workspaces[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rahulbalajee/lenslocked/context/context"
+	"github.com/rahulbalajee/lenslocked/errors"
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+type Workspaces struct {
+	Template struct {
+		Index Executer
+		Show  Executer
+	}
+	WorkspaceService WorkspaceService
+}
+
+func (ws Workspaces) Index(w http.ResponseWriter, r *http.Request) {
+	type Workspace struct {
+		ID      int
+		Name    string
+		Role    string
+		Current bool
+	}
+	var data struct {
+		Workspaces []Workspace
+	}
+
+	current := context.Workspace(r.Context())
+	for _, workspace := range context.Workspaces(r.Context()) {
+		data.Workspaces = append(data.Workspaces, Workspace{
+			ID:      workspace.ID,
+			Name:    workspace.Name,
+			Role:    workspace.Role.Label(),
+			Current: current != nil && current.ID == workspace.ID,
+		})
+	}
+
+	ws.Template.Index.Execute(w, r, data)
+}
+
+// Create creates a workspace owned by the current user and switches to it
+func (ws Workspaces) Create(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name can't be empty", http.StatusBadRequest)
+		return
+	}
+
+	user := context.User(r.Context())
+	workspace, err := ws.WorkspaceService.Create(r.Context(), name, user.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("create workspace", "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	setCookie(w, CookieWorkspace, strconv.Itoa(workspace.ID))
+
+	workspacePath := fmt.Sprintf("/workspaces/%d", workspace.ID)
+	http.Redirect(w, r, workspacePath, http.StatusFound)
+}
+
+func (ws Workspaces) Show(w http.ResponseWriter, r *http.Request) {
+	workspace, err := ws.workspaceByID(w, r, models.PermissionView)
+	if err != nil {
+		return
+	}
+
+	ws.renderShow(w, r, workspace)
+}
+
+// Rename renames a workspace, only its owners can
+func (ws Workspaces) Rename(w http.ResponseWriter, r *http.Request) {
+	workspace, err := ws.workspaceByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		http.Error(w, "Name can't be empty", http.StatusBadRequest)
+		return
+	}
+
+	err = ws.WorkspaceService.Rename(r.Context(), workspace.ID, name)
+	if err != nil {
+		context.Logger(r.Context()).Error("rename workspace", "workspace_id", workspace.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	workspacePath := fmt.Sprintf("/workspaces/%d", workspace.ID)
+	http.Redirect(w, r, workspacePath, http.StatusFound)
+}
+
+// AddMember adds an existing account to the workspace by email
+func (ws Workspaces) AddMember(w http.ResponseWriter, r *http.Request) {
+	workspace, err := ws.workspaceByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	err = ws.WorkspaceService.AddMember(r.Context(), workspace.ID, strings.TrimSpace(r.FormValue("email")), role)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			err = errors.Public(err, "There is no account with that email address, ask them to sign up first.")
+			ws.renderShow(w, r, workspace, err)
+			return
+		}
+		context.Logger(r.Context()).Error("add workspace member", "workspace_id", workspace.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	workspacePath := fmt.Sprintf("/workspaces/%d", workspace.ID)
+	http.Redirect(w, r, workspacePath, http.StatusFound)
+}
+
+func (ws Workspaces) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	workspace, err := ws.workspaceByID(w, r, models.PermissionManage)
+	if err != nil {
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	err = ws.WorkspaceService.SetRole(r.Context(), workspace.ID, userID, role)
+	if err != nil {
+		ws.memberError(w, r, workspace, userID, err)
+		return
+	}
+
+	workspacePath := fmt.Sprintf("/workspaces/%d", workspace.ID)
+	http.Redirect(w, r, workspacePath, http.StatusFound)
+}
+
+// RemoveMember removes a member from the workspace. Owners can remove anyone,
+// other members can only leave.
+func (ws Workspaces) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return
+	}
+
+	user := context.User(r.Context())
+	perm := models.PermissionManage
+	if userID == user.ID {
+		perm = models.PermissionView
+	}
+
+	workspace, err := ws.workspaceByID(w, r, perm)
+	if err != nil {
+		return
+	}
+
+	err = ws.WorkspaceService.RemoveMember(r.Context(), workspace.ID, userID)
+	if err != nil {
+		ws.memberError(w, r, workspace, userID, err)
+		return
+	}
+
+	if userID == user.ID {
+		http.Redirect(w, r, "/workspaces", http.StatusFound)
+		return
+	}
+	workspacePath := fmt.Sprintf("/workspaces/%d", workspace.ID)
+	http.Redirect(w, r, workspacePath, http.StatusFound)
+}
+
+// Switch changes the workspace the user is working in, an empty
+// workspace_id shows all of them
+func (ws Workspaces) Switch(w http.ResponseWriter, r *http.Request) {
+	value := r.FormValue("workspace_id")
+	if value == "" {
+		deleteCookie(w, CookieWorkspace)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+	_, err = findWorkspace(r, id)
+	if err != nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return
+	}
+
+	setCookie(w, CookieWorkspace, strconv.Itoa(id))
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+func (ws Workspaces) renderShow(w http.ResponseWriter, r *http.Request, workspace *models.Workspace, errs ...error) {
+	type Option struct {
+		Value string
+		Label string
+	}
+	type Member struct {
+		UserID int
+		Email  string
+		Role   string
+		Self   bool
+	}
+	var data struct {
+		ID        int
+		Name      string
+		Role      string
+		CanManage bool
+		Roles     []Option
+		Members   []Member
+	}
+	data.ID = workspace.ID
+	data.Name = workspace.Name
+	data.Role = workspace.Role.Label()
+	data.CanManage = workspace.Role.Can(models.PermissionManage)
+	for _, role := range models.Roles {
+		data.Roles = append(data.Roles, Option{
+			Value: string(role),
+			Label: role.Label(),
+		})
+	}
+
+	members, err := ws.WorkspaceService.Members(r.Context(), workspace.ID)
+	if err != nil {
+		context.Logger(r.Context()).Error("query workspace members", "workspace_id", workspace.ID, "err", err)
+		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		return
+	}
+	user := context.User(r.Context())
+	for _, member := range members {
+		data.Members = append(data.Members, Member{
+			UserID: member.UserID,
+			Email:  member.Email,
+			Role:   string(member.Role),
+			Self:   member.UserID == user.ID,
+		})
+	}
+
+	ws.Template.Show.Execute(w, r, data, errs...)
+}
+
+// memberError responds to a failed change of the members of a workspace
+func (ws Workspaces) memberError(w http.ResponseWriter, r *http.Request, workspace *models.Workspace, userID int, err error) {
+	if errors.Is(err, models.ErrLastOwner) {
+		err = errors.Public(err, "A workspace needs at least one owner, make someone else an owner first.")
+		ws.renderShow(w, r, workspace, err)
+		return
+	}
+	if errors.Is(err, models.ErrNotFound) {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+	context.Logger(r.Context()).Error("change workspace member", "workspace_id", workspace.ID, "user_id", userID, "err", err)
+	http.Error(w, "Something went wrong", http.StatusInternalServerError)
+}
+
+// workspaceByID looks up the workspace in the URL among the workspaces of
+// the current user and checks their role grants perm. Workspaces the user
+// isn't a member of are reported as not found.
+func (ws Workspaces) workspaceByID(w http.ResponseWriter, r *http.Request, perm models.Permission) (*models.Workspace, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusNotFound)
+		return nil, err
+	}
+
+	workspace, err := findWorkspace(r, id)
+	if err != nil {
+		http.Error(w, "Workspace not found", http.StatusNotFound)
+		return nil, err
+	}
+	if !workspace.Role.Can(perm) {
+		http.Error(w, "You are not authorized to do this", http.StatusForbidden)
+		return nil, fmt.Errorf("%s can't do this", workspace.Role)
+	}
+
+	return workspace, nil
+}
+
+// findWorkspace returns the workspace with id if the current user is a
+// member of it, see WorkspaceMiddleware
+func findWorkspace(r *http.Request, id int) (*models.Workspace, error) {
+	for _, workspace := range context.Workspaces(r.Context()) {
+		if workspace.ID == id {
+			return &workspace, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "lenslocked"
+
+// Registry holds every Lenslocked metric. We use our own registry instead of
+// the global one so nothing registers metrics behind our back.
+var Registry = newRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by chi route pattern.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by chi route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	ImageUploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_uploads_total",
+		Help:      "Images uploaded, by result (success or rejected or error).",
+	}, []string{"result"})
+
+	ImageUploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_upload_bytes_total",
+		Help:      "Bytes of image data stored.",
+	})
+
+	ImageProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_processing_duration_seconds",
+		Help:      "Time spent processing images, by operation.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+
+	EmailsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_sent_total",
+		Help:      "Email delivery attempts, by result (success or failure).",
+	}, []string{"result"})
+)
+
+// newRegistry returns a registry with the runtime metrics and the metrics
+// declared above
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		ImageUploads,
+		ImageUploadBytes,
+		ImageProcessingDuration,
+		EmailsSent,
+	)
+	return reg
+}
+
+// RegisterDB exposes the sql.DB connection pool stats
+func RegisterDB(db *sql.DB) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// RegisterActiveSessions exposes a gauge computed by count. Counting reads
+// the whole sessions table, so the result is reused for maxAge instead of
+// counting again on every scrape.
+func RegisterActiveSessions(count func(ctx context.Context) (int, error), maxAge time.Duration) error {
+	sessions := &cachedCount{count: count, maxAge: maxAge}
+	return Registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_sessions",
+		Help:      "Sessions currently stored in the database.",
+	}, sessions.value))
+}
+
+// cachedCount calls count at most once every maxAge
+type cachedCount struct {
+	count  func(ctx context.Context) (int, error)
+	maxAge time.Duration
+
+	mu        sync.Mutex
+	last      float64
+	updatedAt time.Time
+}
+
+func (cc *cachedCount) value() float64 {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if !cc.updatedAt.IsZero() && time.Since(cc.updatedAt) < cc.maxAge {
+		return cc.last
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := cc.count(ctx)
+	if err != nil {
+		// NaN tells Prometheus the value is unknown rather than zero
+		cc.last = math.NaN()
+	} else {
+		cc.last = float64(n)
+	}
+	cc.updatedAt = time.Now()
+
+	return cc.last
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
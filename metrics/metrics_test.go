@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCachedCount(t *testing.T) {
+	var calls int
+	cc := &cachedCount{
+		count: func(ctx context.Context) (int, error) {
+			calls++
+			return 3, nil
+		},
+		maxAge: time.Hour,
+	}
+
+	for range 3 {
+		if got := cc.value(); got != 3 {
+			t.Errorf("value() = %v, want 3", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("count called %d times within maxAge, want 1", calls)
+	}
+
+	cc.updatedAt = time.Now().Add(-2 * time.Hour)
+	cc.value()
+	if calls != 2 {
+		t.Errorf("count called %d times after maxAge, want 2", calls)
+	}
+}
+
+func TestCachedCountError(t *testing.T) {
+	cc := &cachedCount{
+		count: func(ctx context.Context) (int, error) {
+			return 0, errors.New("database is down")
+		},
+		maxAge: time.Hour,
+	}
+
+	if got := cc.value(); !math.IsNaN(got) {
+		t.Errorf("value() = %v, want NaN when counting fails", got)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	// The sessions gauge can only be registered once per registry
+	global := Registry
+	Registry = newRegistry()
+	t.Cleanup(func() { Registry = global })
+
+	var calls int
+	err := RegisterActiveSessions(func(ctx context.Context) (int, error) {
+		calls++
+		return 7, nil
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("RegisterActiveSessions() err = %v", err)
+	}
+	// The counters are shared by every registry, so only count on the
+	// increment
+	sent := EmailsSent.WithLabelValues("success")
+	want := testutil.ToFloat64(sent) + 1
+	sent.Inc()
+
+	var body string
+	for range 2 {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("scrape status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		b, err := io.ReadAll(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = string(b)
+	}
+
+	for _, want := range []string{
+		"lenslocked_active_sessions 7",
+		fmt.Sprintf(`lenslocked_emails_sent_total{result="success"} %v`, want),
+		"go_goroutines",
+		"process_cpu_seconds_total",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("scrape doesn't contain %q", want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("sessions counted %d times for 2 scrapes, want 1", calls)
+	}
+}
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE email_outbox
+    ADD COLUMN reply_to TEXT NOT NULL DEFAULT '',
+    ADD COLUMN unsubscribe_url TEXT NOT NULL DEFAULT '',
+    ADD COLUMN message_id TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE email_outbox
+    DROP COLUMN reply_to,
+    DROP COLUMN unsubscribe_url,
+    DROP COLUMN message_id;
+-- +goose StatementEnd
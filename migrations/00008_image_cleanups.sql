@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Files can't take part in a transaction, so deleting a gallery queues the
+-- removal of its images here in the same transaction and a background job
+-- does the actual work, retrying until it succeeds.
+-- No foreign key on gallery_id on purpose, the gallery is already gone.
+CREATE TABLE image_cleanups (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE image_cleanups;
+-- +goose StatementEnd
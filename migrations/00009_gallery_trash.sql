@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries ADD COLUMN deleted_at TIMESTAMPTZ;
+CREATE INDEX galleries_deleted_at_idx ON galleries (deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries DROP COLUMN deleted_at;
+-- +goose StatementEnd
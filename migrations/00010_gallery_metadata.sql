@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN cover_image TEXT NOT NULL DEFAULT '',
+    ADD COLUMN event_date DATE,
+    ADD COLUMN location TEXT NOT NULL DEFAULT '',
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+UPDATE galleries SET published = FALSE WHERE published IS NULL;
+ALTER TABLE galleries ALTER COLUMN published SET NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ALTER COLUMN published DROP NOT NULL,
+    DROP COLUMN description,
+    DROP COLUMN cover_image,
+    DROP COLUMN event_date,
+    DROP COLUMN location,
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at;
+-- +goose StatementEnd
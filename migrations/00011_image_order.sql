@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE images (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    position INT NOT NULL DEFAULT 0,
+    captured_at TIMESTAMPTZ,
+    uploaded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (gallery_id, filename)
+);
+ALTER TABLE galleries ADD COLUMN sort_mode TEXT NOT NULL DEFAULT 'manual';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries DROP COLUMN sort_mode;
+DROP TABLE images;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE images
+    ADD COLUMN title TEXT NOT NULL DEFAULT '',
+    ADD COLUMN caption TEXT NOT NULL DEFAULT '',
+    ADD COLUMN alt_text TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE images
+    DROP COLUMN title,
+    DROP COLUMN caption,
+    DROP COLUMN alt_text;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries
+    ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private'
+        CHECK (visibility IN ('private', 'unlisted', 'public')),
+    ADD COLUMN slug TEXT UNIQUE;
+UPDATE galleries SET visibility = 'public' WHERE published;
+UPDATE galleries SET slug = replace(gen_random_uuid()::text, '-', '');
+ALTER TABLE galleries
+    ALTER COLUMN slug SET NOT NULL,
+    DROP COLUMN published;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries ADD COLUMN published BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE galleries SET published = TRUE WHERE visibility = 'public';
+ALTER TABLE galleries
+    DROP COLUMN visibility,
+    DROP COLUMN slug;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''; -- empty when the gallery has no password
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries DROP COLUMN password_hash;
+-- +goose StatementEnd
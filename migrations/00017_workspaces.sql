@@ -0,0 +1,43 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE workspaces (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_by INT REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE workspace_members (
+    workspace_id INT NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'contributor', 'editor', 'owner')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (workspace_id, user_id)
+);
+CREATE INDEX workspace_members_user_id_idx ON workspace_members (user_id);
+
+-- Every existing user gets a personal workspace holding the galleries they
+-- created
+INSERT INTO workspaces (name, created_by)
+SELECT 'Personal', id FROM users;
+
+INSERT INTO workspace_members (workspace_id, user_id, role)
+SELECT id, created_by, 'owner' FROM workspaces;
+
+ALTER TABLE galleries ADD COLUMN workspace_id INT REFERENCES workspaces (id);
+
+UPDATE galleries
+SET workspace_id = workspaces.id
+FROM workspaces
+WHERE workspaces.created_by = galleries.user_id;
+
+ALTER TABLE galleries ALTER COLUMN workspace_id SET NOT NULL;
+CREATE INDEX galleries_workspace_id_idx ON galleries (workspace_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE galleries DROP COLUMN workspace_id;
+DROP TABLE workspace_members;
+DROP TABLE workspaces;
+-- +goose StatementEnd
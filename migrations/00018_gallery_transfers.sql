@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Admins are made by hand, eg. UPDATE users SET admin = true WHERE email = '...'
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE gallery_transfers (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT UNIQUE NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    from_user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE gallery_transfers;
+ALTER TABLE users DROP COLUMN admin;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE selections (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    -- The share link the client opened the gallery with, NULL on public galleries
+    share_link_id INT REFERENCES share_links (id) ON DELETE SET NULL,
+    client_name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    submitted_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX selections_gallery_id_idx ON selections (gallery_id);
+
+CREATE TABLE selection_items (
+    selection_id INT NOT NULL REFERENCES selections (id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (selection_id, filename)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE selection_items;
+DROP TABLE selections;
+-- +goose StatementEnd
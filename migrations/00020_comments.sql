@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE galleries ADD COLUMN comments_enabled BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE comments (
+    id SERIAL PRIMARY KEY,
+    gallery_id INT NOT NULL REFERENCES galleries (id) ON DELETE CASCADE,
+    -- The image the comment is on, empty for comments on the gallery itself
+    filename TEXT NOT NULL DEFAULT '',
+    parent_id INT REFERENCES comments (id) ON DELETE CASCADE,
+    -- NULL for visitors without an account
+    user_id INT REFERENCES users (id) ON DELETE SET NULL,
+    author_name TEXT NOT NULL,
+    body TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'approved', 'hidden')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX comments_gallery_id_idx ON comments (gallery_id);
+CREATE INDEX comments_parent_id_idx ON comments (parent_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE comments;
+ALTER TABLE galleries DROP COLUMN comments_enabled;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Trashed images keep their row so they return to their old place when
+-- restored. Their file is moved to the trash under trash_name, filename
+-- alone isn't unique there since an image can be deleted, uploaded again
+-- and deleted again.
+ALTER TABLE images
+    ADD COLUMN deleted_at TIMESTAMPTZ,
+    ADD COLUMN trash_name TEXT,
+    DROP CONSTRAINT images_gallery_id_filename_key;
+CREATE UNIQUE INDEX images_gallery_id_filename_key ON images (gallery_id, filename) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX images_gallery_id_trash_name_key ON images (gallery_id, trash_name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM images WHERE deleted_at IS NOT NULL;
+DROP INDEX images_gallery_id_trash_name_key;
+DROP INDEX images_gallery_id_filename_key;
+ALTER TABLE images
+    ADD CONSTRAINT images_gallery_id_filename_key UNIQUE (gallery_id, filename),
+    DROP COLUMN deleted_at,
+    DROP COLUMN trash_name;
+-- +goose StatementEnd
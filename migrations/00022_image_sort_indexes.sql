@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Pages of images seek to the sort key of the last image they showed, one
+-- index per sort mode keeps that from reading the whole gallery. Sorting by
+-- filename uses images_gallery_id_filename_key.
+CREATE INDEX images_manual_order_idx ON images (gallery_id, position, filename) WHERE deleted_at IS NULL;
+CREATE INDEX images_captured_order_idx ON images (gallery_id, COALESCE(captured_at, uploaded_at), filename) WHERE deleted_at IS NULL;
+CREATE INDEX images_uploaded_order_idx ON images (gallery_id, uploaded_at, filename) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX images_uploaded_order_idx;
+DROP INDEX images_captured_order_idx;
+DROP INDEX images_manual_order_idx;
+-- +goose StatementEnd
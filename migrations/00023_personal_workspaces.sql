@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Personal workspaces used to be created on the first page a new user
+-- loaded, now signing up creates them. Users who don't have a workspace yet
+-- get theirs here.
+WITH personal AS (
+    INSERT INTO workspaces (name, created_by)
+    SELECT 'Personal', id FROM users
+    WHERE NOT EXISTS (
+        SELECT 1 FROM workspace_members WHERE workspace_members.user_id = users.id
+    )
+    RETURNING id, created_by
+)
+INSERT INTO workspace_members (workspace_id, user_id, role)
+SELECT id, created_by, 'owner' FROM personal;
+-- +goose StatementEnd
+
+-- +goose Down
+-- Nothing to undo, the workspaces are like any other personal workspace
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Senders claim emails by setting them to 'sending' with next_attempt_at as
+-- the end of the claim, emails of a sender that died are due again once it
+-- has passed.
+DROP INDEX email_outbox_pending_idx;
+CREATE INDEX email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status IN ('pending', 'sending');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE email_outbox SET status = 'pending' WHERE status = 'sending';
+DROP INDEX email_outbox_pending_idx;
+CREATE INDEX email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
+-- +goose StatementEnd
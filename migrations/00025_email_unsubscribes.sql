@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Addresses that no longer want notification emails, transactional emails
+-- (password resets, invitations...) are still sent to them
+CREATE TABLE email_unsubscribes (
+    email TEXT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE email_unsubscribes;
+-- +goose StatementEnd
@@ -0,0 +1,309 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// ImageSize picks the version of the images that goes into an archive
+type ImageSize string
+
+const (
+	ImageSizeOriginal ImageSize = "original"
+	// ImageSizeWeb shrinks large images to webImageSize pixels
+	ImageSizeWeb ImageSize = "web"
+)
+
+func (s ImageSize) Valid() bool {
+	return s == ImageSizeOriginal || s == ImageSizeWeb
+}
+
+// Archive is a ZIP of gallery images that is written straight to the
+// client. Images are stored without compression, they are compressed
+// already, and every header is derived from the files on disk, so the
+// same images always make the same bytes. That lets the download be
+// resumed from any offset without the archive ever being kept around.
+// Neither the archive nor the checksums of its images are cached, writing
+// from an offset reads all the images before it again.
+type Archive struct {
+	// Size is the length of the archive in bytes
+	Size int64
+	// ModTime is the time the newest image was last changed
+	ModTime time.Time
+	// ETag changes whenever an image is added, removed or changed
+	ETag string
+
+	files []archiveFile
+}
+
+type archiveFile struct {
+	name    string
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Archive prepares a ZIP of the images of a gallery in the gallery's sort
+// order, or of only the images in filenames when it isn't nil. Filenames
+// that aren't images of the gallery, eg. images deleted since they were
+// picked or paths into other directories, are left out. Web-size images
+// are made as needed, before Archive returns.
+func (is *ImageService) Archive(ctx context.Context, galleryID int, filenames []string, size ImageSize) (*Archive, error) {
+	var images []Image
+	if filenames == nil {
+		var err error
+		images, err = is.Images(ctx, galleryID)
+		if err != nil {
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, filename := range filenames {
+			if seen[filename] || !validFilename(filename) || !hasExtension(filename, is.extensions()) {
+				continue
+			}
+			seen[filename] = true
+			image, err := is.Image(ctx, galleryID, filename)
+			if err != nil {
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return nil, fmt.Errorf("archive: %w", err)
+			}
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return nil, fmt.Errorf("archive: %w", ErrNotFound)
+	}
+
+	archive := Archive{}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n", size)
+	for _, image := range images {
+		info, err := os.Stat(image.Path)
+		if err != nil {
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+
+		path := image.Path
+		if size == ImageSizeWeb {
+			path, err = is.webImage(image, info)
+			if err != nil {
+				return nil, fmt.Errorf("archive: %w", err)
+			}
+			info, err = os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("archive: %w", err)
+			}
+		}
+
+		file := archiveFile{
+			name: image.Filename,
+			path: path,
+			size: info.Size(),
+			// ZIP timestamps have a precision of one second
+			modTime: info.ModTime().UTC().Truncate(time.Second),
+		}
+		archive.files = append(archive.files, file)
+		if file.modTime.After(archive.ModTime) {
+			archive.ModTime = file.modTime
+		}
+		fmt.Fprintf(hash, "%s\n%d\n%d\n", file.name, file.size, file.modTime.Unix())
+	}
+	archive.ETag = fmt.Sprintf(`"%x"`, hash.Sum(nil)[:16])
+
+	var err error
+	archive.Size, err = archive.size()
+	if err != nil {
+		return nil, fmt.Errorf("archive: %w", err)
+	}
+
+	return &archive, nil
+}
+
+// WriteTo writes the whole archive to w
+func (a *Archive) WriteTo(w io.Writer) (int64, error) {
+	n, err := a.write(w, func(file archiveFile) (io.ReadCloser, error) {
+		f, err := os.Open(file.path)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	})
+	if err != nil {
+		return n, err
+	}
+	// An image replaced since the archive was prepared makes the archive a
+	// different size than promised
+	if n != a.Size {
+		return n, fmt.Errorf("write archive: images changed while writing")
+	}
+	return n, nil
+}
+
+func (a *Archive) write(w io.Writer, open func(archiveFile) (io.ReadCloser, error)) (int64, error) {
+	counter := countWriter{w: w}
+	zw := zip.NewWriter(&counter)
+	for _, file := range a.files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     file.name,
+			Method:   zip.Store,
+			Modified: file.modTime,
+		})
+		if err != nil {
+			return counter.n, fmt.Errorf("write archive: %w", err)
+		}
+
+		contents, err := open(file)
+		if err != nil {
+			return counter.n, fmt.Errorf("write archive: %w", err)
+		}
+		_, err = io.Copy(fw, contents)
+		contents.Close()
+		if err != nil {
+			return counter.n, fmt.Errorf("write archive: %w", err)
+		}
+	}
+
+	err := zw.Close()
+	if err != nil {
+		return counter.n, fmt.Errorf("write archive: %w", err)
+	}
+	return counter.n, nil
+}
+
+// size works out the length of the archive without reading the images.
+// Stored files add exactly their size to the archive, until it grows past
+// 4GiB and needs ZIP64 records. Only then is every byte counted.
+func (a *Archive) size() (int64, error) {
+	headers, err := a.write(io.Discard, func(archiveFile) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("archive size: %w", err)
+	}
+
+	var contents int64
+	for _, file := range a.files {
+		contents += file.size
+	}
+	if headers+contents < math.MaxUint32 {
+		return headers + contents, nil
+	}
+
+	return a.write(io.Discard, func(file archiveFile) (io.ReadCloser, error) {
+		return io.NopCloser(io.LimitReader(zeros{}, file.size)), nil
+	})
+}
+
+// zeros reads as an endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// countWriter counts the bytes written through it
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Reader returns the archive as an io.ReadSeeker for http.ServeContent. The
+// archive is only written once read, from the offset sought to, so a
+// resumed download doesn't send the start again. The images before the
+// offset are still read, the central directory at the end needs their
+// checksums. Close stops the writing.
+func (a *Archive) Reader() io.ReadSeekCloser {
+	return &archiveReader{archive: a}
+}
+
+type archiveReader struct {
+	archive *Archive
+	offset  int64
+	pipe    *io.PipeReader
+}
+
+func (ar *archiveReader) Read(p []byte) (int, error) {
+	if ar.offset >= ar.archive.Size {
+		return 0, io.EOF
+	}
+
+	if ar.pipe == nil {
+		pr, pw := io.Pipe()
+		skip := ar.offset
+		go func() {
+			_, err := ar.archive.WriteTo(&skipWriter{w: pw, skip: skip})
+			pw.CloseWithError(err)
+		}()
+		ar.pipe = pr
+	}
+
+	n, err := ar.pipe.Read(p)
+	ar.offset += int64(n)
+	return n, err
+}
+
+func (ar *archiveReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += ar.offset
+	case io.SeekEnd:
+		offset += ar.archive.Size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek archive: negative offset")
+	}
+
+	// The archive is written front to back, going anywhere else means
+	// writing it again
+	if offset != ar.offset {
+		ar.Close()
+	}
+	ar.offset = offset
+	return offset, nil
+}
+
+func (ar *archiveReader) Close() error {
+	if ar.pipe != nil {
+		ar.pipe.Close()
+		ar.pipe = nil
+	}
+	return nil
+}
+
+// skipWriter drops the first skip bytes written to it
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (sw *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if sw.skip >= int64(n) {
+		sw.skip -= int64(n)
+		return n, nil
+	}
+	_, err := sw.w.Write(p[sw.skip:])
+	sw.skip = 0
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// Number of cleanup jobs picked up per iteration
+	cleanupBatchSize = 20
+	// DefaultGCInterval is how often orphaned image directories are collected
+	DefaultGCInterval = time.Hour
+	// DefaultTrashRetention is how long deleted galleries and images stay in the trash
+	DefaultTrashRetention = 30 * 24 * time.Hour
+)
+
+// CleanupService removes files that outlived their database rows. Deleting a
+// gallery queues an image_cleanups job in the same transaction, and a
+// periodic garbage collector catches anything that slipped through (eg. rows
+// deleted by hand). Galleries and images that stayed in the trash for longer
+// than TrashRetention are purged on the same schedule, and the images table
+// is synced with image files added or removed by hand.
+type CleanupService struct {
+	DB             *sql.DB
+	GalleryService *GalleryService
+	ImageService   *ImageService
+
+	// How often to look for orphaned image directories, defaults to DefaultGCInterval
+	GCInterval time.Duration
+	// How long trashed items are kept, defaults to DefaultTrashRetention
+	TrashRetention time.Duration
+}
+
+// Run processes cleanup jobs every interval and collects garbage every
+// GCInterval until ctx is cancelled
+func (cs *CleanupService) Run(ctx context.Context, interval time.Duration) {
+	gcInterval := cs.GCInterval
+	if gcInterval == 0 {
+		gcInterval = DefaultGCInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	gcTicker := time.NewTicker(gcInterval)
+	defer gcTicker.Stop()
+
+	// Pick up files changed while the server was down right away
+	cs.syncImages(ctx)
+
+	for {
+		err := cs.processJobs(ctx)
+		if err != nil {
+			slog.Error("process image cleanups", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-gcTicker.C:
+			removed, err := cs.CollectGarbage(ctx)
+			if err != nil {
+				slog.Error("collect orphaned images", "err", err)
+			}
+			if removed > 0 {
+				slog.Info("collected orphaned image directories", "count", removed)
+			}
+			cs.purgeTrash(ctx)
+			cs.syncImages(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrash permanently deletes galleries and images that have been in the
+// trash for longer than TrashRetention
+func (cs *CleanupService) purgeTrash(ctx context.Context) {
+	retention := cs.TrashRetention
+	if retention == 0 {
+		retention = DefaultTrashRetention
+	}
+
+	galleries, err := cs.GalleryService.PurgeTrash(ctx, retention)
+	if err != nil {
+		slog.Error("purge trashed galleries", "err", err)
+	}
+	if galleries > 0 {
+		slog.Info("purged trashed galleries", "count", galleries)
+	}
+
+	images, err := cs.ImageService.PurgeTrash(ctx, retention)
+	if err != nil {
+		slog.Error("purge trashed images", "err", err)
+	}
+	if images > 0 {
+		slog.Info("purged trashed images", "count", images)
+	}
+}
+
+// syncImages creates the rows of image files copied into gallery directories
+// by hand and removes the rows of files deleted by hand
+func (cs *CleanupService) syncImages(ctx context.Context) {
+	err := cs.ImageService.SyncImages(ctx)
+	if err != nil {
+		slog.Error("sync images", "err", err)
+	}
+}
+
+// processJobs removes the images of deleted galleries. Failed jobs are retried
+// with the same backoff as emails.
+func (cs *CleanupService) processJobs(ctx context.Context) error {
+	tx, err := cs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("process image cleanups: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, gallery_id, attempts
+		FROM image_cleanups
+		WHERE next_attempt_at <= NOW()
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED;`, cleanupBatchSize)
+	if err != nil {
+		return fmt.Errorf("process image cleanups: %w", err)
+	}
+
+	type job struct {
+		ID        int
+		GalleryID int
+		Attempts  int
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		err = rows.Scan(&j.ID, &j.GalleryID, &j.Attempts)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("process image cleanups: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("process image cleanups: %w", err)
+	}
+
+	for _, j := range jobs {
+		removeErr := cs.ImageService.DeleteAllGalleryImages(ctx, j.GalleryID)
+		if removeErr == nil {
+			_, err = tx.ExecContext(ctx, `
+				DELETE FROM image_cleanups
+				WHERE id = $1;`, j.ID)
+		} else {
+			attempts := j.Attempts + 1
+			slog.Warn("remove gallery images", "gallery_id", j.GalleryID, "attempts", attempts, "err", removeErr)
+			_, err = tx.ExecContext(ctx, `
+				UPDATE image_cleanups
+				SET attempts = $2, last_error = $3, next_attempt_at = $4
+				WHERE id = $1;`, j.ID, attempts, removeErr.Error(), time.Now().Add(outboxBackoff(attempts)))
+		}
+		if err != nil {
+			return fmt.Errorf("process image cleanups: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("process image cleanups: %w", err)
+	}
+
+	return nil
+}
+
+// CollectGarbage removes image directories whose gallery no longer exists and
+// returns how many were removed
+func (cs *CleanupService) CollectGarbage(ctx context.Context) (int, error) {
+	dirs, err := cs.ImageService.galleryDirs()
+	if err != nil {
+		return 0, fmt.Errorf("collect garbage: %w", err)
+	}
+	if len(dirs) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := cs.DB.QueryContext(ctx, `
+		SELECT id FROM galleries;`)
+	if err != nil {
+		return 0, fmt.Errorf("collect garbage: %w", err)
+	}
+	defer rows.Close()
+
+	existing := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, fmt.Errorf("collect garbage: %w", err)
+		}
+		existing[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("collect garbage: %w", err)
+	}
+
+	var removed int
+	for _, galleryID := range dirs {
+		if existing[galleryID] {
+			continue
+		}
+		err = cs.ImageService.DeleteAllGalleryImages(ctx, galleryID)
+		if err != nil {
+			return removed, fmt.Errorf("collect garbage: %w", err)
+		}
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// CloudConfig holds everything needed to talk to a cloud storage provider.
+// Endpoint fields are optional, each provider falls back to its own defaults
+// when they are empty. The JSON keys match the env vars, see
+// ReadCloudConfigFile.
+type CloudConfig struct {
+	ClientID     string   `json:"app_id"`
+	ClientSecret string   `json:"app_secret"`
+	Scopes       []string `json:"scopes"`
+	AuthURL      string   `json:"auth_url"`
+	TokenURL     string   `json:"token_url"`
+	APIURL       string   `json:"api_url"`
+	ContentURL   string   `json:"content_url"`
+}
+
+// ReadCloudConfigFile reads the providers configured in a JSON file, keyed
+// by provider name, eg. {"dropbox": {"app_id": "...", "app_secret": "..."}}.
+// Unknown keys are rejected so a typo doesn't silently drop a setting.
+func ReadCloudConfigFile(path string) (map[string]CloudConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cloud config: %w", err)
+	}
+	defer f.Close()
+
+	var configs map[string]CloudConfig
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&configs)
+	if err != nil {
+		return nil, fmt.Errorf("read cloud config %v: %w", path, err)
+	}
+
+	providers := make(map[string]CloudConfig, len(configs))
+	for name, config := range configs {
+		providers[strings.ToLower(name)] = config
+	}
+
+	return providers, nil
+}
+
+// CloudEntry is a single file or folder returned when listing a folder
+type CloudEntry struct {
+	ID       string
+	Name     string
+	Path     string
+	IsFolder bool
+	Size     int64
+	Modified time.Time
+}
+
+// CloudProvider is implemented by every service we can import images from.
+// The *http.Client passed in is expected to already be authenticated, usually
+// via oauth2.Config.Client.
+type CloudProvider interface {
+	Name() string
+	OAuth2Config() *oauth2.Config
+	// Extra options the provider needs when building the consent page URL
+	AuthCodeOptions() []oauth2.AuthCodeOption
+	ListFolder(ctx context.Context, client *http.Client, path string) ([]CloudEntry, error)
+	File(ctx context.Context, client *http.Client, path string) (io.ReadCloser, error)
+	Thumbnail(ctx context.Context, client *http.Client, path string) (io.ReadCloser, error)
+}
+
+// cloudProviders maps a provider name to its constructor. New providers
+// (Google Drive, OneDrive, WebDAV...) only need to be registered here.
+var cloudProviders = map[string]func(CloudConfig) CloudProvider{
+	"dropbox": NewDropboxProvider,
+}
+
+// NewCloudProvider builds the provider registered under name
+func NewCloudProvider(name string, config CloudConfig) (CloudProvider, error) {
+	newProvider, ok := cloudProviders[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("new cloud provider: unknown provider %q", name)
+	}
+
+	return newProvider(config), nil
+}
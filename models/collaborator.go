@@ -0,0 +1,354 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInvitationDuration is how long an invitation can be accepted for
+	DefaultInvitationDuration = 7 * 24 * time.Hour
+)
+
+// Collaborator is a user with a role on a gallery they didn't create
+type Collaborator struct {
+	GalleryID int
+	UserID    int
+	Email     string
+	Role      Role
+	CreatedAt time.Time
+}
+
+// Invitation asks someone by email to collaborate on a gallery, they get
+// the role once they accept it
+type Invitation struct {
+	ID        int
+	GalleryID int
+	Email     string
+	Role      Role
+	// Token is only set when the invitation is created
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type CollaboratorService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+	// How long invitations are valid for, defaults to DefaultInvitationDuration
+	InvitationDuration time.Duration
+}
+
+// Role returns the role of a user on the gallery, ErrNotFound when they have
+// none. Users get the best of their role as a collaborator and their role in
+// the gallery's workspace. The user owning the gallery is an owner only while
+// they are a member of its workspace, so leaving the workspace revokes it.
+func (cs *CollaboratorService) Role(ctx context.Context, gallery *Gallery, userID int) (Role, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := cs.DB.QueryContext(ctx, `
+		SELECT role, FALSE
+		FROM gallery_collaborators
+		WHERE gallery_id = $1 AND user_id = $2
+		UNION ALL
+		SELECT role, TRUE
+		FROM workspace_members
+		WHERE workspace_id = $3 AND user_id = $2;`, gallery.ID, userID, gallery.WorkspaceID)
+	if err != nil {
+		return "", fmt.Errorf("query collaborator role: %w", err)
+	}
+	defer rows.Close()
+
+	var best Role
+	for rows.Next() {
+		var role Role
+		var member bool
+		err = rows.Scan(&role, &member)
+		if err != nil {
+			return "", fmt.Errorf("query collaborator role: %w", err)
+		}
+		if member && gallery.UserID == userID {
+			role = RoleOwner
+		}
+		if best == "" || slices.Index(Roles, role) > slices.Index(Roles, best) {
+			best = role
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", fmt.Errorf("query collaborator role: %w", err)
+	}
+
+	if best == "" {
+		return "", ErrNotFound
+	}
+
+	return best, nil
+}
+
+// Owns tells if the user owns the gallery, they must have created it or had
+// it transferred to them and still be a member of its workspace
+func (cs *CollaboratorService) Owns(ctx context.Context, gallery *Gallery, userID int) (bool, error) {
+	if gallery.UserID != userID {
+		return false, nil
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var member bool
+	err := cs.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM workspace_members
+			WHERE workspace_id = $1 AND user_id = $2
+		);`, gallery.WorkspaceID, userID).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("query gallery owner: %w", err)
+	}
+
+	return member, nil
+}
+
+// Collaborators lists the collaborators of a gallery, the user who created it
+// isn't included
+func (cs *CollaboratorService) Collaborators(ctx context.Context, galleryID int) ([]Collaborator, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := cs.DB.QueryContext(ctx, `
+		SELECT gallery_collaborators.gallery_id,
+			gallery_collaborators.user_id,
+			users.email,
+			gallery_collaborators.role,
+			gallery_collaborators.created_at
+		FROM gallery_collaborators
+			JOIN users ON users.id = gallery_collaborators.user_id
+		WHERE gallery_collaborators.gallery_id = $1
+		ORDER BY users.email;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("query collaborators: %w", err)
+	}
+	defer rows.Close()
+
+	var collaborators []Collaborator
+	for rows.Next() {
+		var c Collaborator
+		err = rows.Scan(&c.GalleryID, &c.UserID, &c.Email, &c.Role, &c.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query collaborators: %w", err)
+		}
+		collaborators = append(collaborators, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query collaborators: %w", err)
+	}
+
+	return collaborators, nil
+}
+
+// SetRole changes the role of an existing collaborator
+func (cs *CollaboratorService) SetRole(ctx context.Context, galleryID, userID int, role Role) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := cs.DB.ExecContext(ctx, `
+		UPDATE gallery_collaborators
+		SET role = $3
+		WHERE gallery_id = $1 AND user_id = $2;`, galleryID, userID, role)
+	if err != nil {
+		return fmt.Errorf("set collaborator role: %w", err)
+	}
+
+	return expectRows(result, "set collaborator role")
+}
+
+// Remove takes away the access of a collaborator
+func (cs *CollaboratorService) Remove(ctx context.Context, galleryID, userID int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := cs.DB.ExecContext(ctx, `
+		DELETE FROM gallery_collaborators
+		WHERE gallery_id = $1 AND user_id = $2;`, galleryID, userID)
+	if err != nil {
+		return fmt.Errorf("remove collaborator: %w", err)
+	}
+
+	return expectRows(result, "remove collaborator")
+}
+
+// Invite creates an invitation for email, inviting the same email again
+// replaces the previous invitation. notify queues the email with the token
+// along with it.
+func (cs *CollaboratorService) Invite(ctx context.Context, galleryID int, email string, role Role, invitedBy int, notify Notify[Invitation]) (*Invitation, error) {
+	token, tokenHash, err := cs.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	duration := cs.InvitationDuration
+	if duration == 0 {
+		duration = DefaultInvitationDuration
+	}
+
+	invitation := Invitation{
+		GalleryID: galleryID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		Token:     token,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(duration),
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := cs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO gallery_invitations (gallery_id, email, role, invited_by, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (gallery_id, email) DO
+		UPDATE
+		SET role = $3, invited_by = $4, token_hash = $5, expires_at = $6, created_at = NOW()
+		RETURNING id, created_at;`,
+		invitation.GalleryID, invitation.Email, invitation.Role, invitedBy, invitation.TokenHash, invitation.ExpiresAt)
+
+	err = row.Scan(&invitation.ID, &invitation.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	err = notify(tx, &invitation)
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("create invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// Invitations lists the pending invitations of a gallery, expired ones
+// included
+func (cs *CollaboratorService) Invitations(ctx context.Context, galleryID int) ([]Invitation, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := cs.DB.QueryContext(ctx, `
+		SELECT id, gallery_id, email, role, token_hash, expires_at, created_at
+		FROM gallery_invitations
+		WHERE gallery_id = $1
+		ORDER BY created_at DESC, id DESC;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("query invitations: %w", err)
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		var i Invitation
+		err = rows.Scan(&i.ID, &i.GalleryID, &i.Email, &i.Role, &i.TokenHash, &i.ExpiresAt, &i.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query invitations: %w", err)
+		}
+		invitations = append(invitations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation deletes a pending invitation
+func (cs *CollaboratorService) RevokeInvitation(ctx context.Context, galleryID, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := cs.DB.ExecContext(ctx, `
+		DELETE FROM gallery_invitations
+		WHERE id = $1 AND gallery_id = $2;`, id, galleryID)
+	if err != nil {
+		return fmt.Errorf("revoke invitation: %w", err)
+	}
+
+	return expectRows(result, "revoke invitation")
+}
+
+// Accept makes the user a collaborator with the role of the invitation and
+// deletes it. The user who created the gallery keeps being its owner.
+func (cs *CollaboratorService) Accept(ctx context.Context, token string, userID int) (*Invitation, error) {
+	tokenHash := cs.TokenManager.Hash(token)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := cs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+	defer tx.Rollback()
+
+	var invitation Invitation
+	row := tx.QueryRowContext(ctx, `
+		DELETE FROM gallery_invitations
+		WHERE token_hash = $1
+		RETURNING id, gallery_id, email, role, expires_at, created_at;`, tokenHash)
+	err = row.Scan(&invitation.ID, &invitation.GalleryID, &invitation.Email, &invitation.Role,
+		&invitation.ExpiresAt, &invitation.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	if time.Now().After(invitation.ExpiresAt) {
+		return nil, fmt.Errorf("accept invitation: %w", ErrLinkExpired)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO gallery_collaborators (gallery_id, user_id, role)
+		SELECT id, $2, $3 FROM galleries WHERE id = $1 AND user_id <> $2
+		ON CONFLICT (gallery_id, user_id) DO
+		UPDATE
+		SET role = $3;`, invitation.GalleryID, userID, invitation.Role)
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("accept invitation: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// expectRows returns ErrNotFound when result didn't affect any row
+func expectRows(result sql.Result, op string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
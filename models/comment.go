@@ -0,0 +1,245 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CommentStatus decides who can see a comment
+type CommentStatus string
+
+const (
+	// CommentPending comments wait for the owner of the gallery to approve
+	// them, only they can see them
+	CommentPending CommentStatus = "pending"
+	// CommentApproved comments are shown to everyone who can see the gallery
+	CommentApproved CommentStatus = "approved"
+	// CommentHidden comments were taken down by the owner of the gallery
+	CommentHidden CommentStatus = "hidden"
+)
+
+func (s CommentStatus) Valid() bool {
+	switch s {
+	case CommentPending, CommentApproved, CommentHidden:
+		return true
+	}
+	return false
+}
+
+// Comment is left on a gallery or one of its images, replies point at the
+// comment they answer
+type Comment struct {
+	ID        int
+	GalleryID int
+	// Filename is the image the comment is on, empty for the gallery itself
+	Filename string
+	ParentID *int
+	// UserID is nil for visitors without an account
+	UserID     *int
+	AuthorName string
+	Body       string
+	Status     CommentStatus
+	CreatedAt  time.Time
+	// Replies is only filled in by Threads
+	Replies []*Comment
+	// OwnerEmail is the address of the owner of the gallery, it is only set
+	// by Create
+	OwnerEmail string
+}
+
+type CommentService struct {
+	DB *sql.DB
+}
+
+const commentColumns = `id, gallery_id, filename, parent_id, user_id, author_name, body, status, created_at`
+
+func scanComment(row scanner) (Comment, error) {
+	var comment Comment
+	err := row.Scan(
+		&comment.ID,
+		&comment.GalleryID,
+		&comment.Filename,
+		&comment.ParentID,
+		&comment.UserID,
+		&comment.AuthorName,
+		&comment.Body,
+		&comment.Status,
+		&comment.CreatedAt,
+	)
+	return comment, err
+}
+
+// Create saves a comment, notify queues the email telling the owner of the
+// gallery about it along with it. Replies must be on the same gallery and
+// image as their parent, ErrNotFound otherwise.
+func (cs *CommentService) Create(ctx context.Context, comment *Comment, notify Notify[Comment]) error {
+	comment.AuthorName = strings.TrimSpace(comment.AuthorName)
+	comment.Body = strings.TrimSpace(comment.Body)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := cs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO comments (gallery_id, filename, parent_id, user_id, author_name, body, status)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE $3::INT IS NULL OR EXISTS (
+			SELECT 1 FROM comments
+			WHERE id = $3 AND gallery_id = $1 AND filename = $2
+		)
+		RETURNING id, created_at, (
+			SELECT users.email FROM galleries
+				JOIN users ON users.id = galleries.user_id
+			WHERE galleries.id = $1
+		);`,
+		comment.GalleryID, comment.Filename, comment.ParentID, comment.UserID,
+		comment.AuthorName, comment.Body, comment.Status)
+	err = row.Scan(&comment.ID, &comment.CreatedAt, &comment.OwnerEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("create comment: %w", err)
+	}
+
+	err = notify(tx, comment)
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+
+	return nil
+}
+
+func (cs *CommentService) ByID(ctx context.Context, galleryID, id int) (*Comment, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := cs.DB.QueryRowContext(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments
+		WHERE gallery_id = $1 AND id = $2;`, galleryID, id)
+	comment, err := scanComment(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query comment by id: %w", err)
+	}
+
+	return &comment, nil
+}
+
+// ByGalleryID lists every comment on a gallery and its images for the owner
+// to moderate, pending ones first and then newest first
+func (cs *CommentService) ByGalleryID(ctx context.Context, galleryID int) ([]Comment, error) {
+	return cs.query(ctx, "query comments", `
+		WHERE gallery_id = $1
+		ORDER BY status = $2 DESC, created_at DESC, id DESC`, galleryID, CommentPending)
+}
+
+// Approved lists the comments everyone can see on a gallery and its images,
+// oldest first so conversations read in order
+func (cs *CommentService) Approved(ctx context.Context, galleryID int) ([]Comment, error) {
+	return cs.query(ctx, "query approved comments", `
+		WHERE gallery_id = $1 AND status = $2
+		ORDER BY created_at, id`, galleryID, CommentApproved)
+}
+
+// SetStatus approves or hides a comment
+func (cs *CommentService) SetStatus(ctx context.Context, galleryID, id int, status CommentStatus) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := cs.DB.ExecContext(ctx, `
+		UPDATE comments
+		SET status = $3
+		WHERE gallery_id = $1 AND id = $2;`, galleryID, id, status)
+	if err != nil {
+		return fmt.Errorf("set comment status: %w", err)
+	}
+
+	return expectRows(result, "set comment status")
+}
+
+// Delete deletes a comment along with its replies
+func (cs *CommentService) Delete(ctx context.Context, galleryID, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := cs.DB.ExecContext(ctx, `
+		DELETE FROM comments
+		WHERE gallery_id = $1 AND id = $2;`, galleryID, id)
+	if err != nil {
+		return fmt.Errorf("delete comment: %w", err)
+	}
+
+	return expectRows(result, "delete comment")
+}
+
+func (cs *CommentService) query(ctx context.Context, op, where string, args ...any) ([]Comment, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := cs.DB.QueryContext(ctx, `
+		SELECT `+commentColumns+`
+		FROM comments
+		`+where+`;`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		comments = append(comments, comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return comments, nil
+}
+
+// Threads nests replies under the comment they answer and groups the
+// threads by the image they are on, "" for the gallery itself. Replies to a
+// comment that isn't in comments, eg. because it is hidden, are left out
+// with it. Comments keep their order within each thread.
+func Threads(comments []Comment) map[string][]*Comment {
+	byID := make(map[int]*Comment, len(comments))
+	for i := range comments {
+		byID[comments[i].ID] = &comments[i]
+	}
+
+	threads := make(map[string][]*Comment)
+	for i := range comments {
+		comment := &comments[i]
+		if comment.ParentID == nil {
+			threads[comment.Filename] = append(threads[comment.Filename], comment)
+			continue
+		}
+		if parent, ok := byID[*comment.ParentID]; ok {
+			parent.Replies = append(parent.Replies, comment)
+		}
+	}
+
+	return threads
+}
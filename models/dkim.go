@@ -0,0 +1,87 @@
+package models
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// DKIMConfig holds what's needed to sign outgoing mail. The public half of
+// PrivateKey must be published at <Selector>._domainkey.<Domain>.
+type DKIMConfig struct {
+	Domain     string
+	Selector   string
+	PrivateKey crypto.Signer
+}
+
+// ParseDKIMKey parses a PEM encoded RSA or Ed25519 private key
+func ParseDKIMKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("parse dkim key: no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse dkim key: %w", err)
+		}
+		return key, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse dkim key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("parse dkim key: unsupported key type %T", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("parse dkim key: unsupported PEM block %q", block.Type)
+	}
+}
+
+// Headers covered by the signature, see RFC 6376 section 5.4.1
+var dkimHeaderKeys = []string{
+	"From", "Reply-To", "To", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// signDKIM writes msg to a buffer and prepends a DKIM-Signature header
+func signDKIM(config DKIMConfig, msg io.WriterTo) (rawMessage, error) {
+	var unsigned bytes.Buffer
+	_, err := msg.WriteTo(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("sign dkim: %w", err)
+	}
+
+	var signed bytes.Buffer
+	err = dkim.Sign(&signed, &unsigned, &dkim.SignOptions{
+		Domain:                 config.Domain,
+		Selector:               config.Selector,
+		Signer:                 config.PrivateKey,
+		HeaderCanonicalization: dkim.CanonicalizationRelaxed,
+		BodyCanonicalization:   dkim.CanonicalizationRelaxed,
+		HeaderKeys:             dkimHeaderKeys,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sign dkim: %w", err)
+	}
+
+	return rawMessage(signed.Bytes()), nil
+}
+
+// rawMessage lets an already serialised message be handed to an EmailTransport
+type rawMessage []byte
+
+func (rm rawMessage) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(rm)
+	return int64(n), err
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	dropboxAuthURL    = "https://www.dropbox.com/oauth2/authorize"
+	dropboxTokenURL   = "https://api.dropboxapi.com/oauth2/token"
+	dropboxAPIURL     = "https://api.dropboxapi.com/2"
+	dropboxContentURL = "https://content.dropboxapi.com/2"
+)
+
+type DropboxProvider struct {
+	config     *oauth2.Config
+	apiURL     string
+	contentURL string
+}
+
+func NewDropboxProvider(config CloudConfig) CloudProvider {
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"files.metadata.read", "files.content.read"}
+	}
+
+	dp := DropboxProvider{
+		config: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  valueOrDefault(config.AuthURL, dropboxAuthURL),
+				TokenURL: valueOrDefault(config.TokenURL, dropboxTokenURL),
+			},
+		},
+		apiURL:     strings.TrimSuffix(valueOrDefault(config.APIURL, dropboxAPIURL), "/"),
+		contentURL: strings.TrimSuffix(valueOrDefault(config.ContentURL, dropboxContentURL), "/"),
+	}
+
+	return &dp
+}
+
+func (dp *DropboxProvider) Name() string {
+	return "dropbox"
+}
+
+func (dp *DropboxProvider) OAuth2Config() *oauth2.Config {
+	return dp.config
+}
+
+func (dp *DropboxProvider) AuthCodeOptions() []oauth2.AuthCodeOption {
+	// Dropbox only hands out refresh tokens when asked for offline access
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("token_access_type", "offline"),
+	}
+}
+
+type dropboxEntry struct {
+	Tag            string    `json:".tag"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	PathDisplay    string    `json:"path_display"`
+	Size           int64     `json:"size"`
+	ServerModified time.Time `json:"server_modified"`
+}
+
+type dropboxListFolderResult struct {
+	Entries []dropboxEntry `json:"entries"`
+	Cursor  string         `json:"cursor"`
+	HasMore bool           `json:"has_more"`
+}
+
+func (dp *DropboxProvider) ListFolder(ctx context.Context, client *http.Client, path string) ([]CloudEntry, error) {
+	// Dropbox wants the root folder as an empty string rather than "/"
+	if path == "/" {
+		path = ""
+	}
+
+	var result dropboxListFolderResult
+	err := dp.rpc(ctx, client, "/files/list_folder", map[string]string{"path": path}, &result)
+	if err != nil {
+		return nil, fmt.Errorf("dropbox list folder: %w", err)
+	}
+
+	entries := dp.entries(result.Entries)
+	for result.HasMore {
+		cursor := result.Cursor
+		result = dropboxListFolderResult{}
+		err = dp.rpc(ctx, client, "/files/list_folder/continue", map[string]string{"cursor": cursor}, &result)
+		if err != nil {
+			return nil, fmt.Errorf("dropbox list folder: %w", err)
+		}
+		entries = append(entries, dp.entries(result.Entries)...)
+	}
+
+	return entries, nil
+}
+
+func (dp *DropboxProvider) File(ctx context.Context, client *http.Client, path string) (io.ReadCloser, error) {
+	arg := map[string]string{"path": path}
+
+	body, err := dp.content(ctx, client, "/files/download", arg)
+	if err != nil {
+		return nil, fmt.Errorf("dropbox file: %w", err)
+	}
+
+	return body, nil
+}
+
+func (dp *DropboxProvider) Thumbnail(ctx context.Context, client *http.Client, path string) (io.ReadCloser, error) {
+	arg := map[string]any{
+		"resource": map[string]string{
+			".tag": "path",
+			"path": path,
+		},
+		"format": "jpeg",
+		"size":   "w256h256",
+	}
+
+	body, err := dp.content(ctx, client, "/files/get_thumbnail_v2", arg)
+	if err != nil {
+		return nil, fmt.Errorf("dropbox thumbnail: %w", err)
+	}
+
+	return body, nil
+}
+
+func (dp *DropboxProvider) entries(dbxEntries []dropboxEntry) []CloudEntry {
+	entries := make([]CloudEntry, 0, len(dbxEntries))
+	for _, e := range dbxEntries {
+		// Deleted entries only show up when explicitly requested, skip anything else we don't know about
+		if e.Tag != "file" && e.Tag != "folder" {
+			continue
+		}
+		entries = append(entries, CloudEntry{
+			ID:       e.ID,
+			Name:     e.Name,
+			Path:     e.PathDisplay,
+			IsFolder: e.Tag == "folder",
+			Size:     e.Size,
+			Modified: e.ServerModified,
+		})
+	}
+	return entries
+}
+
+// rpc calls one of the Dropbox RPC endpoints, which take and return JSON bodies
+func (dp *DropboxProvider) rpc(ctx context.Context, client *http.Client, endpoint string, arg, result any) error {
+	reqBody, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dp.apiURL+endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return dropboxError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// content calls one of the Dropbox content endpoints, where the argument is
+// passed in a header and the response body is the file itself
+func (dp *DropboxProvider) content(ctx context.Context, client *http.Client, endpoint string, arg any) (io.ReadCloser, error) {
+	apiArg, err := json.Marshal(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dp.contentURL+endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Dropbox-API-Arg", string(apiArg))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, dropboxError(resp)
+	}
+
+	return resp.Body, nil
+}
+
+func dropboxError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("invalid status code %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func valueOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
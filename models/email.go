@@ -1,13 +1,30 @@
 package models
 
 import (
+	"cmp"
+	"context"
+	"database/sql"
 	"fmt"
+	"io"
+	"log/slog"
+	netmail "net/mail"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-mail/mail/v2"
+	"github.com/rahulbalajee/lenslocked/metrics"
+	"github.com/rahulbalajee/lenslocked/rand"
 )
 
 const (
-	DefaultSender = "support@lenslocked.bc-noc-dev.com"
+	// Number of delivery attempts before an email is marked as failed
+	DefaultMaxEmailAttempts = 8
+	// Emails picked up from the outbox per iteration of the sender loop
+	outboxBatchSize = 20
+	// How long a claimed email is left alone before another sender may
+	// assume the one that claimed it died
+	outboxLease = 15 * time.Minute
 )
 
 type SMTPConfig struct {
@@ -19,39 +36,421 @@ type SMTPConfig struct {
 
 type Email struct {
 	From      string
+	ReplyTo   string
 	To        string
 	Subject   string
 	Plaintext string
 	HTML      string
+	// UnsubscribeURL must be set for anything that isn't transactional (eg.
+	// notifications), it is advertised in the List-Unsubscribe header
+	UnsubscribeURL string
+	// MessageID is generated when the email is queued so retries reuse it
+	MessageID string
+}
+
+// EmailTemplate renders the Subject, Plaintext and HTML of an Email from data.
+// Implemented by views.EmailTemplate, we only depend on the behaviour here.
+type EmailTemplate interface {
+	Render(data any) (Email, error)
+}
+
+// ForgotPasswordEmail is the data passed to the forgot password template
+type ForgotPasswordEmail struct {
+	ResetURL string
+}
+
+// GalleryInvitationEmail is the data passed to the gallery invitation template
+type GalleryInvitationEmail struct {
+	InvitedBy    string
+	GalleryTitle string
+	Role         string
+	AcceptURL    string
+	ExpiresAt    time.Time
+}
+
+// GalleryTransferEmail is the data passed to the gallery transfer template,
+// it asks the recipient to accept the gallery
+type GalleryTransferEmail struct {
+	From         string
+	GalleryTitle string
+	AcceptURL    string
+	ExpiresAt    time.Time
+}
+
+// GalleryTransferredEmail is the data passed to the gallery transferred
+// template, it is sent to both the previous and the new owner
+type GalleryTransferredEmail struct {
+	From         string
+	To           string
+	GalleryTitle string
+	GalleryURL   string
+	// Recipient is true in the email to the new owner
+	Recipient bool
+	// Forced is true when an admin made the transfer
+	Forced bool
+}
+
+// SelectionSubmittedEmail is the data passed to the selection submitted
+// template, it tells the owner of a gallery a client picked their images
+type SelectionSubmittedEmail struct {
+	ClientName   string
+	GalleryTitle string
+	// ShareLinkLabel is empty for selections made on a public gallery
+	ShareLinkLabel string
+	Count          int
+	SelectionsURL  string
+	// UnsubscribeURL stops these emails, it is linked in the footer and the
+	// List-Unsubscribe header
+	UnsubscribeURL string
+}
+
+// CommentPostedEmail is the data passed to the comment posted template, it
+// tells the owner of a gallery someone commented on it
+type CommentPostedEmail struct {
+	AuthorName   string
+	Body         string
+	GalleryTitle string
+	// Filename is the image the comment is on, empty for the gallery itself
+	Filename string
+	// Pending is true when the comment waits for approval
+	Pending     bool
+	CommentsURL string
+	// UnsubscribeURL stops these emails, it is linked in the footer and the
+	// List-Unsubscribe header
+	UnsubscribeURL string
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx so emails can be queued as
+// part of a larger transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
 type EmailService struct {
-	//  We can also add a DefaultSender field that can be set if needed, otherwise we will use a constant defined in our code
+	// DefaultSender is used when an Email doesn't set its own From, there is
+	// no built in sender since it must be an address on our own domain
 	DefaultSender string
+	// DefaultReplyTo is used when an Email doesn't set its own ReplyTo
+	DefaultReplyTo string
+	// ReturnPath is the envelope sender bounces are delivered to. Defaults
+	// to the From address.
+	ReturnPath string
+	// Domain is used to generate Message-IDs, eg. <random@Domain>. Defaults
+	// to the domain of the sender.
+	Domain string
+	// DKIM signs every outgoing message when set
+	DKIM *DKIMConfig
+
+	// One template per email we send, these must be set before calling the
+	// matching method
+	Templates struct {
+		ForgotPassword     EmailTemplate
+		GalleryInvitation  EmailTemplate
+		GalleryTransfer    EmailTemplate
+		GalleryTransferred EmailTemplate
+		SelectionSubmitted EmailTemplate
+		CommentPosted      EmailTemplate
+	}
+
+	// Emails are never sent inside a request, they are queued in the outbox
+	// table and delivered by RunOutbox
+	DB *sql.DB
+
+	// Attempts before giving up on an email, defaults to DefaultMaxEmailAttempts
+	MaxAttempts int
 
 	// unexported field because the caller doesn't need to know about our implementation
-	dialer *mail.Dialer
+	transport EmailTransport
 }
 
 // Factory to construct EmailService and the unexported field
-func NewEmailService(config SMTPConfig) *EmailService {
+func NewEmailService(db *sql.DB, transport EmailTransport) *EmailService {
 	return &EmailService{
-		dialer: mail.NewDialer(
-			config.Host,
-			config.Port,
-			config.Username,
-			config.Password),
+		DB:        db,
+		transport: transport,
 	}
 }
 
-func (es *EmailService) Send(email Email) error {
-	msg := mail.NewMessage()
+// Send queues the email in the outbox, it will be delivered by RunOutbox
+func (es *EmailService) Send(ctx context.Context, email Email) error {
+	return es.queue(ctx, es.DB, email)
+}
+
+// SendTx queues the email as part of tx, so it is only sent if tx commits
+func (es *EmailService) SendTx(ctx context.Context, tx *sql.Tx, email Email) error {
+	return es.queue(ctx, tx, email)
+}
+
+// Notify queues the emails about a change from inside the transaction making
+// it, with SendTx or the template methods taking a transaction, so they are
+// only sent if the change is saved
+type Notify[T any] func(tx *sql.Tx, change *T) error
+
+func (es *EmailService) ForgotPassword(ctx context.Context, to, resetURL string) error {
+	email, err := es.render(to, es.Templates.ForgotPassword, ForgotPasswordEmail{
+		ResetURL: resetURL,
+	})
+	if err != nil {
+		return fmt.Errorf("forgot password email: %w", err)
+	}
+
+	err = es.Send(ctx, email)
+	if err != nil {
+		return fmt.Errorf("forgot password email: %w", err)
+	}
+
+	return nil
+}
+
+func (es *EmailService) GalleryInvitation(ctx context.Context, tx *sql.Tx, to string, data GalleryInvitationEmail) error {
+	email, err := es.render(to, es.Templates.GalleryInvitation, data)
+	if err != nil {
+		return fmt.Errorf("gallery invitation email: %w", err)
+	}
+
+	err = es.SendTx(ctx, tx, email)
+	if err != nil {
+		return fmt.Errorf("gallery invitation email: %w", err)
+	}
+
+	return nil
+}
+
+func (es *EmailService) GalleryTransfer(ctx context.Context, tx *sql.Tx, to string, data GalleryTransferEmail) error {
+	email, err := es.render(to, es.Templates.GalleryTransfer, data)
+	if err != nil {
+		return fmt.Errorf("gallery transfer email: %w", err)
+	}
+
+	err = es.SendTx(ctx, tx, email)
+	if err != nil {
+		return fmt.Errorf("gallery transfer email: %w", err)
+	}
+
+	return nil
+}
+
+func (es *EmailService) GalleryTransferred(ctx context.Context, tx *sql.Tx, to string, data GalleryTransferredEmail) error {
+	email, err := es.render(to, es.Templates.GalleryTransferred, data)
+	if err != nil {
+		return fmt.Errorf("gallery transferred email: %w", err)
+	}
 
-	// setFrom() to a default value in case it's not set in Email
-	es.setFrom(msg, email)
+	err = es.SendTx(ctx, tx, email)
+	if err != nil {
+		return fmt.Errorf("gallery transferred email: %w", err)
+	}
+
+	return nil
+}
 
+func (es *EmailService) SelectionSubmitted(ctx context.Context, tx *sql.Tx, to string, data SelectionSubmittedEmail) error {
+	email, err := es.render(to, es.Templates.SelectionSubmitted, data)
+	if err != nil {
+		return fmt.Errorf("selection submitted email: %w", err)
+	}
+	email.UnsubscribeURL = data.UnsubscribeURL
+
+	err = es.SendTx(ctx, tx, email)
+	if err != nil {
+		return fmt.Errorf("selection submitted email: %w", err)
+	}
+
+	return nil
+}
+
+func (es *EmailService) CommentPosted(ctx context.Context, tx *sql.Tx, to string, data CommentPostedEmail) error {
+	email, err := es.render(to, es.Templates.CommentPosted, data)
+	if err != nil {
+		return fmt.Errorf("comment posted email: %w", err)
+	}
+	email.UnsubscribeURL = data.UnsubscribeURL
+
+	err = es.SendTx(ctx, tx, email)
+	if err != nil {
+		return fmt.Errorf("comment posted email: %w", err)
+	}
+
+	return nil
+}
+
+// RunOutbox delivers queued emails every interval until ctx is cancelled
+func (es *EmailService) RunOutbox(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while there are full batches waiting so a backlog drains quickly
+		for {
+			// A batch is never interrupted half way, otherwise emails that
+			// were sent wouldn't be marked as such and would go out twice
+			n, err := es.deliverBatch(context.WithoutCancel(ctx))
+			if err != nil {
+				slog.Error("deliver email batch", "err", err)
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverBatch sends the emails that are due and records the outcome of each
+// attempt. The emails are claimed first, SKIP LOCKED lets several servers
+// share the outbox, and sent outside of any transaction so a failed update
+// can't roll back the record of an email that already went out. A claim is
+// a lease: emails of a server that died while sending are picked up again
+// once it runs out.
+func (es *EmailService) deliverBatch(ctx context.Context) (int, error) {
+	rows, err := es.DB.QueryContext(ctx, `
+		UPDATE email_outbox
+		SET status = 'sending', attempts = attempts + 1, next_attempt_at = $2
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status IN ('pending', 'sending') AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, from_address, reply_to, to_address, subject, plaintext, html, unsubscribe_url, message_id, attempts;`,
+		outboxBatchSize, time.Now().Add(outboxLease))
+	if err != nil {
+		return 0, fmt.Errorf("deliver batch: %w", err)
+	}
+
+	type queuedEmail struct {
+		ID       int
+		Attempts int
+		Email    Email
+	}
+	var queued []queuedEmail
+	for rows.Next() {
+		var q queuedEmail
+		err = rows.Scan(
+			&q.ID,
+			&q.Email.From,
+			&q.Email.ReplyTo,
+			&q.Email.To,
+			&q.Email.Subject,
+			&q.Email.Plaintext,
+			&q.Email.HTML,
+			&q.Email.UnsubscribeURL,
+			&q.Email.MessageID,
+			&q.Attempts,
+		)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("deliver batch: %w", err)
+		}
+		queued = append(queued, q)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("deliver batch: %w", err)
+	}
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(queued, func(a, b queuedEmail) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	maxAttempts := es.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxEmailAttempts
+	}
+
+	for _, q := range queued {
+		sendErr := es.deliver(q.Email)
+		if sendErr != nil {
+			metrics.EmailsSent.WithLabelValues("failure").Inc()
+		} else {
+			metrics.EmailsSent.WithLabelValues("success").Inc()
+		}
+
+		// Each outcome is recorded on its own so a failure only affects
+		// the email at hand
+		switch {
+		case sendErr == nil:
+			_, err = es.DB.ExecContext(ctx, `
+				UPDATE email_outbox
+				SET status = 'sent', sent_at = NOW(), last_error = NULL
+				WHERE id = $1 AND status = 'sending';`, q.ID)
+		case q.Attempts >= maxAttempts:
+			slog.Error("giving up on email", "email_id", q.ID, "attempts", q.Attempts, "err", sendErr)
+			_, err = es.DB.ExecContext(ctx, `
+				UPDATE email_outbox
+				SET status = 'failed', last_error = $2
+				WHERE id = $1 AND status = 'sending';`, q.ID, sendErr.Error())
+		default:
+			slog.Warn("deliver email", "email_id", q.ID, "attempts", q.Attempts, "err", sendErr)
+			_, err = es.DB.ExecContext(ctx, `
+				UPDATE email_outbox
+				SET status = 'pending', last_error = $2, next_attempt_at = $3
+				WHERE id = $1 AND status = 'sending';`, q.ID, sendErr.Error(), time.Now().Add(outboxBackoff(q.Attempts)))
+		}
+		if err != nil {
+			return 0, fmt.Errorf("deliver batch: %w", err)
+		}
+	}
+
+	return len(queued), nil
+}
+
+// deliver builds the MIME message, signs it and hands it to the transport
+func (es *EmailService) deliver(email Email) error {
+	from, err := netmail.ParseAddress(email.From)
+	if err != nil {
+		return fmt.Errorf("parse from address: %w", err)
+	}
+	to, err := netmail.ParseAddress(email.To)
+	if err != nil {
+		return fmt.Errorf("parse to address: %w", err)
+	}
+
+	var msg io.WriterTo = es.message(email)
+	if es.DKIM != nil {
+		msg, err = signDKIM(*es.DKIM, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	envelopeFrom := from.Address
+	if es.ReturnPath != "" {
+		envelopeFrom = es.ReturnPath
+	}
+
+	err = es.transport.Send(envelopeFrom, []string{to.Address}, msg)
+	if err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+
+	return nil
+}
+
+// message builds the MIME message for email
+func (es *EmailService) message(email Email) *mail.Message {
+	msg := mail.NewMessage()
+	msg.SetHeader("From", email.From)
 	msg.SetHeader("To", email.To)
 	msg.SetHeader("Subject", email.Subject)
+	if email.ReplyTo != "" {
+		msg.SetHeader("Reply-To", email.ReplyTo)
+	}
+	if email.MessageID != "" {
+		msg.SetHeader("Message-ID", email.MessageID)
+	}
+	if email.UnsubscribeURL != "" {
+		// RFC 8058 one-click unsubscribe
+		msg.SetHeader("List-Unsubscribe", "<"+email.UnsubscribeURL+">")
+		msg.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
 
 	switch {
 	case email.Plaintext != "" && email.HTML != "":
@@ -63,41 +462,121 @@ func (es *EmailService) Send(email Email) error {
 		msg.SetBody("text/html", email.HTML)
 	}
 
-	err := es.dialer.DialAndSend(msg)
+	return msg
+}
+
+// messageID generates a unique Message-ID on our own domain
+func (es *EmailService) messageID(from string) (string, error) {
+	domain := es.Domain
+	if domain == "" {
+		address, err := netmail.ParseAddress(from)
+		if err != nil {
+			return "", fmt.Errorf("message id: %w", err)
+		}
+		domain = address.Address[strings.LastIndex(address.Address, "@")+1:]
+	}
+
+	id, err := rand.Bytes(16)
 	if err != nil {
-		return fmt.Errorf("send email: %w", err)
+		return "", fmt.Errorf("message id: %w", err)
 	}
 
-	return nil
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().Unix(), id, domain), nil
 }
 
-func (es *EmailService) ForgotPassword(to, resetURL string) error {
-	email := Email{
-		Subject:   "Reset your password",
-		To:        to,
-		Plaintext: "To reset your password, please visit the following link: " + resetURL,
-		HTML:      `<p>To reset your password, please visit the following link: <a href="` + resetURL + `">` + resetURL + `</a></p>`,
+func (es *EmailService) queue(ctx context.Context, db execer, email Email) error {
+	from, err := es.from(email)
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
+	}
+	email.From = from
+	if email.ReplyTo == "" {
+		email.ReplyTo = es.DefaultReplyTo
 	}
 
-	err := es.Send(email)
+	messageID, err := es.messageID(email.From)
 	if err != nil {
-		return fmt.Errorf("forgot password email: %w", err)
+		return fmt.Errorf("queue email: %w", err)
+	}
+	email.MessageID = messageID
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// Emails that can be unsubscribed from are dropped for addresses that did
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO email_outbox (from_address, reply_to, to_address, subject, plaintext, html, unsubscribe_url, message_id)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8
+		WHERE $7 = '' OR NOT EXISTS (
+			SELECT 1 FROM email_unsubscribes
+			WHERE email = $9);`,
+		email.From, email.ReplyTo, email.To, email.Subject, email.Plaintext, email.HTML, email.UnsubscribeURL, email.MessageID,
+		unsubscribeAddress(email.To))
+	if err != nil {
+		return fmt.Errorf("queue email: %w", err)
 	}
 
 	return nil
 }
 
-func (es *EmailService) setFrom(msg *mail.Message, email Email) {
-	var from string
+// Unsubscribe stops the emails that have an UnsubscribeURL, eg.
+// notifications, from being sent to address. Transactional emails like
+// password resets are still sent.
+func (es *EmailService) Unsubscribe(ctx context.Context, address string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
+	_, err := es.DB.ExecContext(ctx, `
+		INSERT INTO email_unsubscribes (email)
+		VALUES ($1) ON CONFLICT (email) DO NOTHING;`, unsubscribeAddress(address))
+	if err != nil {
+		return fmt.Errorf("unsubscribe: %w", err)
+	}
+
+	return nil
+}
+
+// unsubscribeAddress is the bare, lowercase address of to, the form
+// unsubscribed addresses are stored in
+func unsubscribeAddress(to string) string {
+	address, err := netmail.ParseAddress(to)
+	if err != nil {
+		return strings.ToLower(to)
+	}
+	return strings.ToLower(address.Address)
+}
+
+// render renders tmpl with data into an email to the recipient
+func (es *EmailService) render(to string, tmpl EmailTemplate, data any) (Email, error) {
+	if tmpl == nil {
+		return Email{}, fmt.Errorf("render email: template not configured")
+	}
+
+	email, err := tmpl.Render(data)
+	if err != nil {
+		return Email{}, fmt.Errorf("render email: %w", err)
+	}
+	email.To = to
+
+	return email, nil
+}
+
+// from falls back to DefaultSender in case it's not set in Email
+func (es *EmailService) from(email Email) (string, error) {
 	switch {
 	case email.From != "":
-		from = email.From
+		return email.From, nil
 	case es.DefaultSender != "":
-		from = es.DefaultSender
+		return es.DefaultSender, nil
 	default:
-		from = DefaultSender
+		return "", fmt.Errorf("no sender configured")
 	}
+}
 
-	msg.SetHeader("From", from)
+// outboxBackoff doubles the wait after every failed attempt, capped at an hour
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 8 {
+		return time.Hour
+	}
+	return min(30*time.Second<<(attempts-1), time.Hour)
 }
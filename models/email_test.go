@@ -0,0 +1,202 @@
+package models
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	netmail "net/mail"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+func TestEmailServiceDeliver(t *testing.T) {
+	transport := &MemoryTransport{}
+	es := NewEmailService(nil, transport)
+	es.ReturnPath = "bounces@lenslocked.test"
+
+	err := es.deliver(Email{
+		From:      "Lenslocked <support@lenslocked.test>",
+		ReplyTo:   "help@lenslocked.test",
+		To:        "Jon <jon@example.com>",
+		Subject:   "Your gallery was shared",
+		Plaintext: "Hello in plain text",
+		HTML:      "<p>Hello in HTML</p>",
+		MessageID: "<abc@lenslocked.test>",
+	})
+	if err != nil {
+		t.Fatalf("deliver() err = %v", err)
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	sent := messages[0]
+	if sent.From != "bounces@lenslocked.test" {
+		t.Errorf("envelope from = %q, want the return path", sent.From)
+	}
+	if len(sent.To) != 1 || sent.To[0] != "jon@example.com" {
+		t.Errorf("envelope to = %v, want [jon@example.com]", sent.To)
+	}
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(sent.Data))
+	if err != nil {
+		t.Fatalf("ReadMessage() err = %v", err)
+	}
+	headers := map[string]string{
+		"Return-Path": "<bounces@lenslocked.test>",
+		"Subject":     "Your gallery was shared",
+		"Reply-To":    "help@lenslocked.test",
+		"Message-Id":  "<abc@lenslocked.test>",
+	}
+	for name, want := range headers {
+		if got := msg.Header.Get(name); got != want {
+			t.Errorf("%v = %q, want %q", name, got, want)
+		}
+	}
+	if got := msg.Header.Get("List-Unsubscribe"); got != "" {
+		t.Errorf("List-Unsubscribe = %q, want none without an UnsubscribeURL", got)
+	}
+	if !strings.HasPrefix(msg.Header.Get("Content-Type"), "multipart/alternative") {
+		t.Errorf("Content-Type = %q, want multipart/alternative", msg.Header.Get("Content-Type"))
+	}
+	for _, part := range []string{"Hello in plain text", "<p>Hello in HTML</p>"} {
+		if !bytes.Contains(sent.Data, []byte(part)) {
+			t.Errorf("message doesn't contain %q", part)
+		}
+	}
+}
+
+func TestEmailServiceDeliverInvalidRecipient(t *testing.T) {
+	transport := &MemoryTransport{}
+	es := NewEmailService(nil, transport)
+
+	err := es.deliver(Email{
+		From:    "support@lenslocked.test",
+		To:      "not an address",
+		Subject: "Hello",
+	})
+	if err == nil {
+		t.Fatal("deliver() err = nil, want an error")
+	}
+	if n := len(transport.Messages()); n != 0 {
+		t.Errorf("sent %d messages, want none", n)
+	}
+}
+
+func TestEmailServiceDeliverSigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport := &MemoryTransport{}
+	es := NewEmailService(nil, transport)
+	es.ReturnPath = "bounces@lenslocked.test"
+	es.DKIM = &DKIMConfig{
+		Domain:     "lenslocked.test",
+		Selector:   "mail",
+		PrivateKey: privateKey,
+	}
+
+	err = es.deliver(Email{
+		From:           "support@lenslocked.test",
+		To:             "jon@example.com",
+		Subject:        "Jane commented on Summer Wedding",
+		Plaintext:      "These came out beautifully!",
+		MessageID:      "<abc@lenslocked.test>",
+		UnsubscribeURL: "https://lenslocked.test/unsubscribe/token",
+	})
+	if err != nil {
+		t.Fatalf("deliver() err = %v", err)
+	}
+
+	messages := transport.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	data := messages[0].Data
+
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadMessage() err = %v", err)
+	}
+	headers := map[string]string{
+		"Return-Path":           "<bounces@lenslocked.test>",
+		"List-Unsubscribe":      "<https://lenslocked.test/unsubscribe/token>",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+	for name, want := range headers {
+		if got := msg.Header.Get(name); got != want {
+			t.Errorf("%v = %q, want %q", name, got, want)
+		}
+	}
+
+	signature := msg.Header.Get("DKIM-Signature")
+	for _, tag := range []string{"d=lenslocked.test", "s=mail", "list-unsubscribe"} {
+		if !strings.Contains(strings.ToLower(signature), strings.ToLower(tag)) {
+			t.Errorf("DKIM-Signature = %q, want it to contain %q", signature, tag)
+		}
+	}
+
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(data), &dkim.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if domain != "mail._domainkey.lenslocked.test" {
+				return nil, fmt.Errorf("unexpected lookup of %v", domain)
+			}
+			return []string{"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(publicKey)}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("dkim.Verify() err = %v", err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("found %d signatures, want 1", len(verifications))
+	}
+	if verifications[0].Err != nil {
+		t.Errorf("signature doesn't verify: %v", verifications[0].Err)
+	}
+}
+
+func TestEmailServiceSender(t *testing.T) {
+	es := NewEmailService(nil, &MemoryTransport{})
+
+	// There is no built in sender to fall back to
+	_, err := es.from(Email{})
+	if err == nil {
+		t.Fatal("from() err = nil, want an error without a DefaultSender")
+	}
+
+	es.DefaultSender = "Studio <hello@photos.example.com>"
+	from, err := es.from(Email{})
+	if err != nil {
+		t.Fatalf("from() err = %v", err)
+	}
+	if from != es.DefaultSender {
+		t.Errorf("from() = %q, want %q", from, es.DefaultSender)
+	}
+
+	tests := map[string]struct {
+		domain string
+		want   string
+	}{
+		"sender domain": {"", "@photos.example.com>"},
+		"own domain":    {"mail.example.com", "@mail.example.com>"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			es.Domain = tc.domain
+			id, err := es.messageID(from)
+			if err != nil {
+				t.Fatalf("messageID() err = %v", err)
+			}
+			if !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, tc.want) {
+				t.Errorf("messageID() = %q, want it to end with %q", id, tc.want)
+			}
+		})
+	}
+}
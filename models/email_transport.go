@@ -0,0 +1,146 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+	"github.com/rahulbalajee/lenslocked/rand"
+)
+
+// EmailTransport delivers an already built message. The signature matches
+// mail.Sender so any go-mail sender can be used as a transport.
+type EmailTransport interface {
+	Send(from string, to []string, msg io.WriterTo) error
+}
+
+// SMTPTransport sends emails through an SMTP server, dialing a new
+// connection for every message
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTPTransport(config SMTPConfig) *SMTPTransport {
+	return &SMTPTransport{
+		dialer: mail.NewDialer(
+			config.Host,
+			config.Port,
+			config.Username,
+			config.Password),
+	}
+}
+
+func (st *SMTPTransport) Send(from string, to []string, msg io.WriterTo) error {
+	sender, err := st.dialer.Dial()
+	if err != nil {
+		return fmt.Errorf("smtp dial: %w", err)
+	}
+	defer sender.Close()
+
+	err = sender.Send(from, to, msg)
+	if err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+
+	return nil
+}
+
+// writeDelivered writes msg the way it is stored once delivered: the
+// receiving server records the envelope sender in a Return-Path header (RFC
+// 5321 section 4.4), transports that are the final stop do it themselves
+func writeDelivered(w io.Writer, from string, msg io.WriterTo) error {
+	_, err := fmt.Fprintf(w, "Return-Path: <%s>\r\n", from)
+	if err != nil {
+		return err
+	}
+	_, err = msg.WriteTo(w)
+	return err
+}
+
+// FileTransport writes every message into a Maildir, handy in development to
+// read emails with any mail client instead of sending them
+type FileTransport struct {
+	Dir string
+}
+
+func (ft *FileTransport) Send(from string, to []string, msg io.WriterTo) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(ft.Dir, sub), 0755)
+		if err != nil {
+			return fmt.Errorf("file transport: %w", err)
+		}
+	}
+
+	// Maildir delivery: write to tmp/ first and move to new/ once complete so
+	// readers never see a partial message
+	unique, err := rand.String(12)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+	filename := fmt.Sprintf("%d.%s.lenslocked", time.Now().UnixNano(), unique)
+	tmpPath := filepath.Join(ft.Dir, "tmp", filename)
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+	err = writeDelivered(f, from, msg)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("file transport: %w", err)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(ft.Dir, "new", filename))
+	if err != nil {
+		return fmt.Errorf("file transport: %w", err)
+	}
+
+	return nil
+}
+
+// SentMessage is a message captured by MemoryTransport
+type SentMessage struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// MemoryTransport keeps every message in memory, meant for tests
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []SentMessage
+}
+
+func (mt *MemoryTransport) Send(from string, to []string, msg io.WriterTo) error {
+	var buf bytes.Buffer
+	err := writeDelivered(&buf, from, msg)
+	if err != nil {
+		return fmt.Errorf("memory transport: %w", err)
+	}
+
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.messages = append(mt.messages, SentMessage{
+		From: from,
+		To:   to,
+		Data: buf.Bytes(),
+	})
+
+	return nil
+}
+
+// Messages returns a copy of every message sent so far
+func (mt *MemoryTransport) Messages() []SentMessage {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return append([]SentMessage(nil), mt.messages...)
+}
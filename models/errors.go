@@ -12,6 +12,18 @@ import (
 var (
 	ErrEmailTaken = errors.New("models: email address is already in use")
 	ErrNotFound   = errors.New("models: resource could not be found")
+	// ErrInvalidOrder is returned when a new image order doesn't list every
+	// image of the gallery exactly once
+	ErrInvalidOrder = errors.New("models: image order does not match the gallery images")
+	// ErrLinkExpired is returned when a share link or an invitation is past
+	// its expiry date, or a share link was opened as many times as it allows
+	ErrLinkExpired = errors.New("models: link has expired")
+	// ErrLastOwner is returned when removing or demoting the only owner of a
+	// workspace, which would leave nobody able to manage it
+	ErrLastOwner = errors.New("models: workspace must keep an owner")
+	// ErrSelectionSubmitted is returned when changing a selection the client
+	// already submitted
+	ErrSelectionSubmitted = errors.New("models: selection was already submitted")
 )
 
 type FileError struct {
@@ -24,8 +36,10 @@ func (fe FileError) Error() string {
 
 func checkContentType(r io.Reader, allowedTypes []string) ([]byte, error) {
 	testBytes := make([]byte, 512)
-	n, err := r.Read(testBytes)
-	if err != nil {
+	// Readers like a decompressing one may return less than asked for, keep
+	// reading until the buffer is full or the file ends
+	n, err := io.ReadFull(r, testBytes)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return nil, fmt.Errorf("checking content type: %w", err)
 	}
 
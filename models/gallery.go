@@ -1,16 +1,87 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 )
 
 type Gallery struct {
-	ID        int
-	UserID    int
-	Title     string
-	Published bool
+	ID int
+	// UserID is the account owning the gallery, it is always an owner
+	UserID int
+	// WorkspaceID is the workspace the gallery belongs to, its members get
+	// their workspace role on the gallery
+	WorkspaceID int
+	Title       string
+	// Visibility decides who can see the gallery, see the Visibility constants
+	Visibility Visibility
+	// Slug is the random part of the link unlisted and public galleries are
+	// shared with
+	Slug string
+	// PasswordHash is empty unless visitors need a password, see SetPassword
+	PasswordHash string
+	// Description is Markdown, it is rendered when the gallery is shown
+	Description string
+	// CoverImage is the filename of the image shown on gallery cards, the
+	// first image is used when it is empty
+	CoverImage string
+	// SortMode decides the order images are shown in, one of the ImageSort constants
+	SortMode  ImageSort
+	EventDate *time.Time
+	Location  string
+	// CommentsEnabled lets visitors comment on the gallery and its images
+	CommentsEnabled bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	// DeletedAt is set when the gallery is in the trash
+	DeletedAt *time.Time
+}
+
+// galleryColumns are selected by every query returning galleries, in the
+// order scanGallery expects them
+const galleryColumns = `id, user_id, workspace_id, title, visibility, slug, password_hash, description,
+	cover_image, sort_mode, event_date, location, comments_enabled, created_at, updated_at, deleted_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// withColumns lets scanGallery read rows selecting more columns after
+// galleryColumns, they are scanned into extra
+type withColumns struct {
+	scanner
+	extra []any
+}
+
+func (w withColumns) Scan(dest ...any) error {
+	return w.scanner.Scan(append(dest, w.extra...)...)
+}
+
+func scanGallery(row scanner) (Gallery, error) {
+	var gallery Gallery
+	err := row.Scan(
+		&gallery.ID,
+		&gallery.UserID,
+		&gallery.WorkspaceID,
+		&gallery.Title,
+		&gallery.Visibility,
+		&gallery.Slug,
+		&gallery.PasswordHash,
+		&gallery.Description,
+		&gallery.CoverImage,
+		&gallery.SortMode,
+		&gallery.EventDate,
+		&gallery.Location,
+		&gallery.CommentsEnabled,
+		&gallery.CreatedAt,
+		&gallery.UpdatedAt,
+		&gallery.DeletedAt,
+	)
+	return gallery, err
 }
 
 type GalleryService struct {
@@ -18,42 +89,48 @@ type GalleryService struct {
 	ImageService *ImageService
 }
 
-func (gs *GalleryService) Create(title string, userID int) (*Gallery, error) {
-	gallery := Gallery{
-		Title:  title,
-		UserID: userID,
+// Create inserts a new gallery with a random slug, the ID, Visibility and
+// timestamps are set from the database
+func (gs *GalleryService) Create(ctx context.Context, gallery *Gallery) error {
+	slug, err := newSlug()
+	if err != nil {
+		return fmt.Errorf("create gallery: %w", err)
 	}
+	gallery.Slug = slug
 
-	row := gs.DB.QueryRow(`
-		INSERT INTO galleries (title, user_id)
-		VALUES ($1, $2) RETURNING id, published;`, gallery.Title, gallery.UserID)
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
-	err := row.Scan(
+	row := gs.DB.QueryRowContext(ctx, `
+		INSERT INTO galleries (title, user_id, workspace_id, slug, description, event_date, location)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, visibility, sort_mode, created_at, updated_at;`,
+		gallery.Title, gallery.UserID, gallery.WorkspaceID, gallery.Slug, gallery.Description,
+		gallery.EventDate, gallery.Location)
+
+	err = row.Scan(
 		&gallery.ID,
-		&gallery.Published,
+		&gallery.Visibility,
+		&gallery.SortMode,
+		&gallery.CreatedAt,
+		&gallery.UpdatedAt,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("create gallery: %w", err)
+		return fmt.Errorf("create gallery: %w", err)
 	}
 
-	return &gallery, nil
+	return nil
 }
 
-func (gs *GalleryService) ByID(id int) (*Gallery, error) {
-	gallery := Gallery{
-		ID: id,
-	}
+func (gs *GalleryService) ByID(ctx context.Context, id int) (*Gallery, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
-	row := gs.DB.QueryRow(`
-		SELECT title, user_id, published
+	row := gs.DB.QueryRowContext(ctx, `
+		SELECT `+galleryColumns+`
 		FROM galleries 
-		WHERE id = $1;`, gallery.ID)
+		WHERE id = $1 AND deleted_at IS NULL;`, id)
 
-	err := row.Scan(
-		&gallery.Title,
-		&gallery.UserID,
-		&gallery.Published,
-	)
+	gallery, err := scanGallery(row)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
@@ -64,63 +141,356 @@ func (gs *GalleryService) ByID(id int) (*Gallery, error) {
 	return &gallery, nil
 }
 
-func (gs *GalleryService) ByUserID(userID int) ([]Gallery, error) {
-	rows, err := gs.DB.Query(`
-		SELECT id, title, published
+// GalleryPage is one page of a user's galleries
+type GalleryPage struct {
+	Galleries []Gallery
+	// Roles maps the ID of every gallery on the page to the user's role on
+	// it, see CollaboratorService.Role
+	Roles map[int]Role
+	Cursors
+}
+
+// galleryCursor is the sort key of the gallery list
+type galleryCursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        int       `json:"id"`
+}
+
+// ByMember lists the galleries a user can see across their workspaces, most
+// recently created first. With workspaceID 0 galleries of every workspace are
+// listed along with galleries shared with the user directly, otherwise only
+// those of that workspace. It uses keyset pagination so pages stay fast and
+// stable while galleries are added.
+func (gs *GalleryService) ByMember(ctx context.Context, userID, workspaceID int, page Page) (*GalleryPage, error) {
+	limit := page.limit()
+
+	// The best of the user's roles is worked out the same way as
+	// CollaboratorService.Role does, $2 lists the roles by privilege
+	query := `
+		SELECT ` + galleryColumns + `,
+			COALESCE((
+				SELECT role FROM (
+					SELECT role FROM gallery_collaborators
+					WHERE gallery_id = galleries.id AND user_id = $1
+					UNION ALL
+					SELECT CASE WHEN galleries.user_id = $1 THEN 'owner' ELSE role END
+					FROM workspace_members
+					WHERE workspace_id = galleries.workspace_id AND user_id = $1
+				) roles
+				ORDER BY array_position($2::text[], role) DESC
+				LIMIT 1
+			), '')
 		FROM galleries 
-		WHERE user_id = $1;`, userID)
+		WHERE deleted_at IS NULL`
+	roles := make([]string, len(Roles))
+	for i, role := range Roles {
+		roles[i] = string(role)
+	}
+	args := []any{userID, roles}
+	if workspaceID == 0 {
+		query += `
+		AND (workspace_id IN (
+				SELECT workspace_id FROM workspace_members WHERE user_id = $1
+			) OR id IN (
+				SELECT gallery_id FROM gallery_collaborators WHERE user_id = $1
+			))`
+	} else {
+		query += `
+		AND workspace_id IN (
+			SELECT workspace_id FROM workspace_members WHERE user_id = $1 AND workspace_id = $3
+		)`
+		args = append(args, workspaceID)
+	}
+	// The cursor parameters follow the ones above
+	next := len(args) + 1
+	var cursor galleryCursor
+	switch {
+	case page.Before != "":
+		err := decodeCursor(page.Before, &cursor)
+		if err != nil {
+			return nil, fmt.Errorf("query galleries by member: %w", err)
+		}
+		// Walk backwards from the cursor, the rows are flipped back below
+		query += fmt.Sprintf(`
+		AND (created_at, id) > ($%d, $%d)
+		ORDER BY created_at ASC, id ASC`, next, next+1)
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	case page.After != "":
+		err := decodeCursor(page.After, &cursor)
+		if err != nil {
+			return nil, fmt.Errorf("query galleries by member: %w", err)
+		}
+		query += fmt.Sprintf(`
+		AND (created_at, id) < ($%d, $%d)
+		ORDER BY created_at DESC, id DESC`, next, next+1)
+		args = append(args, cursor.CreatedAt, cursor.ID)
+	default:
+		query += `
+		ORDER BY created_at DESC, id DESC`
+	}
+	// One extra row tells us if there is another page
+	query += fmt.Sprintf(`
+		LIMIT %d;`, limit+1)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := gs.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query galleries by user id: %w", err)
+		return nil, fmt.Errorf("query galleries by member: %w", err)
 	}
+	defer rows.Close()
 
 	var galleries []Gallery
+	memberRoles := make(map[int]Role)
 
 	for rows.Next() {
-		gallery := Gallery{
-			UserID: userID,
-		}
-		err = rows.Scan(
-			&gallery.ID,
-			&gallery.Title,
-			&gallery.Published,
-		)
+		var role Role
+		gallery, err := scanGallery(withColumns{rows, []any{&role}})
 		if err != nil {
-			return nil, fmt.Errorf("query galleries by user id: %w", err)
+			return nil, fmt.Errorf("query galleries by member: %w", err)
 		}
 
 		galleries = append(galleries, gallery)
+		memberRoles[gallery.ID] = role
 	}
 
-	if rows.Err() != nil {
-		return nil, fmt.Errorf("query galleries by user id: %w", err)
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query galleries by member: %w", err)
 	}
 
-	return galleries, nil
+	hasMore := len(galleries) > limit
+	if hasMore {
+		galleries = galleries[:limit]
+	}
+	if page.Before != "" {
+		slices.Reverse(galleries)
+	}
+
+	result := GalleryPage{
+		Galleries: galleries,
+		Roles:     memberRoles,
+	}
+	result.Cursors = pageCursors(page, len(galleries), hasMore, func(i int) string {
+		return encodeCursor(galleryCursor{
+			CreatedAt: galleries[i].CreatedAt,
+			ID:        galleries[i].ID,
+		})
+	})
+
+	return &result, nil
 }
 
-func (gs *GalleryService) Update(gallery *Gallery) error {
-	_, err := gs.DB.Exec(`
+// Update saves every editable field and refreshes UpdatedAt
+func (gs *GalleryService) Update(ctx context.Context, gallery *Gallery) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := gs.DB.QueryRowContext(ctx, `
 		UPDATE galleries 
-		SET title = $2, published = $3
-		WHERE id = $1`, gallery.ID, gallery.Title, gallery.Published)
+		SET title = $2, visibility = $3, description = $4, cover_image = $5,
+			sort_mode = $6, event_date = $7, location = $8, comments_enabled = $9, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at;`,
+		gallery.ID, gallery.Title, gallery.Visibility, gallery.Description, gallery.CoverImage,
+		gallery.SortMode, gallery.EventDate, gallery.Location, gallery.CommentsEnabled)
+
+	err := row.Scan(&gallery.UpdatedAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
 		return fmt.Errorf("update gallery: %w", err)
 	}
 
 	return nil
 }
 
-func (gs *GalleryService) Delete(id int) error {
-	_, err := gs.DB.Exec(`
-		DELETE FROM galleries 
+// Delete moves the gallery to the trash, it can be restored until it is
+// purged or deleted forever
+func (gs *GalleryService) Delete(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := gs.DB.ExecContext(ctx, `
+		UPDATE galleries
+		SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("trash gallery: %w", err)
+	}
+
+	return nil
+}
+
+// TrashedByID returns a gallery only if it is in the trash
+func (gs *GalleryService) TrashedByID(ctx context.Context, id int) (*Gallery, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := gs.DB.QueryRowContext(ctx, `
+		SELECT `+galleryColumns+`
+		FROM galleries
+		WHERE id = $1 AND deleted_at IS NOT NULL;`, id)
+
+	gallery, err := scanGallery(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query trashed gallery by id: %w", err)
+	}
+
+	return &gallery, nil
+}
+
+// Trashed lists the galleries in the trash a user can restore, most recently
+// deleted first. Those are the galleries they own while still a member of
+// their workspace and those they are an editor or owner of through a
+// workspace or as a collaborator.
+func (gs *GalleryService) Trashed(ctx context.Context, userID int) ([]Gallery, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := gs.DB.QueryContext(ctx, `
+		SELECT `+galleryColumns+`
+		FROM galleries
+		WHERE (EXISTS (
+				SELECT 1 FROM workspace_members
+				WHERE workspace_id = galleries.workspace_id AND user_id = $1
+					AND (role IN ($2, $3) OR galleries.user_id = $1)
+			) OR id IN (
+				SELECT gallery_id FROM gallery_collaborators
+				WHERE user_id = $1 AND role IN ($2, $3)
+			))
+			AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC;`, userID, RoleEditor, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("query trashed galleries: %w", err)
+	}
+	defer rows.Close()
+
+	var galleries []Gallery
+	for rows.Next() {
+		gallery, err := scanGallery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("query trashed galleries: %w", err)
+		}
+		galleries = append(galleries, gallery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query trashed galleries: %w", err)
+	}
+
+	return galleries, nil
+}
+
+// Restore takes a gallery out of the trash
+func (gs *GalleryService) Restore(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := gs.DB.ExecContext(ctx, `
+		UPDATE galleries
+		SET deleted_at = NULL
+		WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("restore gallery: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteForever removes the gallery and every row that depends on it in a
+// single transaction. Child tables reference galleries with ON DELETE
+// CASCADE. The images on disk are removed after commit by CleanupService.
+func (gs *GalleryService) DeleteForever(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := gs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("delete gallery forever: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = deleteGalleryTx(ctx, tx, id)
+	if err != nil {
+		return fmt.Errorf("delete gallery forever: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("delete gallery forever: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeTrash deletes forever every gallery that has been in the trash for
+// longer than retention and returns how many were purged
+func (gs *GalleryService) PurgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := gs.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("purge trash: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id FROM galleries
+		WHERE deleted_at < $1
+		FOR UPDATE;`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("purge trash: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("purge trash: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("purge trash: %w", err)
+	}
+
+	for _, id := range ids {
+		err = deleteGalleryTx(ctx, tx, id)
+		if err != nil {
+			return 0, fmt.Errorf("purge trash: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("purge trash: %w", err)
+	}
+
+	return len(ids), nil
+}
+
+// deleteGalleryTx deletes the gallery row and queues the removal of its images
+func deleteGalleryTx(ctx context.Context, tx *sql.Tx, id int) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM galleries
 		WHERE id = $1`, id)
 	if err != nil {
-		return fmt.Errorf("delete gallery by id: %w", err)
+		return fmt.Errorf("delete gallery: %w", err)
 	}
 
-	err = gs.ImageService.DeleteAllGalleryImages(id)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO image_cleanups (gallery_id)
+		VALUES ($1)`, id)
 	if err != nil {
-		return fmt.Errorf("delete gallery images: %w", err)
+		return fmt.Errorf("queue gallery images cleanup: %w", err)
 	}
 
 	return nil
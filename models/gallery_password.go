@@ -0,0 +1,57 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Protected tells if visitors need a password to see the gallery
+func (g Gallery) Protected() bool {
+	return g.PasswordHash != ""
+}
+
+// CheckPassword tells if password unlocks the gallery
+func (g Gallery) CheckPassword(password string) bool {
+	if !g.Protected() {
+		return false
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(g.PasswordHash), []byte(password))
+	return err == nil
+}
+
+// SetPassword hashes and saves the password of a gallery, hashing works the
+// same as for user passwords. An empty password removes the protection.
+func (gs *GalleryService) SetPassword(ctx context.Context, gallery *Gallery, password string) error {
+	var passwordHash string
+	if password != "" {
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("set gallery password: %w", err)
+		}
+		passwordHash = string(hashedBytes)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := gs.DB.QueryRowContext(ctx, `
+		UPDATE galleries
+		SET password_hash = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at;`, gallery.ID, passwordHash)
+
+	err := row.Scan(&gallery.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("set gallery password: %w", err)
+	}
+	gallery.PasswordHash = passwordHash
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTransferDuration is how long a transfer can be accepted for
+	DefaultTransferDuration = 7 * 24 * time.Hour
+)
+
+// GalleryTransfer hands a gallery over to another account, it takes effect
+// once the recipient accepts it
+type GalleryTransfer struct {
+	ID         int
+	GalleryID  int
+	FromUserID int
+	FromEmail  string
+	// Email is the address of the recipient, only their account can accept
+	Email string
+	// Token is only set when the transfer is created
+	Token     string
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (t GalleryTransfer) Expired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+type TransferService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+	// How long transfers are valid for, defaults to DefaultTransferDuration
+	Duration time.Duration
+}
+
+// Create starts the transfer of a gallery to the account with email. A
+// gallery has at most one pending transfer, starting another replaces it.
+// notify queues the email with the token along with it.
+func (ts *TransferService) Create(ctx context.Context, galleryID, fromUserID int, email string, notify Notify[GalleryTransfer]) (*GalleryTransfer, error) {
+	token, tokenHash, err := ts.TokenManager.New()
+	if err != nil {
+		return nil, fmt.Errorf("create transfer: %w", err)
+	}
+
+	duration := ts.Duration
+	if duration == 0 {
+		duration = DefaultTransferDuration
+	}
+
+	transfer := GalleryTransfer{
+		GalleryID:  galleryID,
+		FromUserID: fromUserID,
+		Email:      strings.ToLower(email),
+		Token:      token,
+		TokenHash:  tokenHash,
+		ExpiresAt:  time.Now().Add(duration),
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := ts.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create transfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO gallery_transfers (gallery_id, from_user_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5) ON CONFLICT (gallery_id) DO
+		UPDATE
+		SET from_user_id = $2, email = $3, token_hash = $4, expires_at = $5, created_at = NOW()
+		RETURNING id, created_at;`,
+		transfer.GalleryID, transfer.FromUserID, transfer.Email, transfer.TokenHash, transfer.ExpiresAt)
+
+	err = row.Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("create transfer: %w", err)
+	}
+
+	err = notify(tx, &transfer)
+	if err != nil {
+		return nil, fmt.Errorf("create transfer: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("create transfer: %w", err)
+	}
+
+	return &transfer, nil
+}
+
+// ByGalleryID returns the pending transfer of a gallery, ErrNotFound when
+// there is none
+func (ts *TransferService) ByGalleryID(ctx context.Context, galleryID int) (*GalleryTransfer, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := ts.DB.QueryRowContext(ctx, `
+		SELECT gallery_transfers.id, gallery_transfers.gallery_id, gallery_transfers.from_user_id,
+			users.email, gallery_transfers.email, gallery_transfers.token_hash,
+			gallery_transfers.expires_at, gallery_transfers.created_at
+		FROM gallery_transfers
+			JOIN users ON users.id = gallery_transfers.from_user_id
+		WHERE gallery_transfers.gallery_id = $1;`, galleryID)
+
+	transfer, err := scanTransfer(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query transfer by gallery id: %w", err)
+	}
+
+	return &transfer, nil
+}
+
+// ByToken returns the transfer a link was sent for, ErrLinkExpired when it
+// can't be accepted anymore
+func (ts *TransferService) ByToken(ctx context.Context, token string) (*GalleryTransfer, error) {
+	tokenHash := ts.TokenManager.Hash(token)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := ts.DB.QueryRowContext(ctx, `
+		SELECT gallery_transfers.id, gallery_transfers.gallery_id, gallery_transfers.from_user_id,
+			users.email, gallery_transfers.email, gallery_transfers.token_hash,
+			gallery_transfers.expires_at, gallery_transfers.created_at
+		FROM gallery_transfers
+			JOIN users ON users.id = gallery_transfers.from_user_id
+		WHERE gallery_transfers.token_hash = $1;`, tokenHash)
+
+	transfer, err := scanTransfer(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query transfer by token: %w", err)
+	}
+
+	if transfer.Expired() {
+		return nil, fmt.Errorf("query transfer by token: %w", ErrLinkExpired)
+	}
+
+	return &transfer, nil
+}
+
+// Cancel deletes the pending transfer of a gallery
+func (ts *TransferService) Cancel(ctx context.Context, galleryID int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := ts.DB.ExecContext(ctx, `
+		DELETE FROM gallery_transfers
+		WHERE gallery_id = $1;`, galleryID)
+	if err != nil {
+		return fmt.Errorf("cancel transfer: %w", err)
+	}
+
+	return expectRows(result, "cancel transfer")
+}
+
+// Accept makes userID the owner of the gallery and moves it into their
+// workspace in a single transaction. Only the account with the email the
+// transfer was sent to can accept it, anyone else gets ErrNotFound, as does
+// a transfer of a gallery that changed hands since it was created. notify
+// queues the emails telling both owners along with it.
+func (ts *TransferService) Accept(ctx context.Context, token string, userID, workspaceID int, notify Notify[GalleryTransfer]) (*GalleryTransfer, error) {
+	tokenHash := ts.TokenManager.Hash(token)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := ts.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
+		DELETE FROM gallery_transfers
+		USING users
+		WHERE gallery_transfers.token_hash = $1
+			AND users.id = $2 AND users.email = gallery_transfers.email
+		RETURNING gallery_transfers.id, gallery_transfers.gallery_id, gallery_transfers.from_user_id,
+			(SELECT email FROM users WHERE id = gallery_transfers.from_user_id),
+			gallery_transfers.email, gallery_transfers.token_hash,
+			gallery_transfers.expires_at, gallery_transfers.created_at;`, tokenHash, userID)
+	transfer, err := scanTransfer(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	if transfer.Expired() {
+		return nil, fmt.Errorf("accept transfer: %w", ErrLinkExpired)
+	}
+
+	// The recipient must be able to add galleries to the workspace
+	result, err := tx.ExecContext(ctx, `
+		UPDATE galleries
+		SET user_id = $2, workspace_id = $3, updated_at = NOW()
+		WHERE id = $1 AND user_id = $4 AND EXISTS (
+			SELECT 1 FROM workspace_members
+			WHERE workspace_id = $3 AND user_id = $2 AND role <> $5
+		);`, transfer.GalleryID, userID, workspaceID, transfer.FromUserID, RoleViewer)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+	err = expectRows(result, "accept transfer")
+	if err != nil {
+		return nil, err
+	}
+
+	err = ts.dropOwnerCollaborator(ctx, tx, transfer.GalleryID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	err = notify(tx, &transfer)
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("accept transfer: %w", err)
+	}
+
+	return &transfer, nil
+}
+
+// ForceTransfer makes the account with email the owner of the gallery
+// without asking anyone, it is meant for admins. The gallery moves into the
+// first workspace the new owner owns and any pending transfer is dropped.
+// ErrNotFound means there is no account with email. notify queues the
+// emails telling both owners along with it.
+func (ts *TransferService) ForceTransfer(ctx context.Context, galleryID int, email string, notify Notify[GalleryTransfer]) (*GalleryTransfer, error) {
+	transfer := GalleryTransfer{
+		GalleryID: galleryID,
+		Email:     strings.ToLower(email),
+		CreatedAt: time.Now(),
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := ts.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+	defer tx.Rollback()
+
+	var toUserID int
+	row := tx.QueryRowContext(ctx, `
+		SELECT id FROM users WHERE email = $1;`, transfer.Email)
+	err = row.Scan(&toUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	row = tx.QueryRowContext(ctx, `
+		SELECT galleries.user_id, users.email
+		FROM galleries
+			JOIN users ON users.id = galleries.user_id
+		WHERE galleries.id = $1
+		FOR UPDATE OF galleries;`, galleryID)
+	err = row.Scan(&transfer.FromUserID, &transfer.FromEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE galleries
+		SET user_id = $2, updated_at = NOW(), workspace_id = COALESCE((
+			SELECT workspace_id FROM workspace_members
+			WHERE user_id = $2 AND role = $3
+			ORDER BY created_at, workspace_id
+			LIMIT 1
+		), workspace_id)
+		WHERE id = $1;`, galleryID, toUserID, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM gallery_transfers
+		WHERE gallery_id = $1;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	err = ts.dropOwnerCollaborator(ctx, tx, galleryID, toUserID)
+	if err != nil {
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	err = notify(tx, &transfer)
+	if err != nil {
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("force transfer: %w", err)
+	}
+
+	return &transfer, nil
+}
+
+// dropOwnerCollaborator removes the new owner from the collaborators, owning
+// the gallery already gives them every permission
+func (ts *TransferService) dropOwnerCollaborator(ctx context.Context, tx *sql.Tx, galleryID, userID int) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM gallery_collaborators
+		WHERE gallery_id = $1 AND user_id = $2;`, galleryID, userID)
+	return err
+}
+
+func scanTransfer(row scanner) (GalleryTransfer, error) {
+	var transfer GalleryTransfer
+	err := row.Scan(
+		&transfer.ID,
+		&transfer.GalleryID,
+		&transfer.FromUserID,
+		&transfer.FromEmail,
+		&transfer.Email,
+		&transfer.TokenHash,
+		&transfer.ExpiresAt,
+		&transfer.CreatedAt,
+	)
+	return transfer, err
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rahulbalajee/lenslocked/rand"
+)
+
+// Visibility decides who can see a gallery
+type Visibility string
+
+const (
+	// VisibilityPrivate galleries are only shown to their owner
+	VisibilityPrivate Visibility = "private"
+	// VisibilityUnlisted galleries are shown to anyone who has the link with
+	// the slug, they can't be found any other way
+	VisibilityUnlisted Visibility = "unlisted"
+	// VisibilityPublic galleries are shown to everyone, old links with the
+	// numeric ID keep working for them
+	VisibilityPublic Visibility = "public"
+)
+
+// Visibilities lists every visibility in the order they are offered to users
+var Visibilities = []Visibility{VisibilityPrivate, VisibilityUnlisted, VisibilityPublic}
+
+func (v Visibility) Valid() bool {
+	return slices.Contains(Visibilities, v)
+}
+
+// Label is the human readable name of the visibility
+func (v Visibility) Label() string {
+	switch v {
+	case VisibilityPrivate:
+		return "Private (only you can see)"
+	case VisibilityUnlisted:
+		return "Unlisted (anyone with the link can see)"
+	case VisibilityPublic:
+		return "Public (everyone can see)"
+	}
+	return string(v)
+}
+
+// Shared tells if the gallery can be seen by people other than its owner
+func (v Visibility) Shared() bool {
+	return v == VisibilityUnlisted || v == VisibilityPublic
+}
+
+// Number of random bytes in a slug, 12 bytes encode to 16 characters
+const slugBytes = 12
+
+// newSlug returns a random slug, slugs are unguessable so unlisted galleries
+// can't be found by walking through IDs
+func newSlug() (string, error) {
+	slug, err := rand.String(slugBytes)
+	if err != nil {
+		return "", fmt.Errorf("new slug: %w", err)
+	}
+	return slug, nil
+}
+
+// BySlug returns the gallery with the given slug whatever its visibility,
+// callers decide who may see it
+func (gs *GalleryService) BySlug(ctx context.Context, slug string) (*Gallery, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := gs.DB.QueryRowContext(ctx, `
+		SELECT `+galleryColumns+`
+		FROM galleries
+		WHERE slug = $1 AND deleted_at IS NULL;`, slug)
+
+	gallery, err := scanGallery(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query gallery by slug: %w", err)
+	}
+
+	return &gallery, nil
+}
+
+// ResetSlug gives the gallery a new slug, links using the old one stop working
+func (gs *GalleryService) ResetSlug(ctx context.Context, gallery *Gallery) error {
+	slug, err := newSlug()
+	if err != nil {
+		return fmt.Errorf("reset slug: %w", err)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := gs.DB.QueryRowContext(ctx, `
+		UPDATE galleries
+		SET slug = $2, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at;`, gallery.ID, slug)
+
+	err = row.Scan(&gallery.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("reset slug: %w", err)
+	}
+	gallery.Slug = slug
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits on ZIP imports. archive/zip refuses to read more than an entry
+// claims to hold, so checking the sizes in the archive's directory is
+// enough to keep a small upload from unpacking into more than the disk
+// can take.
+const (
+	maxImportEntries   = 2000
+	maxImportImageSize = 100 << 20
+	maxImportSize      = 4 << 30
+	// Photos barely compress, a much higher ratio is a sign of a zip bomb
+	maxImportRatio = 100
+)
+
+// ImportEntry is a file found in a ZIP archive of images
+type ImportEntry struct {
+	// Name is the path of the file in the archive
+	Name string
+	// Folder is the top-level folder the file is in, it is empty for files
+	// at the root of the archive or when folders aren't imported
+	Folder string
+	// Filename is the name the image is saved under
+	Filename string
+	// Issue is why the file can't be imported, it is empty for images
+	Issue string
+
+	file *zip.File
+}
+
+// Open returns the contents of the file
+func (e ImportEntry) Open() (io.ReadCloser, error) {
+	return e.file.Open()
+}
+
+// ImportEntries lists the files of a ZIP archive and checks which of them
+// can be imported as images. Nothing is ever written to the path stored in
+// the archive, images are saved under their base name, but paths that try
+// to leave the archive are reported all the same. With folders set the
+// files are grouped by their top-level folder, files deeper down belong to
+// the top-level folder they are in.
+func (is *ImageService) ImportEntries(zr *zip.Reader, folders bool) ([]ImportEntry, error) {
+	if len(zr.File) > maxImportEntries {
+		return nil, FileError{
+			Issue: fmt.Sprintf("the archive has more than %d files", maxImportEntries),
+		}
+	}
+
+	var entries []ImportEntry
+	var total uint64
+	// seen maps the folder and filename of every image to the entry it
+	// came from, the same name twice would overwrite the first image
+	seen := make(map[string]string)
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		entry := ImportEntry{
+			Name:     file.Name,
+			Filename: path.Base(file.Name),
+			file:     file,
+		}
+		parts := strings.Split(file.Name, "/")
+		if folders && len(parts) > 1 {
+			entry.Folder = parts[0]
+		}
+
+		switch {
+		case strings.Contains(file.Name, `\`) || !filepath.IsLocal(file.Name):
+			entry.Issue = "the path points outside of the archive"
+		case parts[0] == "__MACOSX" || strings.HasPrefix(entry.Filename, "._") || entry.Filename == ".DS_Store":
+			entry.Issue = "macOS metadata, not an image"
+		case strings.HasPrefix(entry.Filename, "."):
+			entry.Issue = "hidden file"
+		case !file.Mode().IsRegular():
+			entry.Issue = "not a regular file"
+		case !hasExtension(entry.Filename, is.extensions()):
+			entry.Issue = fmt.Sprintf("invalid extension: %v", filepath.Ext(entry.Filename))
+		case file.UncompressedSize64 == 0:
+			entry.Issue = "empty file"
+		case file.UncompressedSize64 > maxImportImageSize:
+			entry.Issue = fmt.Sprintf("larger than %d MB", maxImportImageSize>>20)
+		case file.CompressedSize64 == 0 || file.UncompressedSize64/file.CompressedSize64 > maxImportRatio:
+			entry.Issue = "compressed too well to be a photo"
+		case total+file.UncompressedSize64 > maxImportSize:
+			entry.Issue = fmt.Sprintf("the archive unpacks to more than %d GB", maxImportSize>>30)
+		}
+
+		key := entry.Folder + "/" + entry.Filename
+		if other, ok := seen[key]; ok && entry.Issue == "" {
+			entry.Issue = fmt.Sprintf("has the same name as %v", other)
+		}
+
+		if entry.Issue == "" {
+			seen[key] = entry.Name
+			total += file.UncompressedSize64
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
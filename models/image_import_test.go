@@ -0,0 +1,240 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// zipFile is a file of a test archive, its contents are stored as they are
+// but the archive claims they unpack to size bytes
+type zipFile struct {
+	name     string
+	contents string
+	size     uint64
+}
+
+func buildZip(t *testing.T, files []zipFile) *zip.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		size := f.size
+		if size == 0 {
+			size = uint64(len(f.contents))
+		}
+		fw, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               f.name,
+			Method:             zip.Store,
+			CompressedSize64:   uint64(len(f.contents)),
+			UncompressedSize64: size,
+		})
+		if err != nil {
+			t.Fatalf("CreateRaw(%q) err = %v", f.name, err)
+		}
+		_, err = fw.Write([]byte(f.contents))
+		if err != nil {
+			t.Fatalf("Write(%q) err = %v", f.name, err)
+		}
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatalf("Close() err = %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	// Paths leaving the archive are only reported when GODEBUG asks for it,
+	// the reader is usable either way
+	if err != nil && !errors.Is(err, zip.ErrInsecurePath) {
+		t.Fatalf("NewReader() err = %v", err)
+	}
+	return zr
+}
+
+func TestImportEntries(t *testing.T) {
+	photo := strings.Repeat("x", 1<<20)
+	// Enough photos at the size limit to unpack to more than maxImportSize
+	var tooLarge []zipFile
+	for i := range maxImportSize/maxImportImageSize + 1 {
+		tooLarge = append(tooLarge, zipFile{
+			name:     fmt.Sprintf("photos/%02d.jpg", i),
+			contents: photo,
+			size:     maxImportImageSize,
+		})
+	}
+
+	tests := map[string]struct {
+		files   []zipFile
+		folders bool
+		// issues maps the names of the entries to the issue reported
+		issues map[string]string
+	}{
+		"images": {
+			files: []zipFile{
+				{name: "beach.jpg", contents: "jpeg"},
+				{name: "summer/sunset.png", contents: "png"},
+			},
+			issues: map[string]string{
+				"beach.jpg":         "",
+				"summer/sunset.png": "",
+			},
+		},
+		"zip slip": {
+			files: []zipFile{
+				{name: "../../etc/cron.jpg", contents: "jpeg"},
+				{name: "/tmp/absolute.jpg", contents: "jpeg"},
+				{name: `..\windows.jpg`, contents: "jpeg"},
+			},
+			issues: map[string]string{
+				"../../etc/cron.jpg": "the path points outside of the archive",
+				"/tmp/absolute.jpg":  "the path points outside of the archive",
+				`..\windows.jpg`:     "the path points outside of the archive",
+			},
+		},
+		"zip bomb": {
+			files: []zipFile{
+				{name: "bomb.jpg", contents: "jpeg", size: 4 * (maxImportRatio + 1)},
+				{name: "empty.jpg", contents: "", size: 100},
+			},
+			issues: map[string]string{
+				"bomb.jpg":  "compressed too well to be a photo",
+				"empty.jpg": "compressed too well to be a photo",
+			},
+		},
+		"too large": {
+			files: []zipFile{
+				{name: "huge.jpg", contents: photo, size: maxImportImageSize + 1},
+			},
+			issues: map[string]string{
+				"huge.jpg": "larger than 100 MB",
+			},
+		},
+		"total size": {
+			files: tooLarge,
+			issues: map[string]string{
+				tooLarge[0].name:               "",
+				tooLarge[len(tooLarge)-1].name: "the archive unpacks to more than 4 GB",
+			},
+		},
+		"duplicate names": {
+			files: []zipFile{
+				{name: "a/beach.jpg", contents: "jpeg"},
+				{name: "b/beach.jpg", contents: "jpeg"},
+			},
+			issues: map[string]string{
+				"a/beach.jpg": "",
+				"b/beach.jpg": "has the same name as a/beach.jpg",
+			},
+		},
+		"duplicate names in other folders": {
+			files: []zipFile{
+				{name: "a/beach.jpg", contents: "jpeg"},
+				{name: "b/beach.jpg", contents: "jpeg"},
+			},
+			folders: true,
+			issues: map[string]string{
+				"a/beach.jpg": "",
+				"b/beach.jpg": "",
+			},
+		},
+		"macOS metadata": {
+			files: []zipFile{
+				{name: "__MACOSX/beach.jpg", contents: "jpeg"},
+				{name: "summer/._beach.jpg", contents: "jpeg"},
+				{name: ".DS_Store", contents: "meta"},
+			},
+			issues: map[string]string{
+				"__MACOSX/beach.jpg": "macOS metadata, not an image",
+				"summer/._beach.jpg": "macOS metadata, not an image",
+				".DS_Store":          "macOS metadata, not an image",
+			},
+		},
+		"other files": {
+			files: []zipFile{
+				{name: ".hidden.jpg", contents: "jpeg"},
+				{name: "notes.txt", contents: "text"},
+				{name: "blank.jpg", contents: ""},
+			},
+			issues: map[string]string{
+				".hidden.jpg": "hidden file",
+				"notes.txt":   "invalid extension: .txt",
+				"blank.jpg":   "empty file",
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			is := &ImageService{}
+			entries, err := is.ImportEntries(buildZip(t, tc.files), tc.folders)
+			if err != nil {
+				t.Fatalf("ImportEntries() err = %v", err)
+			}
+			if len(entries) != len(tc.files) {
+				t.Fatalf("ImportEntries() returned %d entries, want %d", len(entries), len(tc.files))
+			}
+
+			issues := make(map[string]string)
+			for _, entry := range entries {
+				issues[entry.Name] = entry.Issue
+			}
+			for entryName, want := range tc.issues {
+				got, ok := issues[entryName]
+				if !ok {
+					t.Errorf("no entry for %q", entryName)
+					continue
+				}
+				if got != want {
+					t.Errorf("issue of %q = %q, want %q", entryName, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestImportEntriesFolders(t *testing.T) {
+	zr := buildZip(t, []zipFile{
+		{name: "beach.jpg", contents: "jpeg"},
+		{name: "summer/sunset.jpg", contents: "jpeg"},
+		{name: "summer/day 1/dunes.jpg", contents: "jpeg"},
+	})
+
+	is := &ImageService{}
+	entries, err := is.ImportEntries(zr, true)
+	if err != nil {
+		t.Fatalf("ImportEntries() err = %v", err)
+	}
+
+	want := map[string][2]string{
+		"beach.jpg":              {"", "beach.jpg"},
+		"summer/sunset.jpg":      {"summer", "sunset.jpg"},
+		"summer/day 1/dunes.jpg": {"summer", "dunes.jpg"},
+	}
+	for _, entry := range entries {
+		got := [2]string{entry.Folder, entry.Filename}
+		if got != want[entry.Name] {
+			t.Errorf("%q is in folder %q as %q, want %q as %q",
+				entry.Name, got[0], got[1], want[entry.Name][0], want[entry.Name][1])
+		}
+	}
+}
+
+func TestImportEntriesTooMany(t *testing.T) {
+	files := make([]zipFile, maxImportEntries+1)
+	for i := range files {
+		files[i] = zipFile{name: strings.Repeat("a", i%10+1) + ".jpg", contents: "jpeg"}
+	}
+
+	is := &ImageService{}
+	_, err := is.ImportEntries(buildZip(t, files), false)
+	var fileErr FileError
+	if !errors.As(err, &fileErr) {
+		t.Fatalf("ImportEntries() err = %v, want a FileError", err)
+	}
+	if fileErr.Issue != "the archive has more than 2000 files" {
+		t.Errorf("Issue = %q", fileErr.Issue)
+	}
+}
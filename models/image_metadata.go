@@ -0,0 +1,372 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Only the beginning of a file is read when looking for metadata, EXIF, XMP
+// and IPTC segments all come before the image data and are at most 64KB each
+const metadataReadLimit = 256 << 10
+
+// imageMetadata holds what we read from the metadata embedded in an image
+type imageMetadata struct {
+	// CapturedAt is when the photo was taken, nil when the file doesn't say
+	CapturedAt *time.Time
+	Title      string
+	// Description is what the photo shows, photographers often fill it in
+	// in Lightroom or Photo Mechanic
+	Description string
+}
+
+// readImageMetadata extracts metadata from a JPEG file. Unsupported formats
+// and broken metadata are not errors, the fields are simply left empty.
+func readImageMetadata(path string) imageMetadata {
+	var meta imageMetadata
+
+	f, err := os.Open(path)
+	if err != nil {
+		return meta
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, metadataReadLimit))
+	if err != nil {
+		return meta
+	}
+
+	// The same fields can be found in several places, XMP wins over IPTC
+	// which wins over EXIF as it is the most likely to be up to date
+	var exif, iptc, xmp imageMetadata
+	for _, segment := range jpegSegments(data) {
+		switch {
+		case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, exifHeader):
+			exif = readEXIF(segment.data[len(exifHeader):])
+		case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, xmpHeader):
+			xmp = readXMP(segment.data[len(xmpHeader):])
+		case segment.marker == 0xED && bytes.HasPrefix(segment.data, photoshopHeader):
+			iptc = readPhotoshopIPTC(segment.data[len(photoshopHeader):])
+		}
+	}
+
+	meta.CapturedAt = exif.CapturedAt
+	meta.Title = firstNonEmpty(xmp.Title, iptc.Title)
+	meta.Description = firstNonEmpty(xmp.Description, iptc.Description, exif.Description)
+
+	return meta
+}
+
+var (
+	exifHeader      = []byte("Exif\x00\x00")
+	xmpHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+// jpegSegments returns the marker segments found before the image data starts
+func jpegSegments(data []byte) []jpegSegment {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+
+	var segments []jpegSegment
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			break
+		}
+		marker := data[i+1]
+		// Start of scan, the compressed image follows
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segments = append(segments, jpegSegment{
+			marker: marker,
+			data:   data[i+4 : i+2+length],
+		})
+		i += 2 + length
+	}
+
+	return segments
+}
+
+const (
+	exifTagImageDescription = 0x010E
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// tiffReader reads IFD entries from the TIFF structure EXIF data is stored in
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(data []byte) (*tiffReader, uint32, bool) {
+	if len(data) < 8 {
+		return nil, 0, false
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, false
+	}
+	if order.Uint16(data[2:4]) != 42 {
+		return nil, 0, false
+	}
+	return &tiffReader{data: data, order: order}, order.Uint32(data[4:8]), true
+}
+
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	// value holds the value itself when it fits in 4 bytes, an offset otherwise
+	value []byte
+}
+
+// ifd returns the entries of the IFD at offset keyed by tag
+func (t *tiffReader) ifd(offset uint32) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if int(offset)+2 > len(t.data) {
+		return entries
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	start := int(offset) + 2
+	for i := 0; i < n; i++ {
+		pos := start + i*12
+		if pos+12 > len(t.data) {
+			break
+		}
+		entries[t.order.Uint16(t.data[pos:])] = ifdEntry{
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:]),
+			value: t.data[pos+8 : pos+12],
+		}
+	}
+	return entries
+}
+
+// ascii returns the value of an ASCII entry without its NUL terminator
+func (t *tiffReader) ascii(entry ifdEntry) (string, bool) {
+	const typeASCII = 2
+	if entry.typ != typeASCII {
+		return "", false
+	}
+	raw := entry.value
+	if entry.count > 4 {
+		offset := t.order.Uint32(entry.value)
+		end := uint64(offset) + uint64(entry.count)
+		if end > uint64(len(t.data)) {
+			return "", false
+		}
+		raw = t.data[offset:end]
+	} else {
+		raw = raw[:entry.count]
+	}
+	return strings.TrimRight(string(raw), "\x00 "), true
+}
+
+// readEXIF reads the capture time and the image description. The capture
+// time is DateTimeOriginal, falling back to DateTime. EXIF dates carry no
+// timezone so they are interpreted as UTC.
+func readEXIF(data []byte) imageMetadata {
+	var meta imageMetadata
+
+	t, ifd0Offset, ok := newTIFFReader(data)
+	if !ok {
+		return meta
+	}
+
+	ifd0 := t.ifd(ifd0Offset)
+	if entry, ok := ifd0[exifTagImageDescription]; ok {
+		meta.Description, _ = t.ascii(entry)
+	}
+
+	candidates := []ifdEntry{}
+	if exifIFD, ok := ifd0[exifTagExifIFD]; ok {
+		exif := t.ifd(t.order.Uint32(exifIFD.value))
+		if entry, ok := exif[exifTagDateTimeOriginal]; ok {
+			candidates = append(candidates, entry)
+		}
+	}
+	if entry, ok := ifd0[exifTagDateTime]; ok {
+		candidates = append(candidates, entry)
+	}
+
+	for _, entry := range candidates {
+		value, ok := t.ascii(entry)
+		if !ok {
+			continue
+		}
+		captured, err := time.Parse("2006:01:02 15:04:05", value)
+		if err == nil {
+			meta.CapturedAt = &captured
+			break
+		}
+	}
+
+	return meta
+}
+
+const (
+	xmpNamespaceDC  = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// readXMP reads dc:title and dc:description from an XMP packet. Both are
+// language alternatives, the x-default entry is used when there is one and
+// the first entry otherwise.
+func readXMP(packet []byte) imageMetadata {
+	var meta imageMetadata
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	decoder.Strict = false
+
+	// field points at the value being filled while inside dc:title or dc:description
+	var field *string
+	var isDefault bool
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch el := token.(type) {
+		case xml.StartElement:
+			if el.Name.Space == xmpNamespaceDC && el.Name.Local == "title" {
+				field = &meta.Title
+			}
+			if el.Name.Space == xmpNamespaceDC && el.Name.Local == "description" {
+				field = &meta.Description
+			}
+			if field != nil && el.Name.Space == xmpNamespaceRDF && el.Name.Local == "li" {
+				isDefault = false
+				for _, attr := range el.Attr {
+					if attr.Name.Local == "lang" && attr.Value == "x-default" {
+						isDefault = true
+					}
+				}
+				var value string
+				err = decoder.DecodeElement(&value, &el)
+				if err != nil {
+					return meta
+				}
+				if *field == "" || isDefault {
+					*field = strings.TrimSpace(value)
+				}
+			}
+		case xml.EndElement:
+			if el.Name.Space == xmpNamespaceDC && (el.Name.Local == "title" || el.Name.Local == "description") {
+				field = nil
+			}
+		}
+	}
+
+	return meta
+}
+
+const (
+	photoshopResourceIPTC = 0x0404
+	iptcObjectName        = 5
+	iptcCaption           = 120
+)
+
+// readPhotoshopIPTC finds the IPTC-IIM block among the Photoshop image
+// resources of an APP13 segment and reads the object name and caption
+func readPhotoshopIPTC(data []byte) imageMetadata {
+	var meta imageMetadata
+
+	for len(data) >= 12 && string(data[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[4:6])
+		// The resource name is a Pascal string padded to an even length
+		nameSize := 1 + int(data[6])
+		if nameSize%2 == 1 {
+			nameSize++
+		}
+		pos := 6 + nameSize
+		if pos+4 > len(data) {
+			break
+		}
+		size := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		end := pos + size
+		if size < 0 || end > len(data) {
+			break
+		}
+		if id == photoshopResourceIPTC {
+			return readIPTC(data[pos:end])
+		}
+		if size%2 == 1 {
+			end++
+		}
+		if end > len(data) {
+			break
+		}
+		data = data[end:]
+	}
+
+	return meta
+}
+
+func readIPTC(data []byte) imageMetadata {
+	var meta imageMetadata
+
+	for len(data) >= 5 && data[0] == 0x1C {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:5]))
+		// Extended datasets are only used for huge values we don't care about
+		if size&0x8000 != 0 || 5+size > len(data) {
+			break
+		}
+		value := iptcString(data[5 : 5+size])
+		if record == 2 && dataset == iptcObjectName {
+			meta.Title = value
+		}
+		if record == 2 && dataset == iptcCaption {
+			meta.Description = value
+		}
+		data = data[5+size:]
+	}
+
+	return meta
+}
+
+// iptcString decodes an IPTC value. Modern tools write UTF-8, older ones
+// Latin-1 which maps directly onto the first 256 code points.
+func iptcString(value []byte) string {
+	if utf8.Valid(value) {
+		return string(value)
+	}
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
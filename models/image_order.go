@@ -0,0 +1,335 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ImageSort is the order the images of a gallery are shown in
+type ImageSort string
+
+const (
+	// SortManual uses the order chosen by the owner with drag and drop
+	SortManual   ImageSort = "manual"
+	SortCaptured ImageSort = "captured"
+	SortUploaded ImageSort = "uploaded"
+	SortFilename ImageSort = "filename"
+)
+
+// ImageSorts lists every sort mode in the order they are offered to users
+var ImageSorts = []ImageSort{SortManual, SortCaptured, SortUploaded, SortFilename}
+
+func (s ImageSort) Valid() bool {
+	return slices.Contains(ImageSorts, s)
+}
+
+// Label is the human readable name of the sort mode
+func (s ImageSort) Label() string {
+	switch s {
+	case SortManual:
+		return "Manual"
+	case SortCaptured:
+		return "Capture date"
+	case SortUploaded:
+		return "Upload date"
+	case SortFilename:
+		return "Filename"
+	}
+	return string(s)
+}
+
+// Reorder sets the manual order of a gallery's images. filenames must list
+// every image of the gallery exactly once, otherwise ErrInvalidOrder is returned.
+func (is *ImageService) Reorder(ctx context.Context, galleryID int, filenames []string) error {
+	images, err := is.Images(ctx, galleryID)
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+
+	if len(filenames) != len(images) {
+		return fmt.Errorf("reorder images: %w", ErrInvalidOrder)
+	}
+	existing := make(map[string]bool, len(images))
+	for _, image := range images {
+		existing[image.Filename] = true
+	}
+	for _, filename := range filenames {
+		if !existing[filename] {
+			return fmt.Errorf("reorder images: %w", ErrInvalidOrder)
+		}
+		// Seeing a filename twice means another one is missing
+		delete(existing, filename)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := is.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+	defer tx.Rollback()
+
+	for position, filename := range filenames {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE images
+			SET position = $3
+			WHERE gallery_id = $1 AND filename = $2 AND deleted_at IS NULL;`, galleryID, filename, position)
+		if err != nil {
+			return fmt.Errorf("reorder images: %w", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("reorder images: %w", err)
+	}
+
+	return nil
+}
+
+// SyncImages brings the images table in line with the files of every
+// gallery. Uploads, deletes and restores keep their rows up to date, this
+// only catches files that were added or removed by hand, and images trashed
+// before the trash was recorded in the table.
+func (is *ImageService) SyncImages(ctx context.Context) error {
+	dirs, err := is.galleryDirs()
+	if err != nil {
+		return fmt.Errorf("sync images: %w", err)
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	queryCtx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// Directories of deleted galleries are left to the garbage collector
+	rows, err := is.DB.QueryContext(queryCtx, `
+		SELECT id FROM galleries
+		WHERE id = ANY($1);`, dirs)
+	if err != nil {
+		return fmt.Errorf("sync images: %w", err)
+	}
+	defer rows.Close()
+
+	var galleryIDs []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return fmt.Errorf("sync images: %w", err)
+		}
+		galleryIDs = append(galleryIDs, id)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("sync images: %w", err)
+	}
+	rows.Close()
+
+	for _, galleryID := range galleryIDs {
+		err = is.syncImages(ctx, galleryID)
+		if err != nil {
+			return fmt.Errorf("sync images of gallery %d: %w", galleryID, err)
+		}
+	}
+
+	return nil
+}
+
+// syncImages creates the missing rows of a gallery's files at the end of the
+// manual order and removes rows whose file is gone. Trashed images keep
+// their row so they return to their old place when restored.
+func (is *ImageService) syncImages(ctx context.Context, galleryID int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// Rows are read before the files are listed. An image deleted in between
+	// then looks like a missing file rather than a new one, and the DELETE
+	// below leaves its row alone since it is trashed by then.
+	rows, err := is.DB.QueryContext(ctx, `
+		SELECT filename, trash_name, position
+		FROM images
+		WHERE gallery_id = $1;`, galleryID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	known := make(map[string]bool)
+	var trashed []string
+	nextPosition := 0
+	for rows.Next() {
+		var filename string
+		var trashName *string
+		var position int
+		err = rows.Scan(&filename, &trashName, &position)
+		if err != nil {
+			return err
+		}
+		nextPosition = max(nextPosition, position+1)
+		if trashName != nil {
+			trashed = append(trashed, *trashName)
+			continue
+		}
+		known[filename] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	files, err := is.imageFiles(is.imagesDir(galleryID))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		filename := filepath.Base(file)
+		if known[filename] {
+			delete(known, filename)
+			continue
+		}
+		err = is.insertImage(ctx, galleryID, file, nextPosition)
+		if err != nil {
+			return err
+		}
+		nextPosition++
+	}
+
+	// Images trashed before the trash was recorded here sit in the trash
+	// under their own name
+	var stale, staleTrash []string
+	for filename := range known {
+		info, err := os.Stat(filepath.Join(is.trashDir(galleryID), filename))
+		if errors.Is(err, os.ErrNotExist) {
+			stale = append(stale, filename)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		_, err = is.DB.ExecContext(ctx, `
+			UPDATE images
+			SET deleted_at = $3, trash_name = filename
+			WHERE gallery_id = $1 AND filename = $2 AND deleted_at IS NULL;`, galleryID, filename, info.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+	for _, trashName := range trashed {
+		_, err := os.Stat(filepath.Join(is.trashDir(galleryID), trashName))
+		if errors.Is(err, os.ErrNotExist) {
+			staleTrash = append(staleTrash, trashName)
+		}
+	}
+	if len(stale) > 0 || len(staleTrash) > 0 {
+		_, err = is.DB.ExecContext(ctx, `
+			DELETE FROM images
+			WHERE gallery_id = $1 AND (
+				(deleted_at IS NULL AND filename = ANY($2)) OR trash_name = ANY($3)
+			);`, galleryID, stale, staleTrash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertImage creates the row of a file that doesn't have one yet. The upload
+// date is the file's modification time since that is all we know about it.
+func (is *ImageService) insertImage(ctx context.Context, galleryID int, path string, position int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	meta := readImageMetadata(path)
+	// An upload may have inserted the row since we looked, keep theirs
+	_, err = is.DB.ExecContext(ctx, `
+		INSERT INTO images (gallery_id, filename, position, captured_at, uploaded_at, title, caption, alt_text)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		ON CONFLICT (gallery_id, filename) WHERE deleted_at IS NULL DO NOTHING;`,
+		galleryID, filepath.Base(path), position, meta.CapturedAt, info.ModTime(), meta.Title, meta.Description)
+	return err
+}
+
+// addImage records a freshly uploaded file at the end of the manual order,
+// its title, caption and alt text are prefilled from the file's metadata.
+// Uploading over an existing file keeps its position and texts but refreshes
+// its dates.
+func (is *ImageService) addImage(ctx context.Context, galleryID int, path string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	meta := readImageMetadata(path)
+	_, err := is.DB.ExecContext(ctx, `
+		INSERT INTO images (gallery_id, filename, position, captured_at, uploaded_at, title, caption, alt_text)
+		VALUES ($1, $2, (SELECT COALESCE(MAX(position) + 1, 0) FROM images WHERE gallery_id = $1), $3, $4, $5, $6, $6)
+		ON CONFLICT (gallery_id, filename) WHERE deleted_at IS NULL DO UPDATE
+		SET captured_at = EXCLUDED.captured_at, uploaded_at = EXCLUDED.uploaded_at;`,
+		galleryID, filepath.Base(path), meta.CapturedAt, time.Now(), meta.Title, meta.Description)
+	if err != nil {
+		return fmt.Errorf("add image: %w", err)
+	}
+
+	return nil
+}
+
+// sortMode returns the sort mode of a gallery, galleries that don't exist
+// are sorted manually
+func (is *ImageService) sortMode(ctx context.Context, galleryID int) (ImageSort, error) {
+	var mode ImageSort
+	err := is.DB.QueryRowContext(ctx, `
+		SELECT sort_mode
+		FROM galleries
+		WHERE id = $1;`, galleryID).Scan(&mode)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SortManual, nil
+		}
+		return "", err
+	}
+	return mode, nil
+}
+
+// imageSortKey returns the columns images are ordered by in mode. Ties are
+// broken by filename so the order is stable between requests. Images
+// without a capture date fall back to their upload date.
+func imageSortKey(mode ImageSort) []string {
+	switch mode {
+	case SortManual:
+		return []string{"position", "filename"}
+	case SortCaptured:
+		return []string{"COALESCE(captured_at, uploaded_at)", "filename"}
+	case SortUploaded:
+		return []string{"uploaded_at", "filename"}
+	}
+	return []string{"filename"}
+}
+
+// descending turns a sort key into an ORDER BY clause going the other way
+func descending(key []string) string {
+	columns := make([]string, len(key))
+	for i, column := range key {
+		columns[i] = column + " DESC"
+	}
+	return strings.Join(columns, ", ")
+}
+
+// placeholders returns n query parameters starting at $first
+func placeholders(first, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", first+i)
+	}
+	return strings.Join(params, ", ")
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "image/gif"
+)
+
+// Web-size copies of large images are kept in this directory inside their
+// gallery directory. A copy has the mtime of its original so it is made
+// again when the original is replaced.
+const webDirName = ".web"
+
+// webImageSize is the longest side of a web-size image in pixels
+const webImageSize = 2048
+
+// maxWebSourcePixels caps the images web-size versions are made of, decoding
+// a larger one takes too much memory so it is sent as it is
+const maxWebSourcePixels = 100_000_000
+
+// webImage returns the path of the web-size version of an image, making it
+// when it doesn't exist yet or is out of date. Images that are small enough
+// already, and GIFs which may be animated, are their own web-size version.
+func (is *ImageService) webImage(original Image, info fs.FileInfo) (string, error) {
+	ext := strings.ToLower(filepath.Ext(original.Filename))
+	if ext == ".gif" {
+		return original.Path, nil
+	}
+
+	webDir := filepath.Join(is.imagesDir(original.GalleryID), webDirName)
+	webPath := filepath.Join(webDir, original.Filename)
+	webInfo, err := os.Stat(webPath)
+	if err == nil && webInfo.ModTime().Equal(info.ModTime()) {
+		return webPath, nil
+	}
+
+	f, err := os.Open(original.Path)
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+	defer f.Close()
+
+	// Images that can't be decoded, or would take too much memory to, are
+	// left as they are instead of failing the whole download
+	config, _, err := image.DecodeConfig(f)
+	if err != nil || max(config.Width, config.Height) <= webImageSize ||
+		config.Width*config.Height > maxWebSourcePixels {
+		return original.Path, nil
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return original.Path, nil
+	}
+
+	err = os.MkdirAll(webDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+
+	// Write to a temporary file first so a download running at the same
+	// time never picks up half an image
+	tmp, err := os.CreateTemp(webDir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	dst := scaleDown(src, webImageSize)
+	if ext == ".png" {
+		err = png.Encode(tmp, dst)
+	} else {
+		err = jpeg.Encode(tmp, dst, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return "", fmt.Errorf("web image %v: %w", original.Filename, err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+
+	err = os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+	err = os.Rename(tmp.Name(), webPath)
+	if err != nil {
+		return "", fmt.Errorf("web image: %w", err)
+	}
+
+	return webPath, nil
+}
+
+// removeWebImage removes the web-size version of an image, if there is one
+func (is *ImageService) removeWebImage(galleryID int, filename string) error {
+	err := os.Remove(filepath.Join(is.imagesDir(galleryID), webDirName, filename))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// scaleDown shrinks src so its longest side is size pixels, every pixel of
+// the result is the average of the pixels it covers in src
+func scaleDown(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	dstW, dstH := size, size
+	if srcW > srcH {
+		dstH = max(1, srcH*size/srcW)
+	} else {
+		dstW = max(1, srcW*size/srcH)
+	}
+
+	// Work on RGBA pixels directly, calling At for every pixel of a large
+	// photo is slow. Only the rows of src that make up one row of dst are
+	// converted at a time so there is never a second full-size copy.
+	band := image.NewRGBA(image.Rect(0, 0, srcW, srcH/dstH+2))
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := range dstH {
+		y0, y1 := y*srcH/dstH, max((y+1)*srcH/dstH, y*srcH/dstH+1)
+		draw.Draw(band, image.Rect(0, 0, srcW, y1-y0), src, bounds.Min.Add(image.Pt(0, y0)), draw.Src)
+		for x := range dstW {
+			x0, x1 := x*srcW/dstW, max((x+1)*srcW/dstW, x*srcW/dstW+1)
+			var sum [4]int
+			for sy := range y1 - y0 {
+				row := band.Pix[sy*band.Stride+x0*4 : sy*band.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			count := (y1 - y0) * (x1 - x0)
+			i := dst.PixOffset(x, y)
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScaleDown(t *testing.T) {
+	// Left half red, right half blue
+	src := image.NewYCbCr(image.Rect(0, 0, 400, 100), image.YCbCrSubsampleRatio420)
+	for y := range 100 {
+		for x := range 400 {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 200 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			src.Y[src.YOffset(x, y)] = yy
+			src.Cb[src.COffset(x, y)] = cb
+			src.Cr[src.COffset(x, y)] = cr
+		}
+	}
+
+	dst := scaleDown(src, 40)
+	if got := dst.Bounds().Size(); got != image.Pt(40, 10) {
+		t.Fatalf("scaleDown() size = %v, want (40,10)", got)
+	}
+	for _, tc := range []struct {
+		x    int
+		want func(color.RGBA) bool
+	}{
+		{0, func(c color.RGBA) bool { return c.R > 200 && c.B < 50 }},
+		{39, func(c color.RGBA) bool { return c.B > 200 && c.R < 50 }},
+	} {
+		for y := range 10 {
+			if c := dst.RGBAAt(tc.x, y); !tc.want(c) {
+				t.Errorf("pixel (%d,%d) = %v", tc.x, y, c)
+			}
+		}
+	}
+}
+
+func TestWebImage(t *testing.T) {
+	tests := map[string]struct {
+		data func(t *testing.T) []byte
+		// scaled is true when a web-size version should be made
+		scaled bool
+	}{
+		"large": {
+			data: func(t *testing.T) []byte {
+				return encodePNG(t, image.NewNRGBA(image.Rect(0, 0, webImageSize*2, 10)))
+			},
+			scaled: true,
+		},
+		"small": {
+			data: func(t *testing.T) []byte {
+				return encodePNG(t, image.NewNRGBA(image.Rect(0, 0, webImageSize, 10)))
+			},
+		},
+		"too many pixels": {
+			data: func(t *testing.T) []byte {
+				data := pngHeader(20000, 20000)
+				_, _, err := image.DecodeConfig(bytes.NewReader(data))
+				if err != nil {
+					t.Fatalf("DecodeConfig() err = %v, want the header to be valid", err)
+				}
+				return data
+			},
+		},
+		"not an image": {
+			data: func(t *testing.T) []byte {
+				return []byte("not a png")
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			is := &ImageService{Dir: t.TempDir()}
+			dir := is.imagesDir(1)
+			err := os.MkdirAll(dir, 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := Image{GalleryID: 1, Filename: "photo.png", Path: filepath.Join(dir, "photo.png")}
+			err = os.WriteFile(original.Path, tc.data(t), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(original.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := is.webImage(original, info)
+			if err != nil {
+				t.Fatalf("webImage() err = %v", err)
+			}
+			if !tc.scaled {
+				if got != original.Path {
+					t.Errorf("webImage() = %q, want the original", got)
+				}
+				return
+			}
+
+			f, err := os.Open(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			config, _, err := image.DecodeConfig(f)
+			if err != nil {
+				t.Fatalf("DecodeConfig() err = %v", err)
+			}
+			if config.Width != webImageSize {
+				t.Errorf("web image is %d pixels wide, want %d", config.Width, webImageSize)
+			}
+		})
+	}
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG of the given size, enough for
+// image.DecodeConfig but without any pixels
+func pngHeader(width, height uint32) []byte {
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	// 8 bit RGBA, default compression, filter and interlacing
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
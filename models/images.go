@@ -2,6 +2,8 @@ package models
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
@@ -10,16 +12,60 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rahulbalajee/lenslocked/metrics"
 )
 
 type Image struct {
 	GalleryID int
 	Path      string
 	Filename  string
+	// Position is the place of the image when the gallery is sorted manually
+	Position int
+	// CapturedAt comes from the EXIF data and is nil when there is none
+	CapturedAt *time.Time
+	UploadedAt time.Time
+	Title      string
+	Caption    string
+	// AltText describes the image for screen readers
+	AltText string
+}
+
+// ImageDetails are the texts describing an image, set by the owner
+type ImageDetails struct {
+	Filename string
+	Title    string
+	Caption  string
+	AltText  string
+}
+
+// TrashedImage is an image that was deleted but can still be restored
+type TrashedImage struct {
+	Image
+	// TrashName is the name of the file in the trash, which stays unique when
+	// images with the same filename are deleted
+	TrashName string
+	DeletedAt time.Time
 }
 
+// Deleted images are moved into this directory inside their gallery directory
+// until they are restored or purged. Their row records when and under which
+// name.
+const trashDirName = ".trash"
+
+// ImageService stores images on disk. The images table keeps the order and
+// metadata of each file, uploads, deletes and restores update it along with
+// the files. Images copied into a gallery directory by hand show up once
+// SyncImages has run.
 type ImageService struct {
+	DB *sql.DB
+
 	// ImagesDir is used to tell the GalleryService where to store and locate
 	// images. If not set, the GalleryService will default to using the "images"
 	// directory.
@@ -34,52 +80,322 @@ type ImageService struct {
 	ContentTypes []string
 }
 
-func (is *ImageService) Image(galleryId int, filename string) (Image, error) {
-	imagePath := filepath.Join(is.imagesDir(galleryId), filename)
+func (is *ImageService) Image(ctx context.Context, galleryId int, filename string) (Image, error) {
+	return is.imageIn(is.imagesDir(galleryId), galleryId, filename)
+}
 
-	_, err := os.Stat(imagePath)
+// imageIn looks for filename in dir, only regular files are images
+func (is *ImageService) imageIn(dir string, galleryID int, filename string) (Image, error) {
+	imagePath := filepath.Join(dir, filename)
+
+	info, err := os.Stat(imagePath)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return Image{}, ErrNotFound
 		}
 		return Image{}, fmt.Errorf("query image: %w", err)
 	}
+	if !info.Mode().IsRegular() {
+		return Image{}, ErrNotFound
+	}
 
 	return Image{
 		Filename:  filename,
-		GalleryID: galleryId,
+		GalleryID: galleryID,
 		Path:      imagePath,
 	}, nil
 }
 
-func (is *ImageService) Images(galleryID int) ([]Image, error) {
-	globPattern := filepath.Join(is.imagesDir(galleryID), "*")
+// imageColumns are selected by the queries returning images of a gallery, in
+// the order scanImage expects them
+const imageColumns = `filename, position, captured_at, uploaded_at, title, caption, alt_text`
+
+func (is *ImageService) scanImage(row scanner, galleryID int) (Image, error) {
+	image := Image{GalleryID: galleryID}
+	err := row.Scan(&image.Filename, &image.Position, &image.CapturedAt, &image.UploadedAt,
+		&image.Title, &image.Caption, &image.AltText)
+	if err != nil {
+		return Image{}, err
+	}
+	image.Path = filepath.Join(is.imagesDir(galleryID), image.Filename)
+	return image, nil
+}
+
+// Images returns every image of a gallery in the gallery's sort order
+func (is *ImageService) Images(ctx context.Context, galleryID int) ([]Image, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
-	allFiles, err := filepath.Glob(globPattern)
+	mode, err := is.sortMode(ctx, galleryID)
 	if err != nil {
 		return nil, fmt.Errorf("retrieving gallery images: %w", err)
 	}
 
-	extensions := is.defaultExtensions()
-	if is.Extensions != nil {
-		extensions = is.Extensions
+	images, err := is.queryImages(ctx, galleryID, `
+		SELECT `+imageColumns+`
+		FROM images
+		WHERE gallery_id = $1 AND deleted_at IS NULL
+		ORDER BY `+strings.Join(imageSortKey(mode), ", ")+`;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving gallery images: %w", err)
+	}
+
+	return images, nil
+}
+
+// ImagePage is one page of a gallery's images
+type ImagePage struct {
+	Images []Image
+	// Total is the number of images in the whole gallery
+	Total int
+	Cursors
+}
+
+// imageCursor holds the sort key of an image for every sort mode so a cursor
+// keeps working when the sort mode changes in between two pages
+type imageCursor struct {
+	Position int       `json:"p"`
+	Time     time.Time `json:"t"`
+	Filename string    `json:"f"`
+}
+
+// ImagesPage returns a page of a gallery's images in the gallery's sort order.
+// Cursors hold the sort key of an image rather than an offset so pages don't
+// shift when images are added or deleted in between.
+func (is *ImageService) ImagesPage(ctx context.Context, galleryID int, page Page) (*ImagePage, error) {
+	limit := page.limit()
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	mode, err := is.sortMode(ctx, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving gallery images page: %w", err)
+	}
+	key := imageSortKey(mode)
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE gallery_id = $1 AND deleted_at IS NULL`
+	args := []any{galleryID}
+	var cursor imageCursor
+	switch {
+	case page.Before != "":
+		err = decodeCursor(page.Before, &cursor)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving gallery images page: %w", err)
+		}
+		// Walk backwards from the cursor, the rows are flipped back below
+		values := cursor.values(mode)
+		query += fmt.Sprintf(`
+		AND (%s) < (%s)
+		ORDER BY %s`, strings.Join(key, ", "), placeholders(len(args)+1, len(values)), descending(key))
+		args = append(args, values...)
+	case page.After != "":
+		err = decodeCursor(page.After, &cursor)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving gallery images page: %w", err)
+		}
+		values := cursor.values(mode)
+		query += fmt.Sprintf(`
+		AND (%s) > (%s)
+		ORDER BY %s`, strings.Join(key, ", "), placeholders(len(args)+1, len(values)), strings.Join(key, ", "))
+		args = append(args, values...)
+	default:
+		query += `
+		ORDER BY ` + strings.Join(key, ", ")
+	}
+	// One extra row tells us if there is another page
+	query += fmt.Sprintf(`
+		LIMIT %d;`, limit+1)
+
+	images, err := is.queryImages(ctx, galleryID, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving gallery images page: %w", err)
+	}
+
+	hasMore := len(images) > limit
+	if hasMore {
+		images = images[:limit]
+	}
+	if page.Before != "" {
+		slices.Reverse(images)
+	}
+
+	result := ImagePage{
+		Images: images,
+	}
+	err = is.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM images
+		WHERE gallery_id = $1 AND deleted_at IS NULL;`, galleryID).Scan(&result.Total)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving gallery images page: %w", err)
+	}
+
+	result.Cursors = pageCursors(page, len(images), hasMore, func(i int) string {
+		image := images[i]
+		return encodeCursor(imageCursor{
+			Position: image.Position,
+			Time:     imageSortTime(image, mode),
+			Filename: image.Filename,
+		})
+	})
+
+	return &result, nil
+}
+
+// ImageSummary is what a list of galleries shows of each gallery's images
+type ImageSummary struct {
+	Count int
+	// Cover is the image chosen as the gallery's cover, or the first image
+	// when none was chosen or it has been deleted since. It is nil when the
+	// gallery has no images.
+	Cover *Image
+}
+
+// ImageSummaries counts the images of several galleries and finds their
+// covers with a single query. Galleries that don't exist are left out.
+func (is *ImageService) ImageSummaries(ctx context.Context, galleryIDs []int) (map[int]ImageSummary, error) {
+	// Every gallery has its own sort mode, each mode's sort key only applies
+	// to the galleries using it
+	var order []string
+	for _, mode := range ImageSorts {
+		for _, column := range imageSortKey(mode) {
+			order = append(order, fmt.Sprintf("CASE WHEN galleries.sort_mode = '%s' THEN %s END", mode, column))
+		}
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := is.DB.QueryContext(ctx, `
+		SELECT galleries.id, counts.total, cover.filename,
+			COALESCE(cover.title, ''), COALESCE(cover.caption, ''), COALESCE(cover.alt_text, '')
+		FROM galleries
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS total
+			FROM images
+			WHERE gallery_id = galleries.id AND deleted_at IS NULL
+		) counts
+		LEFT JOIN LATERAL (
+			SELECT filename, title, caption, alt_text
+			FROM images
+			WHERE gallery_id = galleries.id AND deleted_at IS NULL
+			ORDER BY filename = galleries.cover_image DESC, `+strings.Join(order, ", ")+`, filename
+			LIMIT 1
+		) cover ON true
+		WHERE galleries.id = ANY($1);`, galleryIDs)
+	if err != nil {
+		return nil, fmt.Errorf("query image summaries: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := make(map[int]ImageSummary)
+	for rows.Next() {
+		var galleryID int
+		var summary ImageSummary
+		var filename *string
+		var cover Image
+		err = rows.Scan(&galleryID, &summary.Count, &filename, &cover.Title, &cover.Caption, &cover.AltText)
+		if err != nil {
+			return nil, fmt.Errorf("query image summaries: %w", err)
+		}
+		if filename != nil {
+			cover.GalleryID = galleryID
+			cover.Filename = *filename
+			cover.Path = filepath.Join(is.imagesDir(galleryID), cover.Filename)
+			summary.Cover = &cover
+		}
+		summaries[galleryID] = summary
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query image summaries: %w", err)
 	}
 
+	return summaries, nil
+}
+
+// queryImages runs a query selecting imageColumns
+func (is *ImageService) queryImages(ctx context.Context, galleryID int, query string, args ...any) ([]Image, error) {
+	rows, err := is.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
 	var images []Image
+	for rows.Next() {
+		image, err := is.scanImage(rows, galleryID)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+// values returns the cursor's sort key in mode, in the order of the
+// columns of imageSortKey
+func (c imageCursor) values(mode ImageSort) []any {
+	switch mode {
+	case SortManual:
+		return []any{c.Position, c.Filename}
+	case SortCaptured, SortUploaded:
+		return []any{c.Time, c.Filename}
+	}
+	return []any{c.Filename}
+}
+
+// imageSortTime is the date an image is sorted by in mode
+func imageSortTime(image Image, mode ImageSort) time.Time {
+	if mode == SortCaptured && image.CapturedAt != nil {
+		return *image.CapturedAt
+	}
+	return image.UploadedAt
+}
+
+// imageFiles returns the paths of the images stored directly in dir
+func (is *ImageService) imageFiles(dir string) ([]string, error) {
+	allFiles, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	extensions := is.extensions()
+
+	var files []string
 	for _, file := range allFiles {
 		if hasExtension(file, extensions) {
-			images = append(images, Image{
-				GalleryID: galleryID,
-				Path:      file,
-				Filename:  filepath.Base(file),
-			})
+			files = append(files, file)
 		}
 	}
 
-	return images, nil
+	return files, nil
 }
 
-func (is *ImageService) CreateImage(galleryID int, filename string, contents io.Reader) error {
+func (is *ImageService) CreateImage(ctx context.Context, galleryID int, filename string, contents io.Reader) (err error) {
+	start := time.Now()
+	defer func() {
+		var fileErr FileError
+		switch {
+		case err == nil:
+			metrics.ImageUploads.WithLabelValues("success").Inc()
+		case errors.As(err, &fileErr):
+			metrics.ImageUploads.WithLabelValues("rejected").Inc()
+		default:
+			metrics.ImageUploads.WithLabelValues("error").Inc()
+		}
+		metrics.ImageProcessingDuration.WithLabelValues("create").Observe(time.Since(start).Seconds())
+	}()
+
 	contentType := is.defaultImageContentsType()
 	if is.ContentTypes != nil {
 		contentType = is.ContentTypes
@@ -90,10 +406,7 @@ func (is *ImageService) CreateImage(galleryID int, filename string, contents io.
 		return fmt.Errorf("creating image %v: %w", filename, err)
 	}
 
-	extensions := is.defaultExtensions()
-	if is.Extensions != nil {
-		extensions = is.Extensions
-	}
+	extensions := is.extensions()
 	err = checkExtension(filename, extensions)
 	if err != nil {
 		return fmt.Errorf("creating image %v: %w", filename, err)
@@ -117,18 +430,32 @@ func (is *ImageService) CreateImage(galleryID int, filename string, contents io.
 		contents,
 	)
 
-	_, err = io.Copy(dst, completeFile)
+	written, err := io.Copy(dst, completeFile)
 	if err != nil {
+		// Don't leave half an image behind, it would show up in the gallery
+		dst.Close()
+		os.Remove(imagePath)
 		return fmt.Errorf("copying contents to image: %w", err)
 	}
+	metrics.ImageUploadBytes.Add(float64(written))
+
+	err = is.addImage(ctx, galleryID, imagePath)
+	if err != nil {
+		return fmt.Errorf("creating image %v: %w", filename, err)
+	}
 
 	return nil
 }
 
-func (is *ImageService) CreateImageViaURL(galleryID int, url string) error {
+func (is *ImageService) CreateImageViaURL(ctx context.Context, galleryID int, url string) error {
 	filename := path.Base(url)
 
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("downloading image: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("downloading image: %w", err)
 	}
@@ -138,16 +465,99 @@ func (is *ImageService) CreateImageViaURL(galleryID int, url string) error {
 		return fmt.Errorf("downloading image: invalid status code %d", resp.StatusCode)
 	}
 
-	return is.CreateImage(galleryID, filename, resp.Body)
+	return is.CreateImage(ctx, galleryID, filename, resp.Body)
 }
 
-func (is *ImageService) DeleteImage(galleryID int, filename string) error {
-	image, err := is.Image(galleryID, filename)
+// UpdateDetails saves the title, caption and alt text of several images of a
+// gallery at once
+func (is *ImageService) UpdateDetails(ctx context.Context, galleryID int, details []ImageDetails) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := is.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("update image details: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, d := range details {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE images
+			SET title = $3, caption = $4, alt_text = $5
+			WHERE gallery_id = $1 AND filename = $2 AND deleted_at IS NULL;`,
+			galleryID, d.Filename, d.Title, d.Caption, d.AltText)
+		if err != nil {
+			return fmt.Errorf("update image details: %w", err)
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("update image details: %w", err)
+		}
+		if updated == 0 {
+			return fmt.Errorf("update image details %v: %w", d.Filename, ErrNotFound)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("update image details: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteImage moves the image to the gallery's trash. The file is renamed
+// after its row so deleting an image with the same name again doesn't
+// overwrite the first one.
+func (is *ImageService) DeleteImage(ctx context.Context, galleryID int, filename string) error {
+	image, err := is.Image(ctx, galleryID, filename)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
 
-	err = os.Remove(image.Path)
+	trashDir := is.trashDir(galleryID)
+	err = os.MkdirAll(trashDir, 0755)
+	if err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := is.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+	defer tx.Rollback()
+
+	var trashName string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE images
+		SET deleted_at = NOW(), trash_name = id || '-' || filename
+		WHERE gallery_id = $1 AND filename = $2 AND deleted_at IS NULL
+		RETURNING trash_name;`, galleryID, image.Filename).Scan(&trashName)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("deleting image: %w", ErrNotFound)
+		}
+		return fmt.Errorf("deleting image: %w", err)
+	}
+
+	// Files can't take part in the transaction, move the file while the row
+	// is locked and move it back if the commit fails
+	trashPath := filepath.Join(trashDir, trashName)
+	err = moveFile(image.Path, trashPath)
+	if err != nil {
+		return fmt.Errorf("deleting image: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		os.Rename(trashPath, image.Path)
+		return fmt.Errorf("deleting image: %w", err)
+	}
+
+	err = is.removeWebImage(galleryID, image.Filename)
 	if err != nil {
 		return fmt.Errorf("deleting image: %w", err)
 	}
@@ -155,9 +565,203 @@ func (is *ImageService) DeleteImage(galleryID int, filename string) error {
 	return nil
 }
 
+// TrashedImages lists the images in a gallery's trash, most recently deleted first
+func (is *ImageService) TrashedImages(ctx context.Context, galleryID int) ([]TrashedImage, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := is.DB.QueryContext(ctx, `
+		SELECT filename, trash_name, position, captured_at, uploaded_at, title, caption, alt_text, deleted_at
+		FROM images
+		WHERE gallery_id = $1 AND deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC, id DESC;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving trashed images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []TrashedImage
+	for rows.Next() {
+		image := TrashedImage{Image: Image{GalleryID: galleryID}}
+		err = rows.Scan(&image.Filename, &image.TrashName, &image.Position, &image.CapturedAt, &image.UploadedAt,
+			&image.Title, &image.Caption, &image.AltText, &image.DeletedAt)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving trashed images: %w", err)
+		}
+		image.Path = filepath.Join(is.trashDir(galleryID), image.TrashName)
+		images = append(images, image)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("retrieving trashed images: %w", err)
+	}
+
+	return images, nil
+}
+
+// TrashedImage returns a single image from the gallery's trash by the name
+// of its file in the trash
+func (is *ImageService) TrashedImage(ctx context.Context, galleryID int, trashName string) (Image, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var filename string
+	err := is.DB.QueryRowContext(ctx, `
+		SELECT filename
+		FROM images
+		WHERE gallery_id = $1 AND trash_name = $2 AND deleted_at IS NOT NULL;`, galleryID, trashName).Scan(&filename)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Image{}, ErrNotFound
+		}
+		return Image{}, fmt.Errorf("query trashed image: %w", err)
+	}
+
+	image, err := is.imageIn(is.trashDir(galleryID), galleryID, trashName)
+	if err != nil {
+		return Image{}, err
+	}
+	image.Filename = filename
+
+	return image, nil
+}
+
+// RestoreImage moves an image out of the trash. It fails if an image with
+// the same name has been uploaded since.
+func (is *ImageService) RestoreImage(ctx context.Context, galleryID int, trashName string) error {
+	image, err := is.TrashedImage(ctx, galleryID, trashName)
+	if err != nil {
+		return fmt.Errorf("restoring image: %w", err)
+	}
+
+	exists := FileError{
+		Issue: fmt.Sprintf("an image named %v already exists", image.Filename),
+	}
+	restorePath := filepath.Join(is.imagesDir(galleryID), image.Filename)
+	_, err = os.Stat(restorePath)
+	if err == nil {
+		return fmt.Errorf("restoring image: %w", exists)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := is.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("restoring image: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE images
+		SET deleted_at = NULL, trash_name = NULL
+		WHERE gallery_id = $1 AND trash_name = $2;`, galleryID, trashName)
+	if err != nil {
+		var pgError *pgconn.PgError
+		if errors.As(err, &pgError) && pgError.Code == pgerrcode.UniqueViolation {
+			return fmt.Errorf("restoring image: %w", exists)
+		}
+		return fmt.Errorf("restoring image: %w", err)
+	}
+
+	err = moveFile(image.Path, restorePath)
+	if err != nil {
+		return fmt.Errorf("restoring image: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		os.Rename(restorePath, image.Path)
+		return fmt.Errorf("restoring image: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteTrashedImage permanently removes an image from the trash
+func (is *ImageService) DeleteTrashedImage(ctx context.Context, galleryID int, trashName string) error {
+	image, err := is.TrashedImage(ctx, galleryID, trashName)
+	if err != nil {
+		return fmt.Errorf("deleting trashed image: %w", err)
+	}
+
+	err = os.Remove(image.Path)
+	if err != nil {
+		return fmt.Errorf("deleting trashed image: %w", err)
+	}
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err = is.DB.ExecContext(ctx, `
+		DELETE FROM images
+		WHERE gallery_id = $1 AND trash_name = $2;`, galleryID, trashName)
+	if err != nil {
+		return fmt.Errorf("deleting trashed image: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeTrash permanently removes images that have been in the trash for
+// longer than retention and returns how many were removed
+func (is *ImageService) PurgeTrash(ctx context.Context, retention time.Duration) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := is.DB.QueryContext(ctx, `
+		SELECT gallery_id, trash_name
+		FROM images
+		WHERE deleted_at < $1;`, time.Now().Add(-retention))
+	if err != nil {
+		return 0, fmt.Errorf("purge trashed images: %w", err)
+	}
+	defer rows.Close()
+
+	var images []TrashedImage
+	for rows.Next() {
+		var image TrashedImage
+		err = rows.Scan(&image.GalleryID, &image.TrashName)
+		if err != nil {
+			return 0, fmt.Errorf("purge trashed images: %w", err)
+		}
+		images = append(images, image)
+	}
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("purge trashed images: %w", err)
+	}
+	rows.Close()
+
+	var purged int
+	for _, image := range images {
+		err = os.Remove(filepath.Join(is.trashDir(image.GalleryID), image.TrashName))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return purged, fmt.Errorf("purge trashed images: %w", err)
+		}
+		_, err = is.DB.ExecContext(ctx, `
+			DELETE FROM images
+			WHERE gallery_id = $1 AND trash_name = $2;`, image.GalleryID, image.TrashName)
+		if err != nil {
+			return purged, fmt.Errorf("purge trashed images: %w", err)
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// moveFile renames src to dst, refusing to overwrite a file already at dst
+func moveFile(src, dst string) error {
+	_, err := os.Lstat(dst)
+	if err == nil {
+		return fmt.Errorf("move %v: %w", filepath.Base(src), fs.ErrExist)
+	}
+	return os.Rename(src, dst)
+}
+
 // DeleteAllGalleryImages deletes all images for a given gallery by removing the gallery directory.
 // It returns nil if the directory doesn't exist (idempotent).
-func (is *ImageService) DeleteAllGalleryImages(galleryID int) error {
+func (is *ImageService) DeleteAllGalleryImages(ctx context.Context, galleryID int) error {
 	imagesDir := is.imagesDir(galleryID)
 	err := os.RemoveAll(imagesDir)
 	if err != nil {
@@ -166,6 +770,50 @@ func (is *ImageService) DeleteAllGalleryImages(galleryID int) error {
 	return nil
 }
 
+// CheckStorage makes sure the images directory exists and is writable
+func (is *ImageService) CheckStorage() error {
+	dir := is.imagesDir(0)
+	dir = filepath.Dir(dir)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("check storage: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("check storage: %w", err)
+	}
+	f.Close()
+
+	err = os.Remove(f.Name())
+	if err != nil {
+		return fmt.Errorf("check storage: %w", err)
+	}
+
+	return nil
+}
+
+// galleryDirs returns the gallery IDs that have an images directory on disk
+func (is *ImageService) galleryDirs() ([]int, error) {
+	dirs, err := filepath.Glob(filepath.Join(filepath.Dir(is.imagesDir(0)), "gallery-*"))
+	if err != nil {
+		return nil, fmt.Errorf("listing gallery directories: %w", err)
+	}
+
+	var ids []int
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "gallery-"))
+		if err != nil {
+			// Not one of ours, leave it alone
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
 func (is *ImageService) defaultExtensions() []string {
 	return []string{".png", ".jpg", ".jpeg", ".gif"}
 }
@@ -174,6 +822,17 @@ func (is *ImageService) defaultImageContentsType() []string {
 	return []string{"image/png", "image/jpg", "image/jpeg", "image/gif"}
 }
 
+func (is *ImageService) extensions() []string {
+	if is.Extensions != nil {
+		return is.Extensions
+	}
+	return is.defaultExtensions()
+}
+
+func (is *ImageService) trashDir(galleryID int) string {
+	return filepath.Join(is.imagesDir(galleryID), trashDirName)
+}
+
 func (is *ImageService) imagesDir(id int) string {
 	imagesDir := is.Dir
 	if imagesDir == "" {
@@ -182,6 +841,13 @@ func (is *ImageService) imagesDir(id int) string {
 	return filepath.Join(imagesDir, fmt.Sprintf("gallery-%d", id))
 }
 
+// validFilename tells if filename names a file right inside a gallery
+// directory, rather than a path elsewhere or one of the hidden
+// directories like the trash
+func validFilename(filename string) bool {
+	return filename != "" && filepath.Base(filename) == filename && !strings.HasPrefix(filename, ".")
+}
+
 func hasExtension(file string, extensions []string) bool {
 	fileExt := strings.ToLower(filepath.Ext(file))
 	for _, ext := range extensions {
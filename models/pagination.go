@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	DefaultPageSize = 24
+	MaxPageSize     = 100
+)
+
+// ErrInvalidCursor is returned when a page cursor can't be decoded, usually
+// because someone edited the URL
+var ErrInvalidCursor = errors.New("models: invalid page cursor")
+
+// Page selects a page of results with keyset pagination. After and Before
+// are cursors taken from a previous page, at most one of them should be set.
+type Page struct {
+	After  string
+	Before string
+	Limit  int
+}
+
+func (p Page) limit() int {
+	if p.Limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(p.Limit, MaxPageSize)
+}
+
+// Cursors point at the neighbouring pages, they are empty when there is
+// nothing to fetch in that direction
+type Cursors struct {
+	Next string
+	Prev string
+}
+
+// encodeCursor turns the sort key of a row into an opaque string that is safe
+// to put in a URL
+func encodeCursor(key any) string {
+	// Keys are plain structs of strings, ints and times which always marshal
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string, key any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	err = json.Unmarshal(data, key)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// pageCursors works out the cursors of a page of n rows. hasMore tells if
+// rows were left out in the direction we were paging, cursorAt returns the
+// cursor of the i-th row.
+func pageCursors(page Page, n int, hasMore bool, cursorAt func(i int) string) Cursors {
+	var c Cursors
+	if n == 0 {
+		return c
+	}
+
+	if page.Before != "" {
+		c.Next = cursorAt(n - 1)
+		if hasMore {
+			c.Prev = cursorAt(0)
+		}
+		return c
+	}
+
+	if hasMore {
+		c.Next = cursorAt(n - 1)
+	}
+	if page.After != "" {
+		c.Prev = cursorAt(0)
+	}
+	return c
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
@@ -28,12 +29,15 @@ type PasswordResetService struct {
 	TokenManager TokenManager
 }
 
-func (pr *PasswordResetService) Create(email string) (*PasswordReset, error) {
+func (pr *PasswordResetService) Create(ctx context.Context, email string) (*PasswordReset, error) {
 	email = strings.ToLower(email)
 
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	var userID int
 
-	row := pr.DB.QueryRow(`
+	row := pr.DB.QueryRowContext(ctx, `
 		SELECT id FROM users WHERE email = $1`, email)
 
 	err := row.Scan(&userID)
@@ -60,7 +64,7 @@ func (pr *PasswordResetService) Create(email string) (*PasswordReset, error) {
 	}
 
 	// Insert pwReset into DB
-	row = pr.DB.QueryRow(`
+	row = pr.DB.QueryRowContext(ctx, `
 		INSERT INTO password_resets (user_id, token_hash, expires_at)
 		VALUES ($1, $2, $3) ON CONFLICT (user_id) DO
 		UPDATE
@@ -75,13 +79,16 @@ func (pr *PasswordResetService) Create(email string) (*PasswordReset, error) {
 	return &pwReset, nil
 }
 
-func (pr *PasswordResetService) Consume(token string) (*User, error) {
+func (pr *PasswordResetService) Consume(ctx context.Context, token string) (*User, error) {
 	tokenHash := pr.TokenManager.Hash(token)
 
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	var user User
 	var pwReset PasswordReset
 
-	row := pr.DB.QueryRow(`
+	row := pr.DB.QueryRowContext(ctx, `
 		SELECT password_resets.id,
 			password_resets.expires_at,
 			users.id,
@@ -106,7 +113,7 @@ func (pr *PasswordResetService) Consume(token string) (*User, error) {
 		return nil, fmt.Errorf("token expired: %v", token)
 	}
 
-	err = pr.delete(pwReset.ID)
+	err = pr.delete(ctx, pwReset.ID)
 	if err != nil {
 		return nil, fmt.Errorf("consume password reset: %w", err)
 	}
@@ -114,8 +121,11 @@ func (pr *PasswordResetService) Consume(token string) (*User, error) {
 	return &user, nil
 }
 
-func (pr *PasswordResetService) delete(id int) error {
-	_, err := pr.DB.Exec(`
+func (pr *PasswordResetService) delete(ctx context.Context, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := pr.DB.ExecContext(ctx, `
 		DELETE FROM password_resets
 		WHERE id = $1`, id)
 
@@ -0,0 +1,72 @@
+package models
+
+import "slices"
+
+// Role is what a user is to a gallery. The user who created the gallery is
+// always an owner, other users get a role by accepting an invitation.
+type Role string
+
+const (
+	// RoleViewer can see the gallery and download its images
+	RoleViewer Role = "viewer"
+	// RoleContributor can also upload images, eg. a second shooter
+	RoleContributor Role = "contributor"
+	// RoleEditor can also edit the gallery and its images and delete them
+	RoleEditor Role = "editor"
+	// RoleOwner can also manage collaborators and how the gallery is shared
+	RoleOwner Role = "owner"
+)
+
+// Roles lists every role from the least to the most privileged, the order
+// they are offered to users in
+var Roles = []Role{RoleViewer, RoleContributor, RoleEditor, RoleOwner}
+
+func (r Role) Valid() bool {
+	return slices.Contains(Roles, r)
+}
+
+// Label is the human readable name of the role
+func (r Role) Label() string {
+	switch r {
+	case RoleViewer:
+		return "Viewer"
+	case RoleContributor:
+		return "Contributor"
+	case RoleEditor:
+		return "Editor"
+	case RoleOwner:
+		return "Owner"
+	}
+	return string(r)
+}
+
+// Can tells if the role grants the permission, every role has the
+// permissions of the roles before it in Roles
+func (r Role) Can(p Permission) bool {
+	rank := slices.Index(Roles, r)
+	return rank >= 0 && rank >= slices.Index(Roles, permissionRoles[p])
+}
+
+// Permission is something a user can do with a gallery
+type Permission int
+
+const (
+	// PermissionView allows seeing the gallery and downloading its images
+	PermissionView Permission = iota
+	// PermissionUpload allows adding images
+	PermissionUpload
+	// PermissionEdit allows changing the gallery and its images, deleting
+	// them and restoring them from the trash
+	PermissionEdit
+	// PermissionManage allows managing collaborators, share links, the
+	// password and visibility, and deleting the gallery for good
+	PermissionManage
+)
+
+// permissionRoles maps every permission to the least privileged role having it
+var permissionRoles = map[Permission]Role{
+	PermissionView:   RoleViewer,
+	PermissionUpload: RoleContributor,
+	PermissionEdit:   RoleEditor,
+	PermissionManage: RoleOwner,
+}
@@ -1,14 +1,20 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io/fs"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
+// DefaultQueryTimeout bounds every query we run, on top of any deadline the
+// caller's context (usually the HTTP request) already has
+const DefaultQueryTimeout = 5 * time.Second
+
 // fields required to connect to Postgres DB
 type PostgresConfig struct {
 	Host     string
@@ -47,6 +53,12 @@ func Open(config PostgresConfig) (*sql.DB, error) {
 	return db, nil
 }
 
+// queryContext derives the context a single query runs with. The query is
+// cancelled when the request goes away or after DefaultQueryTimeout.
+func queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, DefaultQueryTimeout)
+}
+
 // Migrate() will use Goose to setup SQL migrations automatic when server starts up
 func Migrate(db *sql.DB, dir string) error {
 	err := goose.SetDialect("postgres")
@@ -76,3 +88,37 @@ func MigrateFS(db *sql.DB, migrationsFS fs.FS, dir string) error {
 	// Run the actual migrations and return error in case there's a problem
 	return Migrate(db, dir)
 }
+
+// CheckMigrations returns an error unless every migration in migrationsFS has
+// been applied to the database
+func CheckMigrations(ctx context.Context, db *sql.DB, migrationsFS fs.FS) error {
+	files, err := fs.Glob(migrationsFS, "*.sql")
+	if err != nil {
+		return fmt.Errorf("check migrations: %w", err)
+	}
+
+	var latest int64
+	for _, file := range files {
+		version, err := goose.NumericComponent(file)
+		if err != nil {
+			return fmt.Errorf("check migrations: %w", err)
+		}
+		latest = max(latest, version)
+	}
+
+	var current int64
+	row := db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(version_id), 0)
+		FROM goose_db_version
+		WHERE is_applied;`)
+	err = row.Scan(&current)
+	if err != nil {
+		return fmt.Errorf("check migrations: %w", err)
+	}
+
+	if current < latest {
+		return fmt.Errorf("check migrations: database is at version %d, expected %d", current, latest)
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Selection holds the images a client picked while proofing a gallery. It
+// is tied to the browser that started it through its token, submitting it
+// makes it final.
+type Selection struct {
+	ID        int
+	GalleryID int
+	// ShareLinkID is nil for selections made on a public gallery
+	ShareLinkID *int
+	// ShareLinkLabel is the label of the share link, if any
+	ShareLinkLabel string
+	ClientName     string
+	// Token is only set when the selection is created
+	Token       string
+	TokenHash   string
+	SubmittedAt *time.Time
+	CreatedAt   time.Time
+	Items       []SelectionItem
+	// OwnerEmail is the address of the owner of the gallery, it is only set
+	// by Submit
+	OwnerEmail string
+}
+
+// SelectionItem is an image the client hearted with their optional note
+type SelectionItem struct {
+	Filename  string
+	Note      string
+	CreatedAt time.Time
+}
+
+func (s Selection) Submitted() bool {
+	return s.SubmittedAt != nil
+}
+
+// Item returns the item for filename, nil when the image isn't selected
+func (s Selection) Item(filename string) *SelectionItem {
+	for i := range s.Items {
+		if s.Items[i].Filename == filename {
+			return &s.Items[i]
+		}
+	}
+	return nil
+}
+
+type SelectionService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+}
+
+const selectionColumns = `selections.id, selections.gallery_id, selections.share_link_id,
+	COALESCE(share_links.label, ''), selections.client_name, selections.token_hash,
+	selections.submitted_at, selections.created_at`
+
+func scanSelection(row scanner) (Selection, error) {
+	var selection Selection
+	err := row.Scan(
+		&selection.ID,
+		&selection.GalleryID,
+		&selection.ShareLinkID,
+		&selection.ShareLinkLabel,
+		&selection.ClientName,
+		&selection.TokenHash,
+		&selection.SubmittedAt,
+		&selection.CreatedAt,
+	)
+	return selection, err
+}
+
+// Create starts an empty selection, the token identifies the client from
+// then on
+func (ss *SelectionService) Create(ctx context.Context, selection *Selection) error {
+	token, tokenHash, err := ss.TokenManager.New()
+	if err != nil {
+		return fmt.Errorf("create selection: %w", err)
+	}
+	selection.Token = token
+	selection.TokenHash = tokenHash
+	selection.ClientName = strings.TrimSpace(selection.ClientName)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := ss.DB.QueryRowContext(ctx, `
+		INSERT INTO selections (gallery_id, share_link_id, client_name, token_hash)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at;`,
+		selection.GalleryID, selection.ShareLinkID, selection.ClientName, selection.TokenHash)
+
+	err = row.Scan(&selection.ID, &selection.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create selection: %w", err)
+	}
+
+	return nil
+}
+
+// ByToken returns the selection of the client holding token, it must belong
+// to the gallery
+func (ss *SelectionService) ByToken(ctx context.Context, galleryID int, token string) (*Selection, error) {
+	tokenHash := ss.TokenManager.Hash(token)
+	return ss.one(ctx, "query selection by token", `
+		WHERE selections.gallery_id = $1 AND selections.token_hash = $2`, galleryID, tokenHash)
+}
+
+func (ss *SelectionService) ByID(ctx context.Context, galleryID, id int) (*Selection, error) {
+	return ss.one(ctx, "query selection by id", `
+		WHERE selections.gallery_id = $1 AND selections.id = $2`, galleryID, id)
+}
+
+// ByGalleryID lists the selections made on a gallery with their items,
+// submitted ones first and then newest first
+func (ss *SelectionService) ByGalleryID(ctx context.Context, galleryID int) ([]Selection, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := ss.DB.QueryContext(ctx, `
+		SELECT `+selectionColumns+`
+		FROM selections
+			LEFT JOIN share_links ON share_links.id = selections.share_link_id
+		WHERE selections.gallery_id = $1
+		ORDER BY selections.submitted_at DESC NULLS LAST, selections.created_at DESC;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("query selections: %w", err)
+	}
+	defer rows.Close()
+
+	var selections []Selection
+	for rows.Next() {
+		selection, err := scanSelection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("query selections: %w", err)
+		}
+		selections = append(selections, selection)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query selections: %w", err)
+	}
+
+	err = ss.loadItems(ctx, selections)
+	if err != nil {
+		return nil, fmt.Errorf("query selections: %w", err)
+	}
+
+	return selections, nil
+}
+
+// Select adds the image to the selection or updates its note when it is
+// already in there, ErrSelectionSubmitted once the selection is final
+func (ss *SelectionService) Select(ctx context.Context, selectionID int, filename, note string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := ss.DB.ExecContext(ctx, `
+		INSERT INTO selection_items (selection_id, filename, note)
+		SELECT id, $2, $3 FROM selections
+		WHERE id = $1 AND submitted_at IS NULL
+		ON CONFLICT (selection_id, filename) DO
+		UPDATE
+		SET note = EXCLUDED.note;`, selectionID, filename, strings.TrimSpace(note))
+	if err != nil {
+		return fmt.Errorf("select image: %w", err)
+	}
+
+	err = expectRows(result, "select image")
+	if errors.Is(err, ErrNotFound) {
+		return ErrSelectionSubmitted
+	}
+	return err
+}
+
+// Unselect removes the image from the selection, ErrSelectionSubmitted once
+// the selection is final. Images that aren't selected are ignored.
+func (ss *SelectionService) Unselect(ctx context.Context, selectionID int, filename string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var submitted bool
+	row := ss.DB.QueryRowContext(ctx, `
+		WITH deleted AS (
+			DELETE FROM selection_items
+			USING selections
+			WHERE selection_items.selection_id = selections.id
+				AND selections.id = $1 AND selections.submitted_at IS NULL
+				AND selection_items.filename = $2
+		)
+		SELECT submitted_at IS NOT NULL FROM selections WHERE id = $1;`, selectionID, filename)
+	err := row.Scan(&submitted)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("unselect image: %w", err)
+	}
+	if submitted {
+		return ErrSelectionSubmitted
+	}
+
+	return nil
+}
+
+// Submit makes the selection final. notify queues the email telling the
+// owner of the gallery about it along with it.
+func (ss *SelectionService) Submit(ctx context.Context, selection *Selection, notify Notify[Selection]) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := ss.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+	defer tx.Rollback()
+
+	var submittedAt time.Time
+	row := tx.QueryRowContext(ctx, `
+		UPDATE selections
+		SET submitted_at = NOW()
+		WHERE id = $1 AND submitted_at IS NULL
+		RETURNING submitted_at, (
+			SELECT users.email FROM galleries
+				JOIN users ON users.id = galleries.user_id
+			WHERE galleries.id = selections.gallery_id
+		);`, selection.ID)
+	err = row.Scan(&submittedAt, &selection.OwnerEmail)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSelectionSubmitted
+		}
+		return fmt.Errorf("submit selection: %w", err)
+	}
+	selection.SubmittedAt = &submittedAt
+
+	err = notify(tx, selection)
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("submit selection: %w", err)
+	}
+
+	return nil
+}
+
+// one returns the single selection matching where, with its items
+func (ss *SelectionService) one(ctx context.Context, op, where string, args ...any) (*Selection, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := ss.DB.QueryRowContext(ctx, `
+		SELECT `+selectionColumns+`
+		FROM selections
+			LEFT JOIN share_links ON share_links.id = selections.share_link_id
+		`+where+`;`, args...)
+	selection, err := scanSelection(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	selections := []Selection{selection}
+	err = ss.loadItems(ctx, selections)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &selections[0], nil
+}
+
+// loadItems fills in the items of selections in a single query, in the order
+// they were selected
+func (ss *SelectionService) loadItems(ctx context.Context, selections []Selection) error {
+	if len(selections) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(selections))
+	index := make(map[int]int, len(selections))
+	for i, selection := range selections {
+		ids[i] = selection.ID
+		index[selection.ID] = i
+	}
+
+	rows, err := ss.DB.QueryContext(ctx, `
+		SELECT selection_id, filename, note, created_at
+		FROM selection_items
+		WHERE selection_id = ANY($1)
+		ORDER BY created_at, filename;`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var selectionID int
+		var item SelectionItem
+		err = rows.Scan(&selectionID, &item.Filename, &item.Note, &item.CreatedAt)
+		if err != nil {
+			return err
+		}
+		i := index[selectionID]
+		selections[i].Items = append(selections[i].Items, item)
+	}
+
+	return rows.Err()
+}
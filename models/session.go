@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 )
@@ -18,7 +19,7 @@ type SessionService struct {
 	TokenManager TokenManager
 }
 
-func (ss *SessionService) Create(userID int) (*Session, error) {
+func (ss *SessionService) Create(ctx context.Context, userID int) (*Session, error) {
 	// Create a token and hash it by using Token Manager service
 	token, tokenHash, err := ss.TokenManager.New()
 	if err != nil {
@@ -31,7 +32,10 @@ func (ss *SessionService) Create(userID int) (*Session, error) {
 		TokenHash: tokenHash,
 	}
 
-	row := ss.DB.QueryRow(`
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := ss.DB.QueryRowContext(ctx, `
 		INSERT INTO sessions (user_id, token_hash)
 		VALUES ($1, $2) ON CONFLICT (user_id) DO
 		UPDATE
@@ -46,21 +50,25 @@ func (ss *SessionService) Create(userID int) (*Session, error) {
 	return &session, nil
 }
 
-func (ss *SessionService) User(token string) (*User, error) {
+func (ss *SessionService) User(ctx context.Context, token string) (*User, error) {
 	tokenHash := ss.TokenManager.Hash(token)
 
 	var user User
 
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	// Get a user from a token_hash using inner JOIN combining sessions and users table
-	row := ss.DB.QueryRow(`
+	row := ss.DB.QueryRowContext(ctx, `
 		SELECT users.id,
 			users.email,
-			users.password_hash
+			users.password_hash,
+			users.admin
 		FROM users
 			JOIN sessions ON users.id = sessions.user_id
 		WHERE sessions.token_hash = $1;`, tokenHash)
 
-	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash)
+	err := row.Scan(&user.ID, &user.Email, &user.PasswordHash, &user.Admin)
 	if err != nil {
 		return nil, fmt.Errorf("get user by token: %w", err)
 	}
@@ -68,10 +76,13 @@ func (ss *SessionService) User(token string) (*User, error) {
 	return &user, nil
 }
 
-func (ss *SessionService) Delete(token string) error {
+func (ss *SessionService) Delete(ctx context.Context, token string) error {
 	tokenHash := ss.TokenManager.Hash(token)
 
-	_, err := ss.DB.Exec(`
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := ss.DB.ExecContext(ctx, `
 		DELETE FROM sessions
 		WHERE token_hash = $1;`, tokenHash)
 
@@ -81,3 +92,21 @@ func (ss *SessionService) Delete(token string) error {
 
 	return nil
 }
+
+// Count returns the number of sessions currently stored
+func (ss *SessionService) Count(ctx context.Context) (int, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var count int
+
+	row := ss.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM sessions;`)
+
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count sessions: %w", err)
+	}
+
+	return count, nil
+}
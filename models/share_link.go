@@ -0,0 +1,202 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShareLink gives people without an account access to a gallery, whatever
+// its visibility
+type ShareLink struct {
+	ID        int
+	GalleryID int
+	// Token is only set when the link is created, only its hash is stored
+	Token     string
+	TokenHash string
+	// Label reminds the owner who the link was sent to
+	Label string
+	// ExpiresAt is nil for links that never expire
+	ExpiresAt *time.Time
+	// MaxViews is how many times the link can be opened, 0 means no limit
+	MaxViews int
+	Views    int
+	// AllowDownload lets visitors download the original images
+	AllowDownload bool
+	CreatedAt     time.Time
+}
+
+// Expired tells if the link is past its expiry date, viewers who opened the
+// link lose access when it expires
+func (sl ShareLink) Expired() bool {
+	return sl.ExpiresAt != nil && !time.Now().Before(*sl.ExpiresAt)
+}
+
+// UsedUp tells if the link was opened as many times as it allows, viewers
+// who already opened it keep their access
+func (sl ShareLink) UsedUp() bool {
+	return sl.MaxViews > 0 && sl.Views >= sl.MaxViews
+}
+
+type ShareLinkService struct {
+	DB           *sql.DB
+	TokenManager TokenManager
+}
+
+const shareLinkColumns = `id, gallery_id, token_hash, label, expires_at, max_views,
+	views, allow_download, created_at`
+
+func scanShareLink(row scanner) (ShareLink, error) {
+	var link ShareLink
+	err := row.Scan(
+		&link.ID,
+		&link.GalleryID,
+		&link.TokenHash,
+		&link.Label,
+		&link.ExpiresAt,
+		&link.MaxViews,
+		&link.Views,
+		&link.AllowDownload,
+		&link.CreatedAt,
+	)
+	return link, err
+}
+
+// Create generates the token of a new link, the ID and CreatedAt are set
+// from the database
+func (sls *ShareLinkService) Create(ctx context.Context, link *ShareLink) error {
+	token, tokenHash, err := sls.TokenManager.New()
+	if err != nil {
+		return fmt.Errorf("create share link: %w", err)
+	}
+	link.Token = token
+	link.TokenHash = tokenHash
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := sls.DB.QueryRowContext(ctx, `
+		INSERT INTO share_links (gallery_id, token_hash, label, expires_at, max_views, allow_download)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at;`,
+		link.GalleryID, link.TokenHash, link.Label, link.ExpiresAt, link.MaxViews, link.AllowDownload)
+
+	err = row.Scan(&link.ID, &link.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("create share link: %w", err)
+	}
+
+	return nil
+}
+
+// ByGalleryID lists the links of a gallery, newest first
+func (sls *ShareLinkService) ByGalleryID(ctx context.Context, galleryID int) ([]ShareLink, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := sls.DB.QueryContext(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM share_links
+		WHERE gallery_id = $1
+		ORDER BY created_at DESC, id DESC;`, galleryID)
+	if err != nil {
+		return nil, fmt.Errorf("query share links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("query share links: %w", err)
+		}
+		links = append(links, link)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query share links: %w", err)
+	}
+
+	return links, nil
+}
+
+// Visit counts a visit of the link. It returns ErrLinkExpired when the link
+// is past its expiry date or out of views and ErrNotFound when it was revoked.
+func (sls *ShareLinkService) Visit(ctx context.Context, token string) (*ShareLink, error) {
+	tokenHash := sls.TokenManager.Hash(token)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	// Checking and counting in one statement so concurrent visits can't go
+	// over MaxViews
+	row := sls.DB.QueryRowContext(ctx, `
+		UPDATE share_links
+		SET views = views + 1
+		WHERE token_hash = $1
+			AND (expires_at IS NULL OR expires_at > NOW())
+			AND (max_views = 0 OR views < max_views)
+		RETURNING `+shareLinkColumns+`;`, tokenHash)
+
+	link, err := scanShareLink(row)
+	if err == nil {
+		return &link, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("visit share link: %w", err)
+	}
+
+	_, err = sls.ByToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("visit share link: %w", err)
+	}
+	return nil, fmt.Errorf("visit share link: %w", ErrLinkExpired)
+}
+
+// ByToken returns the link with the given token, expired links are returned
+// too so callers should check Expired
+func (sls *ShareLinkService) ByToken(ctx context.Context, token string) (*ShareLink, error) {
+	tokenHash := sls.TokenManager.Hash(token)
+
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := sls.DB.QueryRowContext(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM share_links
+		WHERE token_hash = $1;`, tokenHash)
+
+	link, err := scanShareLink(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("query share link by token: %w", err)
+	}
+
+	return &link, nil
+}
+
+// Revoke deletes a link, viewers who opened it lose access right away
+func (sls *ShareLinkService) Revoke(ctx context.Context, galleryID, id int) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := sls.DB.ExecContext(ctx, `
+		DELETE FROM share_links
+		WHERE id = $1 AND gallery_id = $2;`, id, galleryID)
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("revoke share link: %w", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -15,13 +16,15 @@ type User struct {
 	ID           int
 	Email        string
 	PasswordHash string
+	// Admin users can force-transfer galleries between accounts
+	Admin bool
 }
 
 type UserService struct {
 	DB *sql.DB
 }
 
-func (us *UserService) Create(email, password string) (*User, error) {
+func (us *UserService) Create(ctx context.Context, email, password string) (*User, error) {
 	email = strings.ToLower(email)
 
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -35,7 +38,16 @@ func (us *UserService) Create(email, password string) (*User, error) {
 		PasswordHash: passwordHash,
 	}
 
-	row := us.DB.QueryRow(`
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := us.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `
 		INSERT INTO users (email, password_hash)
 		VALUES ($1, $2) RETURNING id;`, email, passwordHash)
 
@@ -50,16 +62,30 @@ func (us *UserService) Create(email, password string) (*User, error) {
 		return nil, fmt.Errorf("create user: %w", err)
 	}
 
+	// Every user starts with a workspace of their own to put galleries in
+	_, err = createWorkspace(ctx, tx, PersonalWorkspaceName, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("create user: %w", err)
+	}
+
 	return &user, nil
 }
 
-func (us *UserService) Authenticate(email, password string) (*User, error) {
+func (us *UserService) Authenticate(ctx context.Context, email, password string) (*User, error) {
 	email = strings.ToLower(email)
 	user := User{
 		Email: email,
 	}
 
-	row := us.DB.QueryRow(`
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	row := us.DB.QueryRowContext(ctx, `
 		SELECT id, password_hash FROM users where email=$1`, email)
 
 	err := row.Scan(&user.ID, &user.PasswordHash)
@@ -75,14 +101,17 @@ func (us *UserService) Authenticate(email, password string) (*User, error) {
 	return &user, nil
 }
 
-func (us *UserService) UpdatePassword(userID int, password string) error {
+func (us *UserService) UpdatePassword(ctx context.Context, userID int, password string) error {
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return fmt.Errorf("update password: %w", err)
 	}
 	passwordHash := string(hashedBytes)
 
-	_, err = us.DB.Exec(`
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err = us.DB.ExecContext(ctx, `
 		UPDATE users
 		SET password_hash = $2
 		WHERE id = $1`, userID, passwordHash)
@@ -94,10 +123,13 @@ func (us *UserService) UpdatePassword(userID int, password string) error {
 	return nil
 }
 
-func (us *UserService) UpdateEmail(userID int, email string) error {
+func (us *UserService) UpdateEmail(ctx context.Context, userID int, email string) error {
 	email = strings.ToLower(email)
 
-	_, err := us.DB.Exec(`
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := us.DB.ExecContext(ctx, `
 		UPDATE users
 		SET email = $2
 		WHERE id = $1`, userID, email)
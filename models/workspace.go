@@ -0,0 +1,267 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PersonalWorkspaceName is the name of the workspace every user starts with
+const PersonalWorkspaceName = "Personal"
+
+// Workspace owns galleries on behalf of a team, its members get their role
+// on every gallery in it
+type Workspace struct {
+	ID        int
+	Name      string
+	CreatedAt time.Time
+	// Role is the role of the user the workspace was listed for, see ByUserID
+	Role Role
+}
+
+type WorkspaceMember struct {
+	WorkspaceID int
+	UserID      int
+	Email       string
+	Role        Role
+	CreatedAt   time.Time
+}
+
+type WorkspaceService struct {
+	DB *sql.DB
+}
+
+// Create creates a workspace with userID as its owner
+func (ws *WorkspaceService) Create(ctx context.Context, name string, userID int) (*Workspace, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := ws.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create workspace: %w", err)
+	}
+	defer tx.Rollback()
+
+	workspace, err := createWorkspace(ctx, tx, name, userID)
+	if err != nil {
+		return nil, fmt.Errorf("create workspace: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("create workspace: %w", err)
+	}
+
+	return workspace, nil
+}
+
+// createWorkspace inserts a workspace and its owner in tx, signing up uses
+// it to create the personal workspace along with the user
+func createWorkspace(ctx context.Context, tx *sql.Tx, name string, userID int) (*Workspace, error) {
+	workspace := Workspace{
+		Name: strings.TrimSpace(name),
+		Role: RoleOwner,
+	}
+
+	row := tx.QueryRowContext(ctx, `
+		INSERT INTO workspaces (name, created_by)
+		VALUES ($1, $2) RETURNING id, created_at;`, workspace.Name, userID)
+	err := row.Scan(&workspace.ID, &workspace.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		VALUES ($1, $2, $3);`, workspace.ID, userID, RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// ByUserID lists the workspaces a user is a member of with their role in
+// each, oldest first so the personal workspace comes first
+func (ws *WorkspaceService) ByUserID(ctx context.Context, userID int) ([]Workspace, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := ws.DB.QueryContext(ctx, `
+		SELECT workspaces.id, workspaces.name, workspaces.created_at, workspace_members.role
+		FROM workspaces
+			JOIN workspace_members ON workspace_members.workspace_id = workspaces.id
+		WHERE workspace_members.user_id = $1
+		ORDER BY workspaces.created_at, workspaces.id;`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query workspaces by user id: %w", err)
+	}
+	defer rows.Close()
+
+	var workspaces []Workspace
+	for rows.Next() {
+		var w Workspace
+		err = rows.Scan(&w.ID, &w.Name, &w.CreatedAt, &w.Role)
+		if err != nil {
+			return nil, fmt.Errorf("query workspaces by user id: %w", err)
+		}
+		workspaces = append(workspaces, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query workspaces by user id: %w", err)
+	}
+
+	return workspaces, nil
+}
+
+// Rename changes the name of a workspace
+func (ws *WorkspaceService) Rename(ctx context.Context, id int, name string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := ws.DB.ExecContext(ctx, `
+		UPDATE workspaces
+		SET name = $2
+		WHERE id = $1;`, id, strings.TrimSpace(name))
+	if err != nil {
+		return fmt.Errorf("rename workspace: %w", err)
+	}
+
+	return expectRows(result, "rename workspace")
+}
+
+// Members lists the members of a workspace
+func (ws *WorkspaceService) Members(ctx context.Context, workspaceID int) ([]WorkspaceMember, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	rows, err := ws.DB.QueryContext(ctx, `
+		SELECT workspace_members.workspace_id,
+			workspace_members.user_id,
+			users.email,
+			workspace_members.role,
+			workspace_members.created_at
+		FROM workspace_members
+			JOIN users ON users.id = workspace_members.user_id
+		WHERE workspace_members.workspace_id = $1
+		ORDER BY users.email;`, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("query workspace members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []WorkspaceMember
+	for rows.Next() {
+		var m WorkspaceMember
+		err = rows.Scan(&m.WorkspaceID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("query workspace members: %w", err)
+		}
+		members = append(members, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("query workspace members: %w", err)
+	}
+
+	return members, nil
+}
+
+// AddMember adds the user with email to the workspace, ErrNotFound when
+// nobody has signed up with it. Users who are already members keep their
+// role, use SetRole to change it.
+func (ws *WorkspaceService) AddMember(ctx context.Context, workspaceID int, email string, role Role) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	var userID int
+	row := ws.DB.QueryRowContext(ctx, `
+		SELECT id FROM users WHERE email = $1;`, strings.ToLower(email))
+	err := row.Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("add workspace member: %w", err)
+	}
+
+	_, err = ws.DB.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		VALUES ($1, $2, $3) ON CONFLICT (workspace_id, user_id) DO NOTHING;`,
+		workspaceID, userID, role)
+	if err != nil {
+		return fmt.Errorf("add workspace member: %w", err)
+	}
+
+	return nil
+}
+
+// SetRole changes the role of a member, ErrLastOwner when that would leave
+// the workspace without an owner
+func (ws *WorkspaceService) SetRole(ctx context.Context, workspaceID, userID int, role Role) error {
+	return ws.changeMembers(ctx, workspaceID, "set workspace role", `
+		UPDATE workspace_members
+		SET role = $3
+		WHERE workspace_id = $1 AND user_id = $2;`, workspaceID, userID, role)
+}
+
+// RemoveMember removes a member from the workspace, ErrLastOwner when they
+// are its only owner. Galleries they own are no longer theirs to manage
+// once they leave, see CollaboratorService.Role.
+func (ws *WorkspaceService) RemoveMember(ctx context.Context, workspaceID, userID int) error {
+	return ws.changeMembers(ctx, workspaceID, "remove workspace member", `
+		DELETE FROM workspace_members
+		WHERE workspace_id = $1 AND user_id = $2;`, workspaceID, userID)
+}
+
+// changeMembers runs query and makes sure the workspace still has an owner
+// afterwards. The workspace row is locked so two owners demoting each other
+// at the same time can't both succeed.
+func (ws *WorkspaceService) changeMembers(ctx context.Context, workspaceID int, op, query string, args ...any) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	tx, err := ws.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		SELECT id FROM workspaces WHERE id = $1 FOR UPDATE;`, workspaceID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	err = expectRows(result, op)
+	if err != nil {
+		return err
+	}
+
+	var owners int
+	row := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM workspace_members
+		WHERE workspace_id = $1 AND role = $2;`, workspaceID, RoleOwner)
+	err = row.Scan(&owners)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package urls
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Builder generates absolute URLs for links that leave the app, eg. in emails
+// or OAuth redirects, so the public host is configured in one place.
+type Builder struct {
+	base *url.URL
+}
+
+// New validates rawURL and returns a Builder rooted at it. The base URL must
+// be an absolute http(s) URL without a query string or fragment.
+func New(rawURL string) (*Builder, error) {
+	base, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse base url: %w", err)
+	}
+
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("base url %q: scheme must be http or https", rawURL)
+	}
+	if base.Host == "" {
+		return nil, fmt.Errorf("base url %q: missing host", rawURL)
+	}
+	if base.RawQuery != "" || base.Fragment != "" {
+		return nil, fmt.Errorf("base url %q: must not contain a query or fragment", rawURL)
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+
+	return &Builder{base: base}, nil
+}
+
+// URL joins path onto the base URL and appends query if it isn't empty
+func (b *Builder) URL(path string, query url.Values) string {
+	u := *b.base
+	u.Path = b.base.Path + "/" + strings.TrimPrefix(path, "/")
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// Host returns the host (and port if set) of the base URL
+func (b *Builder) Host() string {
+	return b.base.Host
+}
+
+// Hostname returns the host of the base URL without the port
+func (b *Builder) Hostname() string {
+	return b.base.Hostname()
+}
+
+// String returns the base URL itself
+func (b *Builder) String() string {
+	return b.base.String()
+}
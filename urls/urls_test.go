@@ -0,0 +1,80 @@
+package urls
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := map[string]struct {
+		rawURL  string
+		wantErr bool
+		// want is the base URL after New cleaned it up
+		want string
+	}{
+		"http":           {rawURL: "http://localhost:3000", want: "http://localhost:3000"},
+		"https":          {rawURL: "https://photos.example.com", want: "https://photos.example.com"},
+		"trailing slash": {rawURL: "https://photos.example.com/", want: "https://photos.example.com"},
+		"path":           {rawURL: "https://example.com/photos/", want: "https://example.com/photos"},
+		"empty":          {rawURL: "", wantErr: true},
+		"no scheme":      {rawURL: "photos.example.com", wantErr: true},
+		"other scheme":   {rawURL: "ftp://photos.example.com", wantErr: true},
+		"no host":        {rawURL: "https:///photos", wantErr: true},
+		"query":          {rawURL: "https://photos.example.com/?a=b", wantErr: true},
+		"fragment":       {rawURL: "https://photos.example.com/#top", wantErr: true},
+		"invalid":        {rawURL: "https://photos example.com", wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, err := New(tc.rawURL)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("New(%q) err = nil, want an error", tc.rawURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New(%q) err = %v", tc.rawURL, err)
+			}
+			if got := b.String(); got != tc.want {
+				t.Errorf("String() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b, err := New("https://photos.example.com:8443/studio/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		path  string
+		query url.Values
+		want  string
+	}{
+		"path":          {path: "/galleries/1", want: "https://photos.example.com:8443/studio/galleries/1"},
+		"relative path": {path: "galleries/1", want: "https://photos.example.com:8443/studio/galleries/1"},
+		"root":          {path: "/", want: "https://photos.example.com:8443/studio/"},
+		"query": {
+			path:  "/reset-pw",
+			query: url.Values{"token": {"a b&c"}},
+			want:  "https://photos.example.com:8443/studio/reset-pw?token=a+b%26c",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := b.URL(tc.path, tc.query); got != tc.want {
+				t.Errorf("URL(%q) = %q, want %q", tc.path, got, tc.want)
+			}
+		})
+	}
+
+	if got, want := b.Host(), "photos.example.com:8443"; got != want {
+		t.Errorf("Host() = %q, want %q", got, want)
+	}
+	if got, want := b.Hostname(), "photos.example.com"; got != want {
+		t.Errorf("Hostname() = %q, want %q", got, want)
+	}
+}
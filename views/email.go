@@ -0,0 +1,128 @@
+package views
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	htmltemplate "html/template"
+	"io/fs"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/rahulbalajee/lenslocked/models"
+)
+
+const (
+	emailDir        = "emails"
+	emailHTMLLayout = "emails/layout.gohtml"
+	emailTextLayout = "emails/layout.txt"
+)
+
+// EmailTemplate renders a transactional email. Every email has an HTML
+// version and optionally a hand written plaintext version, when the latter is
+// missing we derive it from the HTML.
+type EmailTemplate struct {
+	htmltmpl *htmltemplate.Template
+	texttmpl *texttemplate.Template
+}
+
+func MustEmail(t EmailTemplate, err error) EmailTemplate {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseEmailFS parses emails/<name>.gohtml and, if it exists, emails/<name>.txt
+// along with the shared email layouts. The HTML file must define the "subject"
+// and "content" templates, the text file only needs "content".
+func ParseEmailFS(fsys fs.FS, name string) (EmailTemplate, error) {
+	htmlFile := fmt.Sprintf("%s/%s.gohtml", emailDir, name)
+	htmltmpl, err := htmltemplate.New(name).ParseFS(fsys, emailHTMLLayout, htmlFile)
+	if err != nil {
+		return EmailTemplate{}, fmt.Errorf("parsing email template %s: %w", name, err)
+	}
+
+	var texttmpl *texttemplate.Template
+	textFile := fmt.Sprintf("%s/%s.txt", emailDir, name)
+	_, err = fs.Stat(fsys, textFile)
+	switch {
+	case err == nil:
+		texttmpl, err = texttemplate.New(name).ParseFS(fsys, emailTextLayout, textFile)
+		if err != nil {
+			return EmailTemplate{}, fmt.Errorf("parsing email template %s: %w", name, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return EmailTemplate{}, fmt.Errorf("parsing email template %s: %w", name, err)
+	}
+
+	return EmailTemplate{htmltmpl: htmltmpl, texttmpl: texttmpl}, nil
+}
+
+// Render executes the template with data and returns an Email with the
+// Subject, HTML and Plaintext fields set
+func (t EmailTemplate) Render(data any) (models.Email, error) {
+	var email models.Email
+
+	var subject bytes.Buffer
+	err := t.htmltmpl.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return email, fmt.Errorf("rendering email subject: %w", err)
+	}
+	// The subject goes in a header, not in HTML, so undo html/template's escaping
+	email.Subject = strings.TrimSpace(html.UnescapeString(subject.String()))
+
+	var htmlBody bytes.Buffer
+	err = t.htmltmpl.ExecuteTemplate(&htmlBody, "layout", data)
+	if err != nil {
+		return email, fmt.Errorf("rendering email html: %w", err)
+	}
+	email.HTML = htmlBody.String()
+
+	if t.texttmpl == nil {
+		email.Plaintext = htmlToText(email.HTML)
+		return email, nil
+	}
+
+	var textBody bytes.Buffer
+	err = t.texttmpl.ExecuteTemplate(&textBody, "layout", data)
+	if err != nil {
+		return email, fmt.Errorf("rendering email plaintext: %w", err)
+	}
+	email.Plaintext = textBody.String()
+
+	return email, nil
+}
+
+var (
+	headRegexp     = regexp.MustCompile(`(?is)<head.*?</head>`)
+	linkRegexp     = regexp.MustCompile(`(?is)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	blockRegexp    = regexp.MustCompile(`(?i)<(br|/p|/tr|/h[1-6]|/li|/div)\s*/?>`)
+	tagRegexp      = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRegexp    = regexp.MustCompile(`[ \t]+`)
+	blankRegexp    = regexp.MustCompile(`\n\s*\n+`)
+	lineEndsRegexp = regexp.MustCompile(`(?m)^[ \t]+|[ \t]+$`)
+)
+
+// htmlToText is a best effort conversion used when an email has no plaintext
+// template. Links keep their target so they are still usable.
+func htmlToText(s string) string {
+	s = headRegexp.ReplaceAllString(s, "")
+	s = linkRegexp.ReplaceAllStringFunc(s, func(link string) string {
+		m := linkRegexp.FindStringSubmatch(link)
+		text := strings.TrimSpace(tagRegexp.ReplaceAllString(m[2], ""))
+		if text == "" || text == m[1] {
+			return m[1]
+		}
+		return fmt.Sprintf("%s (%s)", text, m[1])
+	})
+	s = blockRegexp.ReplaceAllString(s, "\n")
+	s = tagRegexp.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = spaceRegexp.ReplaceAllString(s, " ")
+	s = lineEndsRegexp.ReplaceAllString(s, "")
+	s = blankRegexp.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s) + "\n"
+}
@@ -0,0 +1,26 @@
+package views
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// Raw HTML is left out of the output and dangerous links (javascript: and
+// friends) are dropped since goldmark's unsafe mode is not enabled, so the
+// result can be trusted as template.HTML
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+)
+
+// markdown renders user written Markdown to HTML that is safe to embed
+func markdown(source string) (template.HTML, error) {
+	var buf bytes.Buffer
+	err := md.Convert([]byte(source), &buf)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
@@ -7,7 +7,7 @@ import (
 	"html/template"
 	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"net/http"
 	"path"
 
@@ -46,9 +46,16 @@ func ParseFS(fs fs.FS, tmplToExecute string, patterns ...string) (Template, erro
 			"currentUser": func() (*models.User, error) {
 				return nil, fmt.Errorf("currentUser not implemented")
 			},
+			"workspaces": func() ([]models.Workspace, error) {
+				return nil, fmt.Errorf("workspaces not implemented")
+			},
+			"currentWorkspace": func() (*models.Workspace, error) {
+				return nil, fmt.Errorf("currentWorkspace not implemented")
+			},
 			"errors": func() []string {
 				return nil
 			},
+			"markdown": markdown,
 		},
 	)
 
@@ -69,12 +76,12 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 	// Cloning gives each request its own isolated copy with the correct request-specific data
 	tmpl, err := t.htmltmpl.Clone()
 	if err != nil {
-		log.Printf("cloning template: %v", err)
+		context.Logger(r.Context()).Error("cloning template", "err", err)
 		http.Error(w, "There was an error rendering the page", http.StatusInternalServerError)
 		return
 	}
 
-	errMsgs := errMessages(errs...)
+	errMsgs := errMessages(context.Logger(r.Context()), errs...)
 
 	tmpl.Funcs(
 		template.FuncMap{
@@ -84,6 +91,12 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 			"currentUser": func() *models.User {
 				return context.User(r.Context())
 			},
+			"workspaces": func() []models.Workspace {
+				return context.Workspaces(r.Context())
+			},
+			"currentWorkspace": func() *models.Workspace {
+				return context.Workspace(r.Context())
+			},
 			"errors": func() []string {
 				return errMsgs
 			},
@@ -95,7 +108,7 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, data)
 	if err != nil {
-		log.Printf("executing template %v", err)
+		context.Logger(r.Context()).Error("executing template", "err", err)
 		http.Error(w, "Error executing template", http.StatusInternalServerError)
 		return
 	}
@@ -103,7 +116,9 @@ func (t Template) Execute(w http.ResponseWriter, r *http.Request, data any, errs
 	io.Copy(w, &buf)
 }
 
-func errMessages(errs ...error) []string {
+// errMessages turns errs into messages safe to show to the user. Errors
+// without a public message are logged since the user only sees a generic one.
+func errMessages(logger *slog.Logger, errs ...error) []string {
 	var msgs []string
 
 	for _, err := range errs {
@@ -111,7 +126,7 @@ func errMessages(errs ...error) []string {
 		if errors.As(err, &pubErr) {
 			msgs = append(msgs, pubErr.Public())
 		} else {
-			fmt.Println(err)
+			logger.Error("rendering page with error", "err", err)
 			msgs = append(msgs, "Something went wrong.")
 		}
 	}